@@ -0,0 +1,174 @@
+// Package card validates payment card details: the number's Luhn check
+// digit, the brand from its BIN range, brand-specific number and CVV lengths,
+// and the expiry date.
+package card
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Brand is a card network.
+type Brand string
+
+// Supported brands.
+const (
+	Visa       Brand = "visa"
+	Mastercard Brand = "mastercard"
+	Amex       Brand = "amex"
+	Discover   Brand = "discover"
+	RuPay      Brand = "rupay"
+	JCB        Brand = "jcb"
+	Diners     Brand = "diners"
+	UnionPay   Brand = "unionpay"
+	Maestro    Brand = "maestro"
+	Unknown    Brand = ""
+)
+
+var (
+	// ErrInvalidNumber is returned for card numbers that are not 12 to 19 digits.
+	ErrInvalidNumber = errors.New("invalid card number")
+	// ErrLuhnCheck is returned when the check digit is wrong, usually a typo.
+	ErrLuhnCheck = errors.New("invalid card number, check digit does not match")
+	// ErrUnsupportedBrand is returned for numbers outside every known BIN range.
+	ErrUnsupportedBrand = errors.New("unsupported card brand")
+	// ErrInvalidCVV is returned for a CVV of the wrong length for the brand.
+	ErrInvalidCVV = errors.New("invalid CVV")
+)
+
+// binRange is a range of issuer identification number prefixes, compared on
+// the first len(strconv.Itoa(low)) digits.
+type binRange struct {
+	low, high int
+}
+
+// brandRule describes one network's numbers.
+type brandRule struct {
+	brand     Brand
+	ranges    []binRange
+	lengths   []int
+	cvvLength int
+}
+
+// rules lists the networks. Ranges overlap (RuPay and Discover both use 65,
+// for instance), so Detect picks the rule with the longest matching prefix.
+var rules = []brandRule{
+	{Amex, []binRange{{34, 34}, {37, 37}}, []int{15}, 4},
+	{Visa, []binRange{{4, 4}}, []int{13, 16, 19}, 3},
+	{Mastercard, []binRange{{51, 55}, {2221, 2720}}, []int{16}, 3},
+	{RuPay, []binRange{{508500, 508999}, {606985, 607984}, {608001, 608500}, {652150, 653149}}, []int{16}, 3},
+	{Discover, []binRange{{6011, 6011}, {644, 649}, {65, 65}, {622126, 622925}}, []int{16, 17, 18, 19}, 3},
+	{JCB, []binRange{{3528, 3589}}, []int{16, 17, 18, 19}, 3},
+	{Diners, []binRange{{300, 305}, {36, 36}, {38, 39}}, []int{14, 15, 16, 17, 18, 19}, 3},
+	{UnionPay, []binRange{{62, 62}}, []int{16, 17, 18, 19}, 3},
+	{Maestro, []binRange{{5018, 5018}, {5020, 5020}, {5038, 5038}, {5893, 5893}, {6304, 6304}, {6759, 6759}, {6761, 6763}}, []int{12, 13, 14, 15, 16, 17, 18, 19}, 3},
+}
+
+// Normalize strips the spaces and dashes people type between digit groups.
+func Normalize(number string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(number))
+}
+
+// Luhn reports whether the number's last digit is a valid Luhn check digit.
+func Luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return len(number) > 0 && sum%10 == 0
+}
+
+// Detect returns the brand of a card number from its BIN, or Unknown.
+func Detect(number string) Brand {
+	rule := detect(number)
+	if rule == nil {
+		return Unknown
+	}
+	return rule.brand
+}
+
+// Validate checks a normalized card number and returns its brand.
+func Validate(number string) (Brand, error) {
+	if len(number) < 12 || len(number) > 19 || !isDigits(number) {
+		return Unknown, ErrInvalidNumber
+	}
+	if !Luhn(number) {
+		return Unknown, ErrLuhnCheck
+	}
+	rule := detect(number)
+	if rule == nil {
+		return Unknown, ErrUnsupportedBrand
+	}
+	for _, length := range rule.lengths {
+		if len(number) == length {
+			return rule.brand, nil
+		}
+	}
+	return rule.brand, errors.New("invalid card number length for " + string(rule.brand))
+}
+
+// ValidateCVV checks the CVV length for the brand: four digits for Amex,
+// three for the others. For an unknown brand either is accepted.
+func ValidateCVV(brand Brand, cvv string) error {
+	if !isDigits(cvv) {
+		return ErrInvalidCVV
+	}
+	for _, rule := range rules {
+		if rule.brand == brand {
+			if len(cvv) != rule.cvvLength {
+				return ErrInvalidCVV
+			}
+			return nil
+		}
+	}
+	if len(cvv) != 3 && len(cvv) != 4 {
+		return ErrInvalidCVV
+	}
+	return nil
+}
+
+func detect(number string) *brandRule {
+	var best *brandRule
+	bestDigits := 0
+	for i := range rules {
+		for _, r := range rules[i].ranges {
+			digits := len(strconv.Itoa(r.low))
+			if digits > len(number) || digits <= bestDigits {
+				continue
+			}
+			prefix, err := strconv.Atoi(number[:digits])
+			if err != nil {
+				return nil
+			}
+			if prefix >= r.low && prefix <= r.high {
+				best, bestDigits = &rules[i], digits
+			}
+		}
+	}
+	return best
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package card
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// withCheckDigit appends the Luhn check digit to a partial number, for BIN
+// ranges without a well-known test card.
+func withCheckDigit(partial string) string {
+	sum := 0
+	for i := len(partial) - 1; i >= 0; i-- {
+		d := int(partial[i] - '0')
+		if (len(partial)-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return partial + string(rune('0'+(10-sum%10)%10))
+}
+
+func TestLuhn(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"4111111111111111", true},
+		{"79927398713", true},
+		{"0", true},
+		{"4111111111111112", false},
+		{"79927398710", false},
+		{"", false},
+		{"4111a11111111111", false},
+		{"4111 1111 1111 1111", false}, // Not normalized
+	}
+	for _, tt := range tests {
+		if got := Luhn(tt.number); got != tt.want {
+			t.Errorf("Luhn(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+// errAny stands for any error in tables of expected errors.
+var errAny = errors.New("any error")
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		number    string
+		wantBrand Brand
+		wantErr   error // nil for valid numbers; errAny for any error
+	}{
+		{"4111111111111111", Visa, nil},
+		{"4012888888881881", Visa, nil},
+		{"4222222222222", Visa, nil},
+		{"5555555555554444", Mastercard, nil},
+		{"5105105105105100", Mastercard, nil},
+		{"2223003122003222", Mastercard, nil},
+		{"378282246310005", Amex, nil},
+		{"371449635398431", Amex, nil},
+		{"6011111111111117", Discover, nil},
+		{"6011000990139424", Discover, nil},
+		{withCheckDigit("650000000000000"), Discover, nil},
+		{withCheckDigit("652150000000000"), RuPay, nil}, // Inside Discover's 65, RuPay's longer prefix wins
+		{withCheckDigit("608100000000000"), RuPay, nil},
+		{"3530111333300000", JCB, nil},
+		{"3566002020360505", JCB, nil},
+		{"30569309025904", Diners, nil},
+		{"38520000023237", Diners, nil},
+		{"6200000000000005", UnionPay, nil},
+		{withCheckDigit("622126000000000"), Discover, nil}, // Discover's co-branded range inside UnionPay's 62
+		{withCheckDigit("67590000000"), Maestro, nil},
+
+		{"4111111111111112", Unknown, ErrLuhnCheck},
+		{"41111111111", Unknown, ErrInvalidNumber},          // Too short
+		{"41111111111111111111", Unknown, ErrInvalidNumber}, // Too long
+		{"4111-1111-1111-1111", Unknown, ErrInvalidNumber},
+		{"", Unknown, ErrInvalidNumber},
+		{withCheckDigit("999999999999999"), Unknown, ErrUnsupportedBrand},
+		{withCheckDigit("41111111111111"), Visa, errAny},  // 15 digits is not a Visa length
+		{withCheckDigit("378282246310000"), Amex, errAny}, // 16 digits is not an Amex length
+	}
+	for _, tt := range tests {
+		brand, err := Validate(tt.number)
+		switch {
+		case tt.wantErr == nil && err != nil:
+			t.Errorf("Validate(%q) failed: %v", tt.number, err)
+		case tt.wantErr == errAny && err == nil:
+			t.Errorf("Validate(%q) succeeded, want an error", tt.number)
+		case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+			t.Errorf("Validate(%q) error = %v, want %v", tt.number, err, tt.wantErr)
+		}
+		if brand != tt.wantBrand {
+			t.Errorf("Validate(%q) brand = %q, want %q", tt.number, brand, tt.wantBrand)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		number string
+		want   Brand
+	}{
+		{"4", Visa},
+		{"34", Amex},
+		{"37", Amex},
+		{"35", Unknown}, // JCB needs four digits to tell
+		{"3528", JCB},
+		{"2221", Mastercard},
+		{"2720", Mastercard},
+		{"2721", Unknown},
+		{"5018", Maestro}, // Not Mastercard, which starts at 51
+		{"6521", Discover},
+		{"652150", RuPay},
+		{"9", Unknown},
+		{"", Unknown},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.number); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize(" 4111 1111-1111 1111 "); got != "4111111111111111" {
+		t.Errorf("Normalize = %q, want 4111111111111111", got)
+	}
+}
+
+func TestValidateCVV(t *testing.T) {
+	tests := []struct {
+		brand Brand
+		cvv   string
+		valid bool
+	}{
+		{Visa, "123", true},
+		{Visa, "1234", false},
+		{Amex, "1234", true},
+		{Amex, "123", false},
+		{Unknown, "123", true},
+		{Unknown, "1234", true},
+		{Unknown, "12", false},
+		{Mastercard, "12a", false},
+		{Mastercard, "", false},
+	}
+	for _, tt := range tests {
+		err := ValidateCVV(tt.brand, tt.cvv)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateCVV(%q, %q) failed: %v", tt.brand, tt.cvv, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidCVV) {
+			t.Errorf("ValidateCVV(%q, %q) = %v, want ErrInvalidCVV", tt.brand, tt.cvv, err)
+		}
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		expiry    string
+		wantMonth time.Month
+		wantYear  int
+		wantErr   bool
+	}{
+		{"12/30", time.December, 2030, false},
+		{"01/00", time.January, 2000, false},
+		{"00/30", 0, 0, true},
+		{"13/30", 0, 0, true},
+		{"1/30", 0, 0, true},
+		{"12/2030", 0, 0, true},
+		{"12-30", 0, 0, true},
+		{" 2/30", 0, 0, true},
+		{"ab/cd", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		month, year, err := ParseExpiry(tt.expiry)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidExpiry) {
+				t.Errorf("ParseExpiry(%q) = %v, want ErrInvalidExpiry", tt.expiry, err)
+			}
+			continue
+		}
+		if err != nil || month != tt.wantMonth || year != tt.wantYear {
+			t.Errorf("ParseExpiry(%q) = %v %d, %v; want %v %d", tt.expiry, month, year, err, tt.wantMonth, tt.wantYear)
+		}
+	}
+}
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expiry  string
+		now     time.Time
+		wantErr error
+	}{
+		{"10/26", now, nil}, // Valid through the end of the month
+		{"10/26", time.Date(2026, time.October, 31, 23, 59, 59, 0, time.UTC), nil},
+		{"10/26", time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC), ErrExpired},
+		{"09/26", now, ErrExpired},
+		{"12/25", now, ErrExpired},
+		{"01/27", now, nil},
+		{"12/12", time.Date(2012, time.December, 31, 0, 0, 0, 0, time.UTC), nil}, // Month rolls into the next year
+		{"09/46", now, nil},              // Just inside the validity limit
+		{"12/46", now, ErrInvalidExpiry}, // Too far ahead
+		{"13/26", now, ErrInvalidExpiry},
+	}
+	for _, tt := range tests {
+		err := CheckExpiry(tt.expiry, tt.now)
+		if tt.wantErr == nil && err != nil {
+			t.Errorf("CheckExpiry(%q, %s) failed: %v", tt.expiry, tt.now.Format(time.DateOnly), err)
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("CheckExpiry(%q, %s) = %v, want %v", tt.expiry, tt.now.Format(time.DateOnly), err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package card
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidExpiry is returned for expiry dates not in MM/YY form.
+	ErrInvalidExpiry = errors.New("invalid expiry date, format should be MM/YY")
+	// ErrExpired is returned for cards past their expiry month.
+	ErrExpired = errors.New("card has expired")
+)
+
+// maxValidityYears bounds how far ahead an expiry date may be; issuers do
+// not go beyond this, so a later date is a typo.
+const maxValidityYears = 20
+
+// ParseExpiry parses an MM/YY expiry date.
+func ParseExpiry(expiry string) (month time.Month, year int, err error) {
+	var mm, yy int
+	if len(expiry) != 5 || expiry[2] != '/' || !isDigits(expiry[:2]) || !isDigits(expiry[3:]) {
+		return 0, 0, ErrInvalidExpiry
+	}
+	if _, err := fmt.Sscanf(expiry, "%02d/%02d", &mm, &yy); err != nil || mm < 1 || mm > 12 {
+		return 0, 0, ErrInvalidExpiry
+	}
+	return time.Month(mm), 2000 + yy, nil
+}
+
+// CheckExpiry returns an error if the card is expired at now. Cards are
+// valid through the last day of their expiry month.
+func CheckExpiry(expiry string, now time.Time) error {
+	month, year, err := ParseExpiry(expiry)
+	if err != nil {
+		return err
+	}
+	// The first moment after the expiry month
+	end := time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+	if !now.Before(end) {
+		return ErrExpired
+	}
+	if end.After(now.AddDate(maxValidityYears, 0, 0)) {
+		return ErrInvalidExpiry
+	}
+	return nil
+}
@@ -1,9 +1,15 @@
 package controller
 
 import (
+	"errors"
+	"html/template"
+	"log"
+	"math"
 	"net/http"
+	"poc/model"
 	"poc/services"
-	"poc/utils"
+	"strconv"
+	"time"
 
 	"github.com/kataras/iris/v12"
 )
@@ -11,6 +17,8 @@ import (
 // UserController handles HTTP requests for user operations.
 type UserController struct {
 	UserService *services.UserService
+	MFAService  *services.MFAService
+	Throttle    *services.LoginThrottle
 }
 
 // Signup handles user registration (signup).
@@ -58,33 +66,218 @@ func (uc *UserController) Login(ctx iris.Context) {
 		return
 	}
 
+	// Refuse early while the account or client IP is backing off or locked out
+	account, ip := services.AccountKeyForEmail(req.Email), ctx.RemoteAddr()
+	if err := uc.Throttle.Check(ctx.Request().Context(), account, ip); err != nil {
+		respondLoginError(ctx, err)
+		return
+	}
+
 	// Call the UserService to verify the credentials
 	user, err := uc.UserService.LoginUser(ctx.Request().Context(), req.Email, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			if err := uc.Throttle.RecordFailure(ctx.Request().Context(), account, ip, ""); err != nil {
+				log.Printf("Failed to record login failure: %v", err)
+			}
+		}
+		respondLoginError(ctx, err)
+		return
+	}
+	if err := uc.Throttle.RecordSuccess(ctx.Request().Context(), account); err != nil {
+		log.Printf("Failed to reset login attempts: %v", err)
+	}
+
+	// With MFA enabled the password only earns a challenge for the second step
+	mfaEnabled, err := uc.MFAService.IsEnabled(ctx.Request().Context(), user.UserID)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+	if mfaEnabled {
+		challenge, err := uc.MFAService.BeginLogin(user.UserID)
+		if err != nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			ctx.JSON(map[string]string{"error": err.Error()})
+			return
+		}
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"message":      "Submit a code from your authenticator app to /login/mfa",
+		})
+		return
+	}
+
+	// Start a new session with a short-lived access token and a refresh token
+	tokens, err := uc.UserService.Tokens.IssueTokens(ctx.Request().Context(), user.UserID)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Respond with user data and the generated tokens
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]interface{}{
+		"user":          user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    tokens.TokenType,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+// LoginMFA completes a two-step login with the challenge from Login and a
+// TOTP or recovery code.
+func (uc *UserController) LoginMFA(ctx iris.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	// Decode the incoming request
+	if err := ctx.ReadJSON(&req); err != nil || req.MFAToken == "" {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "mfa_token and code are required"})
+		return
+	}
+
+	// Codes are throttled like passwords, per user and per client IP
+	userID, err := uc.MFAService.ChallengeUser(req.MFAToken)
 	if err != nil {
 		ctx.StatusCode(http.StatusUnauthorized)
 		ctx.JSON(map[string]string{"error": err.Error()})
 		return
 	}
+	account, ip := services.AccountKeyForUser(userID), ctx.RemoteAddr()
+	if err := uc.Throttle.Check(ctx.Request().Context(), account, ip); err != nil {
+		respondLoginError(ctx, err)
+		return
+	}
 
-	// Generate a JWT or session token
-	token, err := utils.GenerateToken(user.UserID)
+	if _, err := uc.MFAService.CompleteLogin(ctx.Request().Context(), req.MFAToken, req.Code); err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrMFARequired) {
+			if err := uc.Throttle.RecordFailure(ctx.Request().Context(), account, ip, userID); err != nil {
+				log.Printf("Failed to record login failure: %v", err)
+			}
+		}
+		respondLoginError(ctx, err)
+		return
+	}
+	if err := uc.Throttle.RecordSuccess(ctx.Request().Context(), account); err != nil {
+		log.Printf("Failed to reset login attempts: %v", err)
+	}
+
+	tokens, err := uc.UserService.Tokens.IssueTokens(ctx.Request().Context(), userID)
 	if err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Respond with user data and the generated token
 	ctx.StatusCode(http.StatusOK)
 	ctx.JSON(map[string]interface{}{
-		"user":  user,
-		"token": token,
+		"user_id":       userID,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    tokens.TokenType,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
-// Logout handles user logout.
+// UnlockAccount lifts a login lockout. Admin and support only.
+func (uc *UserController) UnlockAccount(ctx iris.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	// Decode the incoming request
+	if err := ctx.ReadJSON(&req); err != nil || req.Email == "" {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "email is required"})
+		return
+	}
+
+	if err := uc.Throttle.Unlock(ctx.Request().Context(), req.Email, ctx.Values().GetString("UserID")); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]string{"message": "Account unlocked"})
+}
+
+// respondLoginError maps login failures to status codes, adding Retry-After
+// while the caller is throttled.
+func respondLoginError(ctx iris.Context, err error) {
+	var blocked *services.LoginBlockedError
+	switch {
+	case errors.As(err, &blocked):
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+		ctx.StatusCode(http.StatusTooManyRequests)
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrMFARequired):
+		ctx.StatusCode(http.StatusUnauthorized)
+	default:
+		ctx.StatusCode(http.StatusInternalServerError)
+	}
+	ctx.JSON(map[string]string{"error": err.Error()})
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh token pair.
+func (uc *UserController) RefreshToken(ctx iris.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// Decode the incoming request
+	if err := ctx.ReadJSON(&req); err != nil || req.RefreshToken == "" {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "refresh_token is required"})
+		return
+	}
+
+	tokens, err := uc.UserService.Tokens.Refresh(ctx.Request().Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			ctx.StatusCode(http.StatusUnauthorized)
+		} else {
+			ctx.StatusCode(http.StatusInternalServerError)
+		}
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(tokens)
+}
+
+// Logout handles user logout. By default only the current session ends;
+// "all_sessions": true logs the user out everywhere.
 func (uc *UserController) Logout(ctx iris.Context) {
-	// Invalidate session or token here
+	var req struct {
+		AllSessions bool `json:"all_sessions"`
+	}
+	if ctx.GetContentLength() > 0 {
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	// Revoke the session's refresh tokens and the access token in use
+	expiresAt, _ := ctx.Values().Get("TokenExpiresAt").(time.Time)
+	err := uc.UserService.LogoutUser(ctx.Request().Context(), ctx.Values().GetString("UserID"),
+		ctx.Values().GetString("SessionID"), ctx.Values().GetString("TokenID"), expiresAt, req.AllSessions)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
 
 	// Respond with success message
 	ctx.StatusCode(http.StatusOK)
@@ -93,6 +286,173 @@ func (uc *UserController) Logout(ctx iris.Context) {
 	})
 }
 
+// VerifyEmail confirms the user's email address from the emailed link.
+func (uc *UserController) VerifyEmail(ctx iris.Context) {
+	token := ctx.URLParam("token")
+	if token == "" {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "token is required"})
+		return
+	}
+
+	user, err := uc.UserService.VerifyEmail(ctx.Request().Context(), token)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]interface{}{
+		"message":     "Email verified successfully",
+		"email":       user.Email,
+		"is_verified": user.IsVerified,
+	})
+}
+
+// ResendVerificationEmail sends the authenticated user a new verification link.
+func (uc *UserController) ResendVerificationEmail(ctx iris.Context) {
+	userID := ctx.Values().GetString("UserID")
+	if err := uc.UserService.ResendVerificationEmail(ctx.Request().Context(), userID); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusAccepted)
+	ctx.JSON(map[string]string{"message": "Verification email sent"})
+}
+
+// ForgotPassword emails a password reset link. It always answers the same way
+// so callers cannot probe which emails have accounts.
+func (uc *UserController) ForgotPassword(ctx iris.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	// Decode the incoming request
+	if err := ctx.ReadJSON(&req); err != nil || req.Email == "" {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "email is required"})
+		return
+	}
+
+	if err := uc.UserService.ForgotPassword(ctx.Request().Context(), req.Email); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusAccepted)
+	ctx.JSON(map[string]string{"message": "If the email is registered, a reset link has been sent"})
+}
+
+// resetPasswordPage is the page the emailed reset link opens. It posts the
+// token from the link together with the new password to POST /password/reset.
+var resetPasswordPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset your password</title></head>
+<body>
+<h1>Reset your password</h1>
+<form id="reset">
+<input type="hidden" id="token" value="{{.}}">
+<label>New password <input type="password" id="new_password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+<p id="result"></p>
+<script>
+document.getElementById("reset").addEventListener("submit", async function (event) {
+	event.preventDefault();
+	const response = await fetch("/password/reset", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({
+			token: document.getElementById("token").value,
+			new_password: document.getElementById("new_password").value
+		})
+	});
+	const body = await response.json();
+	document.getElementById("result").textContent = body.message || body.error;
+});
+</script>
+</body>
+</html>
+`))
+
+// ResetPasswordForm serves the page behind the emailed reset link. Opening it
+// does not use up the token; submitting the form does.
+func (uc *UserController) ResetPasswordForm(ctx iris.Context) {
+	token := ctx.URLParam("token")
+	if token == "" {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "token is required"})
+		return
+	}
+
+	// Keep the token out of caches and Referer headers
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Referrer-Policy", "no-referrer")
+	ctx.ContentType("text/html; charset=utf-8")
+	ctx.StatusCode(http.StatusOK)
+	if err := resetPasswordPage.Execute(ctx.ResponseWriter(), token); err != nil {
+		log.Printf("Failed to render password reset page: %v", err)
+	}
+}
+
+// ResetPassword sets a new password using the token from a reset link.
+func (uc *UserController) ResetPassword(ctx iris.Context) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	// Decode the incoming request
+	if err := ctx.ReadJSON(&req); err != nil || req.Token == "" {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "token and new_password are required"})
+		return
+	}
+
+	if err := uc.UserService.ResetPassword(ctx.Request().Context(), req.Token, req.NewPassword); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]string{"message": "Password reset successfully. Please log in again."})
+}
+
+// ChangePassword changes the authenticated user's password.
+func (uc *UserController) ChangePassword(ctx iris.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	// Decode the incoming request
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	err := uc.UserService.ChangePassword(ctx.Request().Context(), ctx.Values().GetString("UserID"), req.CurrentPassword, req.NewPassword)
+	var blocked *services.LoginBlockedError
+	if errors.As(err, &blocked) {
+		respondLoginError(ctx, err)
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]string{"message": "Password changed successfully. Please log in again."})
+}
+
 // UpdateUser updates user details.
 func (uc *UserController) UpdateUser(ctx iris.Context) {
 	// userID := ctx.Params().Get("id")
@@ -101,8 +461,8 @@ func (uc *UserController) UpdateUser(ctx iris.Context) {
 		Email     string `json:"email"`
 		FirstName string `json:"first_name"`
 		LastName  string `json:"last_name"`
-		isPayee   bool   `json:"isPayee"`
-		isPayer   bool   `json:"isPayer"`
+		IsPayee   bool   `json:"isPayee"`
+		IsPayer   bool   `json:"isPayer"`
 	}
 
 	// Decode the incoming request
@@ -113,7 +473,7 @@ func (uc *UserController) UpdateUser(ctx iris.Context) {
 	}
 
 	// Call the service to update the user
-	err := uc.UserService.UpdateUser(ctx, userID, req.Email, req.FirstName, req.LastName, req.isPayee, req.isPayer)
+	err := uc.UserService.UpdateUser(ctx, userID, req.Email, req.FirstName, req.LastName, req.IsPayee, req.IsPayer)
 	if err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(map[string]string{"error": err.Error()})
@@ -124,14 +484,16 @@ func (uc *UserController) UpdateUser(ctx iris.Context) {
 	ctx.JSON(map[string]string{"message": "User updated successfully"})
 }
 
-// UpdatePayer updates payer-specific details like balance.
+// balanceAdjustmentRequest is the body of the admin balance adjustment routes.
+type balanceAdjustmentRequest struct {
+	Balance model.Money `json:"balance"` // New balance to set
+	Reason  string      `json:"reason"`  // Why the balance is being changed; required
+}
+
+// UpdatePayer sets a payer's balance. Admin only; a reason is required.
 func (uc *UserController) UpdatePayer(ctx iris.Context) {
-	var req struct {
-		PayerID string  `json:"payer_id"`
-		Amount  float64 `json:"amount"`
-	}
+	var req balanceAdjustmentRequest
 
-	// fmt.Println("req ", req.PayerID, req.Amount, req)
 	// Decode the incoming request
 	if err := ctx.ReadJSON(&req); err != nil {
 		ctx.StatusCode(http.StatusBadRequest)
@@ -140,9 +502,9 @@ func (uc *UserController) UpdatePayer(ctx iris.Context) {
 	}
 
 	// Call the service to update the payer
-	err := uc.UserService.UpdatePayer(ctx, req.PayerID, req.Amount)
+	err := uc.UserService.UpdatePayer(ctx, ctx.Params().GetString("payerID"), req.Balance, req.Reason, ctx.Values().GetString("UserID"))
 	if err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.StatusCode(http.StatusBadRequest)
 		ctx.JSON(map[string]string{"error": err.Error()})
 		return
 	}
@@ -151,16 +513,33 @@ func (uc *UserController) UpdatePayer(ctx iris.Context) {
 	ctx.JSON(map[string]string{"message": "Payer updated successfully"})
 }
 
-// UpdatePayee updates payee-specific details like balance.
+// UpdatePayee sets a payee's balance. Admin only; a reason is required.
 func (uc *UserController) UpdatePayee(ctx iris.Context) {
-	// payeeID := ctx.Params().Get("id")
-	// var req struct {
-	// 	Balance float64 `json:"balance"`
-	// }
+	var req balanceAdjustmentRequest
+
+	// Decode the incoming request
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Call the service to update the payee
+	err := uc.UserService.UpdatePayee(ctx, ctx.Params().GetString("payeeID"), req.Balance, req.Reason, ctx.Values().GetString("UserID"))
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]string{"message": "Payee updated successfully"})
+}
 
+// SetRole grants a user a role. Admin only.
+func (uc *UserController) SetRole(ctx iris.Context) {
 	var req struct {
-		PayeeID string  `json:"payee_id"`
-		Amount  float64 `json:"amount"`
+		Role string `json:"role"`
 	}
 
 	// Decode the incoming request
@@ -170,14 +549,13 @@ func (uc *UserController) UpdatePayee(ctx iris.Context) {
 		return
 	}
 
-	// Call the service to update the payee
-	err := uc.UserService.UpdatePayee(ctx, req.PayeeID, req.Amount)
+	err := uc.UserService.SetRole(ctx.Request().Context(), ctx.Params().GetString("userID"), req.Role, ctx.Values().GetString("UserID"))
 	if err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.StatusCode(http.StatusBadRequest)
 		ctx.JSON(map[string]string{"error": err.Error()})
 		return
 	}
 
 	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(map[string]string{"message": "Payee updated successfully"})
+	ctx.JSON(map[string]string{"message": "Role updated successfully"})
 }
@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"poc/utils"
+
+	"github.com/kataras/iris/v12"
+)
+
+// JWKSHandler publishes the public keys that verify tokens issued by this
+// service, so other services can validate them without sharing secrets.
+func JWKSHandler(ctx iris.Context) {
+	ring, err := utils.CurrentKeyRing()
+	if err != nil {
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(ring.JWKS())
+}
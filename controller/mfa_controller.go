@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"poc/services"
+
+	"github.com/kataras/iris/v12"
+)
+
+// MFAController handles TOTP enrollment and recovery codes.
+type MFAController struct {
+	MFAService *services.MFAService
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Enroll starts TOTP enrollment and returns the secret to add to an authenticator app.
+func (mc *MFAController) Enroll(ctx iris.Context) {
+	secret, uri, err := mc.MFAService.Enroll(ctx.Request().Context(), ctx.Values().GetString("UserID"))
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"message":     "Add the secret to your authenticator app, then confirm with a code",
+	})
+}
+
+// Confirm finishes enrollment with a first code and returns the recovery codes.
+func (mc *MFAController) Confirm(ctx iris.Context) {
+	var req mfaCodeRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	codes, err := mc.MFAService.ConfirmEnrollment(ctx.Request().Context(), ctx.Values().GetString("UserID"), req.Code)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]interface{}{
+		"message":        "Multi-factor authentication enabled. Store the recovery codes somewhere safe; they are shown only once.",
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns MFA off; it needs a current code or recovery code.
+func (mc *MFAController) Disable(ctx iris.Context) {
+	var req mfaCodeRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := mc.MFAService.Disable(ctx.Request().Context(), ctx.Values().GetString("UserID"), req.Code); err != nil {
+		ctx.StatusCode(mfaErrorStatus(err))
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]string{"message": "Multi-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes; it needs a current code.
+func (mc *MFAController) RegenerateRecoveryCodes(ctx iris.Context) {
+	var req mfaCodeRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	codes, err := mc.MFAService.RegenerateRecoveryCodes(ctx.Request().Context(), ctx.Values().GetString("UserID"), req.Code)
+	if err != nil {
+		ctx.StatusCode(mfaErrorStatus(err))
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(map[string]interface{}{"recovery_codes": codes})
+}
+
+func mfaErrorStatus(err error) int {
+	var blocked *services.LoginBlockedError
+	switch {
+	case errors.As(err, &blocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, services.ErrMFARequired), errors.Is(err, services.ErrInvalidMFACode):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrMFANotEnrolled):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
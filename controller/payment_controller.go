@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"poc/model"
@@ -12,9 +15,8 @@ import (
 // PaymentMethodRequest represents the request payload for creating a payment method
 type PaymentMethodRequest struct {
 	MethodType    string `json:"method_type" validate:"required,oneof=card bank_transfer upi wallet cheque"`
-	CardNumber    string `json:"card_number,omitempty" validate:"required_if=MethodType card,len=16"`
+	CardNumber    string `json:"card_number,omitempty" validate:"required_if=MethodType card"`
 	ExpiryDate    string `json:"expiry_date,omitempty" validate:"required_if=MethodType card"`
-	Status        string `json:"status" validate:"required,oneof=active inactive"`
 	Details       string `json:"details" validate:"required"`
 	AccountNumber string `json:"account_number,omitempty" validate:"required_if=MethodType bank_transfer"`
 }
@@ -46,24 +48,22 @@ func CreatePaymentMethodHandler(svc *services.PaymentMethodService, ctx iris.Con
 		MethodType:    paymentMethodRequest.MethodType,
 		CardNumber:    paymentMethodRequest.CardNumber,
 		ExpiryDate:    paymentMethodRequest.ExpiryDate,
-		Status:        paymentMethodRequest.Status,
 		AccountNumber: paymentMethodRequest.AccountNumber,
 		Details:       paymentMethodRequest.Details,
 	}
 
-	fmt.Println(paymentMethod, " = paymentMethod")
-
-	// Call the service to create the payment method
-	if err := svc.CreatePaymentMethod(paymentMethod); err != nil {
-		log.Printf("Error creating payment method: %v", err)
+	// Call the service to create the payment method; numbers go to the vault
+	created, err := svc.CreatePaymentMethod(ctx.Request().Context(), paymentMethod)
+	if err != nil {
+		log.Printf("Error creating payment method for payer %s: %v", payerID, err)
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Respond with success
+	// Respond with success; only masked values are returned
 	ctx.StatusCode(iris.StatusCreated)
-	ctx.JSON(map[string]string{"message": "Payment method created successfully"})
+	ctx.JSON(iris.Map{"message": "Payment method created successfully", "payment_method": created})
 }
 
 // GetPaymentMethodsHandler handles fetching payment methods for a specific payer
@@ -171,44 +171,109 @@ func GetPaymentMethodHandler(svc *services.PaymentMethodService, ctx iris.Contex
 }
 */
 
-// ============= ********** ====================//
-// Need to work on it later
-
-// UpdatePaymentMethodHandler handles updating a payment method
+// UpdatePaymentMethodHandler handles updating one of the user's payment
+// methods. Only the fields in model.PaymentMethodUpdate may be sent.
 func UpdatePaymentMethodHandler(svc *services.PaymentMethodService, ctx iris.Context) {
 	paymentMethodID := ctx.Params().GetString("paymentMethodID")
-	var updates map[string]interface{}
+	payerID := ctx.Values().GetString("UserID")
 
-	// Bind the JSON request to the updates map
-	if err := ctx.ReadJSON(&updates); err != nil {
+	// Unknown fields such as payer_id or card_token are rejected, not ignored
+	var update model.PaymentMethodUpdate
+	body, err := ctx.GetBody()
+	if err != nil {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.JSON(iris.Map{"error": "Invalid request body"})
 		return
 	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
 
 	// Call the service to update the payment method
-	if err := svc.UpdatePaymentMethod(paymentMethodID, updates); err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": err.Error()})
+	paymentMethod, err := svc.UpdatePaymentMethod(ctx.Request().Context(), payerID, paymentMethodID, update)
+	if err != nil {
+		respondPaymentMethodError(ctx, err)
 		return
 	}
 
 	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(iris.Map{"message": "Payment method updated successfully"})
+	ctx.JSON(iris.Map{"message": "Payment method updated successfully", "payment_method": paymentMethod})
 }
 
-// ValidatePaymentMethodHandler handles validating a payment method
+// ValidatePaymentMethodHandler handles validating one of the user's payment methods
 func ValidatePaymentMethodHandler(svc *services.PaymentMethodService, ctx iris.Context) {
 	paymentMethodID := ctx.Params().GetString("paymentMethodID")
 
 	// Call the service to validate the payment method
-	paymentMethod, err := svc.ValidatePaymentMethod(paymentMethodID)
+	paymentMethod, err := svc.ValidatePaymentMethod(ctx.Values().GetString("UserID"), paymentMethodID)
 	if err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(iris.Map{"error": err.Error()})
+		respondPaymentMethodError(ctx, err)
 		return
 	}
 
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(iris.Map{"message": "Payment method is valid", "payment_method": paymentMethod})
 }
+
+// SetDefaultPaymentMethodHandler makes one of the user's payment methods the
+// one used when a payment does not name a method.
+func SetDefaultPaymentMethodHandler(svc *services.PaymentMethodService, ctx iris.Context) {
+	paymentMethod, err := svc.SetDefaultPaymentMethod(ctx.Values().GetString("UserID"), ctx.Params().GetString("paymentMethodID"))
+	if err != nil {
+		respondPaymentMethodError(ctx, err)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(iris.Map{"message": "Default payment method updated", "payment_method": paymentMethod})
+}
+
+// VerifyBankAccountHandler confirms the micro-deposit amounts sent to one of
+// the user's bank accounts
+func VerifyBankAccountHandler(svc *services.PaymentMethodService, ctx iris.Context) {
+	var req struct {
+		Amounts []model.Money `json:"amounts"`
+	}
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid request body"})
+		return
+	}
+
+	paymentMethod, err := svc.VerifyBankAccount(ctx.Request().Context(), ctx.Values().GetString("UserID"), ctx.Params().GetString("paymentMethodID"), req.Amounts)
+	if err != nil {
+		respondPaymentMethodError(ctx, err)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(iris.Map{"message": "Bank account verified", "payment_method": paymentMethod})
+}
+
+// DeletePaymentMethodHandler removes one of the user's payment methods
+func DeletePaymentMethodHandler(svc *services.PaymentMethodService, ctx iris.Context) {
+	err := svc.RemovePaymentMethod(ctx.Request().Context(), ctx.Values().GetString("UserID"), ctx.Params().GetString("paymentMethodID"))
+	if err != nil {
+		respondPaymentMethodError(ctx, err)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(iris.Map{"message": "Payment method removed"})
+}
+
+// respondPaymentMethodError maps payment method service errors to responses.
+func respondPaymentMethodError(ctx iris.Context, err error) {
+	if errors.Is(err, services.ErrPaymentMethodNotFound) {
+		ctx.StatusCode(iris.StatusNotFound)
+	} else if errors.Is(err, services.ErrPaymentMethodInUse) {
+		ctx.StatusCode(iris.StatusConflict)
+	} else {
+		ctx.StatusCode(iris.StatusBadRequest)
+	}
+	ctx.JSON(iris.Map{"error": err.Error()})
+}
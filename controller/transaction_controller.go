@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"poc/model"
 	"poc/services"
+	"strings"
+	"time"
 
 	"github.com/kataras/iris/v12"
 )
@@ -28,28 +31,53 @@ func CreateTransactionHandler(svc *services.TransactionService, ctx iris.Context
 		return
 	}
 
-	fmt.Println("pay detail", req.PaymentDetails.CardNumber, "status", req.Status,
-		"payment method id", req.PaymentDetails.ExpiryDate, "transaction id", req.PaymentDetails.CVV)
+	// Credits are funded by the platform's settlement account, so only
+	// finance staff may issue them
+	if strings.EqualFold(req.TransactionType, "Credit") {
+		role := ctx.Values().GetString("Role")
+		if role != model.RoleAdmin && role != model.RoleFinance {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.JSON(map[string]string{"error": "Only finance staff can issue credits"})
+			return
+		}
+	}
 
 	// Create the transaction
-	reservedAmount := 0.0
-	transaction, err := svc.InitializeTransaction(ctx, payerId, req.PayeeID, req.Amount, req.TransactionType, req.Status, reservedAmount, req.PaymentMethodID, req.PaymentDetails)
+	reservedAmount := model.ZeroMoney(req.Amount.Currency)
+	transaction, err := svc.InitializeTransaction(ctx, payerId, req.PayeeID, req.Amount, req.TransactionType, reservedAmount, req.PaymentMethodID, req.PaymentDetails, ctx.Values().GetString("IdempotencyKey"))
 	if err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.StatusCode(processorErrorStatus(err, iris.StatusInternalServerError))
 		ctx.JSON(map[string]string{"error": err.Error()})
 		return
 	}
 
+	message := "Transaction Completed Successfully."
+	if transaction.Status == model.StatusPending {
+		message = "Cheque deposited; funds are paid once it clears."
+	}
+
 	ctx.StatusCode(iris.StatusCreated)
 	// ctx.JSON(transaction)
 	ctx.JSON(iris.Map{
 		"transaction_id": transaction.TransactionID,
 		"status":         transaction.Status,
-		"message":        "Transaction Completed Successfully.",
+		"message":        message,
 	})
 }
 
-// ListTransactionsHandler lists all transactions for the authenticated user
+// processorErrorStatus maps a payment processor's refusal to a status code:
+// 402 for declines and 3-D Secure challenges, 504 for timeouts.
+func processorErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, services.ErrPaymentDeclined), errors.Is(err, services.ErrAuthenticationRequired):
+		return iris.StatusPaymentRequired
+	case errors.Is(err, services.ErrProcessorTimeout):
+		return iris.StatusGatewayTimeout
+	}
+	return fallback
+}
+
+// ListTransactionsHandler lists the authenticated user's transactions a page at a time
 func ListTransactionsHandler(svc *services.TransactionService, ctx iris.Context) {
 	// Extract authenticated user's ID
 	userID := ctx.Values().GetString("UserID")
@@ -59,13 +87,397 @@ func ListTransactionsHandler(svc *services.TransactionService, ctx iris.Context)
 		return
 	}
 
+	filter, err := parseTransactionFilter(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
 	// Fetch transactions
-	// transactions, err := svc.ListTransactions(ctx.Request().Context(), userID)
-	// if err != nil {
-	// 	ctx.StatusCode(iris.StatusInternalServerError)
-	// 	ctx.JSON(map[string]string{"error": err.Error()})
-	// 	return
-	// }
-
-	ctx.JSON(nil)
+	page, err := svc.ListTransactions(ctx.Request().Context(), userID, filter)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			ctx.StatusCode(iris.StatusBadRequest)
+		} else {
+			ctx.StatusCode(iris.StatusInternalServerError)
+		}
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(page)
+}
+
+// GetTransactionHandler returns one of the authenticated user's transactions with its status history
+func GetTransactionHandler(svc *services.TransactionService, ctx iris.Context) {
+	userID := ctx.Values().GetString("UserID")
+	if userID == "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]string{"error": "User not authenticated"})
+		return
+	}
+
+	transaction, err := svc.GetTransactionByID(ctx.Request().Context(), ctx.Params().GetString("transactionID"), userID)
+	if err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	history, err := svc.GetStatusHistory(ctx.Request().Context(), transaction.TransactionID)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(iris.Map{
+		"transaction":    transaction,
+		"status_history": history,
+	})
+}
+
+// parseTransactionFilter reads the history filters from the query string.
+// Dates accept RFC 3339 or YYYY-MM-DD; a date-only "to" includes that whole day.
+// Amounts are decimal strings in the "currency" parameter (default INR).
+func parseTransactionFilter(ctx iris.Context) (services.TransactionFilter, error) {
+	filter := services.TransactionFilter{
+		TransactionType: ctx.URLParam("type"),
+		Counterparty:    ctx.URLParam("counterparty"),
+		PaymentMethodID: ctx.URLParam("payment_method_id"),
+		Cursor:          ctx.URLParam("cursor"),
+	}
+
+	if value := ctx.URLParam("from"); value != "" {
+		from, _, err := parseFilterTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %v", err)
+		}
+		filter.From = &from
+	}
+	if value := ctx.URLParam("to"); value != "" {
+		to, dateOnly, err := parseFilterTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %v", err)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
+
+	if value := ctx.URLParam("status"); value != "" {
+		for _, s := range strings.Split(value, ",") {
+			status := model.TransactionStatus(strings.TrimSpace(s))
+			if !status.IsKnown() {
+				return filter, fmt.Errorf("unknown status %q", string(status))
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	currency := ctx.URLParamDefault("currency", model.DefaultCurrency)
+	if value := ctx.URLParam("min_amount"); value != "" {
+		amount, err := model.ParseMoney(value, currency)
+		if err != nil {
+			return filter, fmt.Errorf("invalid min_amount: %v", err)
+		}
+		filter.MinAmount = &amount
+	}
+	if value := ctx.URLParam("max_amount"); value != "" {
+		amount, err := model.ParseMoney(value, currency)
+		if err != nil {
+			return filter, fmt.Errorf("invalid max_amount: %v", err)
+		}
+		filter.MaxAmount = &amount
+	}
+
+	if value := ctx.URLParam("sort"); value != "" {
+		sort, err := services.ParseTransactionSort(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.Sort = sort
+	}
+
+	limit, err := ctx.URLParamInt("limit")
+	if err != nil && ctx.URLParamExists("limit") {
+		return filter, errors.New("invalid limit")
+	}
+	filter.Limit = limit
+	return filter, nil
+}
+
+func parseFilterTime(value string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	if t, err = time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, errors.New("expected RFC 3339 or YYYY-MM-DD")
+}
+
+// AuthorizeTransactionHandler places a hold on the payer's funds without paying the payee
+func AuthorizeTransactionHandler(svc *services.TransactionService, ctx iris.Context) {
+	payerId := ctx.Values().GetString("UserID")
+	if payerId == "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]string{"error": "User not authenticated"})
+		return
+	}
+
+	// Parse request body
+	var req model.ProcessPaymentInput
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "Invalid request payload"})
+		return
+	}
+
+	transaction, err := svc.AuthorizeTransaction(ctx, payerId, req.PayeeID, req.Amount, req.PaymentMethodID, req.PaymentDetails, ctx.Values().GetString("IdempotencyKey"))
+	if err != nil {
+		ctx.StatusCode(processorErrorStatus(err, iris.StatusInternalServerError))
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(iris.Map{
+		"transaction_id":  transaction.TransactionID,
+		"status":          transaction.Status,
+		"reserved_amount": transaction.ReservedAmount,
+		"expires_at":      transaction.AuthorizationExpiresAt,
+		"message":         "Transaction Authorized Successfully.",
+	})
+}
+
+// CaptureTransactionHandler captures all or part of an authorization
+func CaptureTransactionHandler(svc *services.TransactionService, ctx iris.Context) {
+	userID := ctx.Values().GetString("UserID")
+	if userID == "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]string{"error": "User not authenticated"})
+		return
+	}
+
+	// An empty body (or no amount) captures everything still held
+	var req struct {
+		Amount *model.Money `json:"amount"`
+	}
+	if ctx.GetContentLength() > 0 {
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]string{"error": "Invalid request payload"})
+			return
+		}
+	}
+
+	transaction, err := svc.CaptureAuthorization(ctx, ctx.Params().GetString("transactionID"), userID, req.Amount)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(iris.Map{
+		"transaction_id":  transaction.TransactionID,
+		"status":          transaction.Status,
+		"captured_amount": transaction.CapturedAmount,
+		"reserved_amount": transaction.ReservedAmount,
+	})
+}
+
+// VoidTransactionHandler releases an authorization hold back to the payer
+func VoidTransactionHandler(svc *services.TransactionService, ctx iris.Context) {
+	userID := ctx.Values().GetString("UserID")
+	if userID == "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]string{"error": "User not authenticated"})
+		return
+	}
+
+	transaction, err := svc.VoidAuthorization(ctx, ctx.Params().GetString("transactionID"), userID)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(iris.Map{
+		"transaction_id":  transaction.TransactionID,
+		"status":          transaction.Status,
+		"captured_amount": transaction.CapturedAmount,
+	})
+}
+
+// CreateRefundHandler refunds all or part of a completed transaction
+func CreateRefundHandler(svc *services.TransactionService, ctx iris.Context) {
+	userID := ctx.Values().GetString("UserID")
+	if userID == "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]string{"error": "User not authenticated"})
+		return
+	}
+
+	// An empty body (or no amount) refunds everything still refundable
+	var req struct {
+		Amount *model.Money `json:"amount"`
+		Reason string       `json:"reason"`
+	}
+	if ctx.GetContentLength() > 0 {
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]string{"error": "Invalid request payload"})
+			return
+		}
+	}
+
+	refund, original, err := svc.RefundTransaction(ctx, ctx.Params().GetString("transactionID"), userID, req.Amount, req.Reason)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	remaining, _ := services.RefundableAmount(original)
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(iris.Map{
+		"refund_id":               refund.TransactionID,
+		"original_transaction_id": original.TransactionID,
+		"amount":                  refund.Amount,
+		"status":                  refund.Status,
+		"original_status":         original.Status,
+		"refunded_amount":         original.RefundedAmount,
+		"remaining_refundable":    remaining,
+	})
+}
+
+// RequestUPICollectHandler lets the authenticated user, as payee, ask a payer
+// for money by UPI ID
+func RequestUPICollectHandler(svc *services.TransactionService, ctx iris.Context) {
+	var req struct {
+		PayerVPA string      `json:"payer_vpa"`
+		Amount   model.Money `json:"amount"`
+		Note     string      `json:"note"`
+	}
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "Invalid request payload"})
+		return
+	}
+
+	request, err := svc.RequestUPICollect(ctx, ctx.Values().GetString("UserID"), req.PayerVPA, req.Amount, req.Note, ctx.Values().GetString("IdempotencyKey"))
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(request)
+}
+
+// ListUPICollectRequestsHandler lists the collect requests waiting for the
+// authenticated user to answer
+func ListUPICollectRequestsHandler(svc *services.TransactionService, ctx iris.Context) {
+	requests, err := svc.ListUPICollectRequests(ctx, ctx.Values().GetString("UserID"))
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(requests)
+}
+
+// RespondToUPICollectHandler returns a handler that approves or declines a
+// collect request addressed to the authenticated user
+func RespondToUPICollectHandler(svc *services.TransactionService, approve bool) iris.Handler {
+	return func(ctx iris.Context) {
+		request, transaction, err := svc.RespondToUPICollect(ctx, ctx.Values().GetString("UserID"), ctx.Params().GetString("collectRequestID"), approve)
+		if err != nil {
+			if errors.Is(err, services.ErrCollectRequestNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+			} else {
+				ctx.StatusCode(iris.StatusBadRequest)
+			}
+			ctx.JSON(map[string]string{"error": err.Error()})
+			return
+		}
+
+		ctx.StatusCode(iris.StatusOK)
+		ctx.JSON(iris.Map{
+			"collect_request": request,
+			"transaction_id":  transaction.TransactionID,
+			"status":          transaction.Status,
+		})
+	}
+}
+
+// GetChequeHandler shows the clearing state of a cheque payment to its payer or payee
+func GetChequeHandler(svc *services.TransactionService, ctx iris.Context) {
+	cheque, err := svc.GetCheque(ctx, ctx.Params().GetString("transactionID"), ctx.Values().GetString("UserID"))
+	if err != nil {
+		respondChequeError(ctx, err)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(cheque)
+}
+
+// ClearChequeHandler lets an administrator clear a cheque before its clearing period ends
+func ClearChequeHandler(svc *services.TransactionService, ctx iris.Context) {
+	cheque, err := svc.ClearCheque(ctx, ctx.Params().GetString("chequeID"), ctx.Values().GetString("UserID"))
+	if err != nil {
+		respondChequeError(ctx, err)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(cheque)
+}
+
+// BounceChequeHandler lets an administrator return a cheque unpaid
+func BounceChequeHandler(svc *services.TransactionService, ctx iris.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]string{"error": "Invalid request payload"})
+		return
+	}
+
+	cheque, err := svc.BounceCheque(ctx, ctx.Params().GetString("chequeID"), ctx.Values().GetString("UserID"), strings.TrimSpace(req.Reason))
+	if err != nil {
+		respondChequeError(ctx, err)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(cheque)
+}
+
+func respondChequeError(ctx iris.Context, err error) {
+	if errors.Is(err, services.ErrChequeNotFound) {
+		ctx.StatusCode(iris.StatusNotFound)
+	} else {
+		ctx.StatusCode(iris.StatusBadRequest)
+	}
+	ctx.JSON(map[string]string{"error": err.Error()})
+}
+
+// RecoveryMetricsHandler reports how many transactions are stuck and what the
+// recovery sweeper has done about them
+func RecoveryMetricsHandler(svc *services.TransactionService, ctx iris.Context) {
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(svc.Recovery.Snapshot())
 }
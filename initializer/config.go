@@ -3,6 +3,8 @@ package initializer
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -40,3 +42,40 @@ func GetEnv(key string) string {
 	}
 	return value
 }
+
+// GetEnvDefault fetches an optional environment variable, falling back to the
+// given default when it is unset.
+func GetEnvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvDuration reads a duration such as "15m" or "72h" from the environment,
+// falling back to the given default when the variable is unset.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Environment variable %s is not a valid duration: %v", key, err)
+	}
+	return duration
+}
+
+// GetEnvInt reads a positive integer from the environment, falling back to
+// the given default when the variable is unset.
+func GetEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Environment variable %s is not a positive integer", key)
+	}
+	return n
+}
@@ -0,0 +1,85 @@
+package initializer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"poc/utils"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// LoadSigningKeys builds the JWT key ring from the environment.
+//
+// JWT_SIGNING_KEYS lists keys as comma-separated "kid:algorithm:path"
+// entries. Algorithms are HS256, RS256 and ES256. For HS256 the file holds
+// the shared secret; for RS256/ES256 it holds a PEM private key, or a PEM
+// public key for a retired key that should only verify existing tokens.
+// JWT_ACTIVE_KEY_ID names the key new tokens are signed with.
+//
+// For simple deployments JWT_SECRET alone configures a single HS256 key.
+func LoadSigningKeys() (*utils.KeyRing, error) {
+	spec := os.Getenv("JWT_SIGNING_KEYS")
+	if spec == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("set JWT_SIGNING_KEYS or JWT_SECRET to configure token signing")
+		}
+		key, err := utils.NewSigningKey("default", "HS256", []byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		return utils.NewKeyRing(key.ID, key)
+	}
+
+	var keys []*utils.SigningKey
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid JWT_SIGNING_KEYS entry %q, want kid:algorithm:path", entry)
+		}
+		kid, algorithm, path := parts[0], strings.ToUpper(parts[1]), parts[2]
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key %s: %v", kid, err)
+		}
+		material, err := parseKeyMaterial(algorithm, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %s: %v", kid, err)
+		}
+		key, err := utils.NewSigningKey(kid, algorithm, material)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	activeID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	if activeID == "" && len(keys) == 1 {
+		activeID = keys[0].ID
+	}
+	return utils.NewKeyRing(activeID, keys...)
+}
+
+// parseKeyMaterial reads a private key when the PEM holds one, falling back to
+// a public key for verify-only entries.
+func parseKeyMaterial(algorithm string, data []byte) (interface{}, error) {
+	switch algorithm {
+	case "HS256":
+		return []byte(strings.TrimSpace(string(data))), nil
+	case "RS256":
+		if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+			return key, nil
+		}
+		return jwt.ParseRSAPublicKeyFromPEM(data)
+	case "ES256":
+		if key, err := jwt.ParseECPrivateKeyFromPEM(data); err == nil {
+			return key, nil
+		}
+		return jwt.ParseECPublicKeyFromPEM(data)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+}
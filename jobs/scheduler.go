@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs fn on a fixed interval until ctx is cancelled. Errors are logged
+// and the job keeps running on the next tick.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Background job %s started (every %s)", name, interval)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Background job %s stopped", name)
+			return
+		case <-ticker.C:
+			if err := fn(ctx); err != nil {
+				log.Printf("Background job %s failed: %v", name, err)
+			}
+		}
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"poc/initializer"
+	"poc/jobs"
+	"poc/middleware"
+	"poc/model"
 	"poc/routes"
 	"poc/services"
+	"poc/utils"
 
 	"github.com/kataras/iris/v12"
 )
@@ -16,6 +23,13 @@ func main() {
 	// Load configuration from .env file
 	initializer.LoadConfig()
 
+	// Load the JWT signing key ring
+	keyRing, err := initializer.LoadSigningKeys()
+	if err != nil {
+		log.Fatalf("Failed to load token signing keys: %v", err)
+	}
+	utils.SetKeyRing(keyRing)
+
 	// Initialize the GORM client (Spanner)
 	db, err := initializer.InitializeGORMSpannerClient()
 	if err != nil {
@@ -23,17 +37,168 @@ func main() {
 	}
 
 	// Set up services (user service, transaction service, payment method service, etc.)
-	userService := services.NewUserService(db)
-	paymentMethodService := services.NewPaymentMethodService(db)
-	transactionService := services.NewTransactionService(db, paymentMethodService)
+	ledgerService := services.NewLedgerService(db)
+	tokenService := services.NewTokenService(db)
+	tokenService.AccessTTL = initializer.GetEnvDuration("ACCESS_TOKEN_TTL", services.DefaultAccessTokenTTL)
+	tokenService.RefreshTTL = initializer.GetEnvDuration("REFRESH_TOKEN_TTL", services.DefaultRefreshTokenTTL)
+	middleware.UseRevocationList(tokenService)
+	mailer, err := services.NewMailer(initializer.GetEnvDefault("MAIL_SINK", "console"), initializer.GetEnvDefault("MAIL_FILE_DIR", "mail"))
+	if err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
+	userService := services.NewUserService(db, ledgerService, tokenService, mailer)
+	userService.PublicURL = initializer.GetEnvDefault("APP_BASE_URL", userService.PublicURL)
+	userService.VerificationTokenTTL = initializer.GetEnvDuration("EMAIL_VERIFICATION_TTL", services.DefaultVerificationTokenTTL)
+	userService.PasswordResetTTL = initializer.GetEnvDuration("PASSWORD_RESET_TTL", services.DefaultPasswordResetTTL)
+
+	// Promote the first admin; every later role change goes through an admin
+	if email := os.Getenv("BOOTSTRAP_ADMIN_EMAIL"); email != "" {
+		if granted, err := userService.BootstrapAdmin(context.Background(), email); err != nil {
+			log.Printf("Failed to bootstrap admin: %v", err)
+		} else if granted {
+			log.Printf("Granted the admin role to %s", email)
+		}
+	}
+	kms, err := services.NewLocalKMS(initializer.GetEnvDefault("VAULT_KEY_FILE", "vault.key"))
+	if err != nil {
+		log.Fatalf("Failed to load vault keys: %v", err)
+	}
+	fingerprintKey, err := services.LoadFingerprintKey(initializer.GetEnvDefault("FINGERPRINT_KEY_FILE", "fingerprint.key"), kms)
+	if err != nil {
+		log.Fatalf("Failed to load fingerprint key: %v", err)
+	}
+	vault := services.NewVault(db, kms, fingerprintKey)
+	bankRail, err := services.NewBankRail(initializer.GetEnvDefault("BANK_RAIL", "simulator"))
+	if err != nil {
+		log.Fatalf("Failed to set up bank rail: %v", err)
+	}
+	psp, err := services.NewPSP(initializer.GetEnvDefault("UPI_PSP", "simulator"))
+	if err != nil {
+		log.Fatalf("Failed to set up UPI PSP: %v", err)
+	}
+	paymentMethodService := services.NewPaymentMethodService(db, vault, bankRail, psp)
+	paymentMethodService.MicroDepositAttempts = initializer.GetEnvInt("MICRO_DEPOSIT_ATTEMPTS", services.DefaultMicroDepositAttempts)
+	if vaulted, err := paymentMethodService.VaultLegacyNumbers(context.Background()); err != nil {
+		log.Fatalf("Failed to move stored card numbers into the vault: %v", err)
+	} else if vaulted > 0 {
+		log.Printf("Moved %d stored card and account numbers into the vault", vaulted)
+	}
+	transactionService := services.NewTransactionService(db, paymentMethodService, ledgerService)
+	idempotencyService := services.NewIdempotencyService(db)
+	idempotencyService.Lease = initializer.GetEnvDuration("IDEMPOTENCY_LEASE", services.DefaultIdempotencyLease)
+	stepUpThreshold, err := model.ParseMoney(initializer.GetEnvDefault("MFA_STEP_UP_THRESHOLD", "10000.00"), initializer.GetEnvDefault("MFA_STEP_UP_CURRENCY", model.DefaultCurrency))
+	if err != nil {
+		log.Fatalf("Invalid MFA step-up threshold: %v", err)
+	}
+	mfaService := services.NewMFAService(db, stepUpThreshold)
+	attemptStore := services.NewInMemoryAttemptStore()
+	loginThrottle := services.NewLoginThrottle(db, attemptStore)
+	mfaService.Throttle = loginThrottle
+	userService.Throttle = loginThrottle
+	transactionService.AuthorizationTTL = initializer.GetEnvDuration("AUTHORIZATION_HOLD_TTL", services.DefaultAuthorizationTTL)
+	transactionService.CollectRequestTTL = initializer.GetEnvDuration("UPI_COLLECT_TTL", services.DefaultCollectRequestTTL)
+	// No default: the simulator must never end up in production by accident
+	processorKind := os.Getenv("PAYMENT_PROCESSOR")
+	processor, err := services.NewPaymentProcessor(processorKind)
+	if err != nil {
+		log.Fatalf("Failed to set up payment processor (set PAYMENT_PROCESSOR, e.g. to \"simulator\" for local development): %v", err)
+	}
+	if processorKind == "simulator" {
+		log.Printf("WARNING: using the simulated payment processor; no real money moves and payments are forgotten on restart")
+	}
+	transactionService.Processors = services.NewProcessorRegistry(processor)
+	transactionService.Processors.Timeout = initializer.GetEnvDuration("PAYMENT_PROCESSOR_TIMEOUT", services.DefaultProcessorTimeout)
+	for _, methodType := range []string{"card", "bank_transfer", "upi", "wallet"} {
+		// e.g. PAYMENT_PROCESSOR_CARD sends card payments to their own acquirer
+		kind := os.Getenv("PAYMENT_PROCESSOR_" + strings.ToUpper(methodType))
+		if kind == "" {
+			continue
+		}
+		methodProcessor, err := services.NewPaymentProcessor(kind)
+		if err != nil {
+			log.Fatalf("Failed to set up %s payment processor: %v", methodType, err)
+		}
+		transactionService.Processors.Register(methodType, methodProcessor)
+	}
+	transactionService.ChequeClearingPeriod = initializer.GetEnvDuration("CHEQUE_CLEARING_PERIOD", services.DefaultChequeClearingPeriod)
+	transactionService.ChequeBounceFee, err = model.ParseMoney(initializer.GetEnvDefault("CHEQUE_BOUNCE_FEE", "0.00"), initializer.GetEnvDefault("CHEQUE_BOUNCE_FEE_CURRENCY", model.DefaultCurrency))
+	if err != nil {
+		log.Fatalf("Invalid cheque bounce fee: %v", err)
+	}
+	transactionService.StuckAfter = initializer.GetEnvDuration("STUCK_TRANSACTION_AFTER", services.DefaultStuckTransactionAfter)
+
+	// Finish or undo payments cut off by the last shutdown
+	sagaStaleAfter := initializer.GetEnvDuration("SAGA_STALE_AFTER", services.DefaultSagaStaleAfter)
+	if sagaStaleAfter < 2*transactionService.Processors.Timeout {
+		log.Fatalf("SAGA_STALE_AFTER (%s) must be at least twice the payment processor timeout (%s)", sagaStaleAfter, transactionService.Processors.Timeout)
+	}
+	if resumed, err := transactionService.ResumeSagas(context.Background(), sagaStaleAfter); err != nil {
+		log.Printf("Failed to resume interrupted payments: %v", err)
+	} else if resumed > 0 {
+		log.Printf("Resumed %d interrupted payments", resumed)
+	}
+
+	// Start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go jobs.Every(jobsCtx, "saga-recovery", initializer.GetEnvDuration("SAGA_RECOVERY_INTERVAL", time.Minute), func(ctx context.Context) error {
+		resumed, err := transactionService.ResumeSagas(ctx, sagaStaleAfter)
+		if resumed > 0 {
+			log.Printf("Resumed %d interrupted payments", resumed)
+		}
+		return err
+	})
+	go jobs.Every(jobsCtx, "authorization-expiry", initializer.GetEnvDuration("AUTHORIZATION_EXPIRY_INTERVAL", time.Minute), func(ctx context.Context) error {
+		released, err := transactionService.ExpireAuthorizations(ctx)
+		if released > 0 {
+			log.Printf("Released %d expired authorization holds", released)
+		}
+		return err
+	})
+	go jobs.Every(jobsCtx, "upi-collect-expiry", time.Minute, func(ctx context.Context) error {
+		expired, err := transactionService.ExpireUPICollectRequests(ctx)
+		if expired > 0 {
+			log.Printf("Expired %d unanswered UPI collect requests", expired)
+		}
+		return err
+	})
+	go jobs.Every(jobsCtx, "cheque-clearing", initializer.GetEnvDuration("CHEQUE_CLEARING_INTERVAL", 5*time.Minute), func(ctx context.Context) error {
+		processed, err := transactionService.ProcessCheques(ctx)
+		if processed > 0 {
+			log.Printf("Moved %d cheques through clearing", processed)
+		}
+		return err
+	})
+	go jobs.Every(jobsCtx, "transaction-recovery", initializer.GetEnvDuration("TRANSACTION_RECOVERY_INTERVAL", 5*time.Minute), func(ctx context.Context) error {
+		settled, err := transactionService.RecoverStuckTransactions(ctx)
+		if settled > 0 {
+			log.Printf("Settled %d stuck transactions", settled)
+		}
+		return err
+	})
+	go jobs.Every(jobsCtx, "payment-method-expiry", initializer.GetEnvDuration("PAYMENT_METHOD_EXPIRY_INTERVAL", time.Hour), func(ctx context.Context) error {
+		expired, err := paymentMethodService.ExpireCards(ctx)
+		if expired > 0 {
+			log.Printf("Marked %d cards as expired", expired)
+		}
+		return err
+	})
+	go jobs.Every(jobsCtx, "login-attempt-cleanup", time.Hour, func(ctx context.Context) error {
+		_, err := attemptStore.Prune(ctx, 24*time.Hour)
+		return err
+	})
+	go jobs.Every(jobsCtx, "token-cleanup", initializer.GetEnvDuration("TOKEN_CLEANUP_INTERVAL", time.Hour), func(ctx context.Context) error {
+		_, err := tokenService.PurgeExpired(ctx)
+		return err
+	})
 
 	// Create an Iris application instance
 	app := iris.New()
 
 	// Register routes for user, transaction, and payment method
-	routes.RegisterAuthRoutes(app, userService)
-	routes.RegisterPaymentRoutes(app, paymentMethodService) // Add this to register payment method routes
-	routes.RegisterTransactionRoutes(app, transactionService)
+	routes.RegisterAuthRoutes(app, userService, mfaService, loginThrottle, idempotencyService)
+	routes.RegisterPaymentRoutes(app, paymentMethodService, idempotencyService, userService) // Add this to register payment method routes
+	routes.RegisterTransactionRoutes(app, transactionService, idempotencyService, userService, mfaService)
 
 	// Define the server port (default to 8080)
 	port := os.Getenv("PORT")
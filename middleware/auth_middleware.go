@@ -1,15 +1,29 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
+	"log"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v4"
+	"poc/utils"
+
 	"github.com/kataras/iris/v12"
 )
 
-// Secret key for signing (should be the same as used during token generation)
-var secretKey = []byte("your-secret-key")
+// RevocationList reports whether an access token, identified by its jti, has
+// been revoked by logout or refresh token rotation.
+type RevocationList interface {
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// revocations is consulted by AuthMiddleware on every request.
+var revocations RevocationList
+
+// UseRevocationList sets the revocation list AuthMiddleware checks. It must
+// be called once at startup, before the server accepts requests.
+func UseRevocationList(list RevocationList) {
+	revocations = list
+}
 
 // AuthMiddleware checks for the validity of JWT token
 func AuthMiddleware(ctx iris.Context) {
@@ -29,41 +43,40 @@ func AuthMiddleware(ctx iris.Context) {
 		return
 	}
 
-	// Parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
-
+	// Parse and verify the token
+	claims, err := utils.ParseToken(tokenString)
 	if err != nil {
 		ctx.StatusCode(iris.StatusUnauthorized)
 		ctx.JSON(map[string]string{"error": "Invalid or expired token"})
 		return
 	}
 
-	// Check if token is valid
-	if !token.Valid {
-		ctx.StatusCode(iris.StatusUnauthorized)
-		ctx.JSON(map[string]string{"error": "Invalid token"})
+	// Reject tokens revoked by logout; fail closed if the list is unavailable
+	if revocations == nil {
+		log.Println("AuthMiddleware: no revocation list configured")
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+		ctx.JSON(map[string]string{"error": "Authentication unavailable"})
 		return
 	}
-
-	// Extract user ID from claims and store it in the context
-	claims, ok := token.Claims.(jwt.MapClaims)
-	// fmt.Println("claims - ", claims)
-	if !ok || claims["UserID"] == nil {
+	revoked, err := revocations.IsRevoked(ctx.Request().Context(), claims.ID)
+	if err != nil {
+		log.Printf("AuthMiddleware: %v", err)
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+		ctx.JSON(map[string]string{"error": "Authentication unavailable"})
+		return
+	}
+	if revoked {
 		ctx.StatusCode(iris.StatusUnauthorized)
-		ctx.JSON(map[string]string{"error": "Invalid token claims"})
+		ctx.JSON(map[string]string{"error": "Token has been revoked"})
 		return
 	}
 
-	// Set the user ID into the context for further use
-	userID := claims["UserID"].(string)
-	// fmt.Println("Authenticated user ID:", userID) // Log user ID for debugging
-	ctx.Values().Set("UserID", userID)
+	// Set the user and session into the context for further use
+	ctx.Values().Set("UserID", claims.UserID)
+	ctx.Values().Set("Role", claims.Role)
+	ctx.Values().Set("SessionID", claims.SessionID)
+	ctx.Values().Set("TokenID", claims.ID)
+	ctx.Values().Set("TokenExpiresAt", claims.ExpiresAt.Time)
 
 	// Call the next handler
 	ctx.Next()
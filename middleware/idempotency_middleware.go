@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"poc/services"
+
+	"github.com/kataras/iris/v12"
+)
+
+// IdempotencyKeyHeader is the request header clients use to make retries safe.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyReleaseKey marks a response that must not be stored for replay.
+const idempotencyReleaseKey = "IdempotencyRelease"
+
+// releaseIdempotencyKey lets the client retry under the same Idempotency-Key
+// instead of getting this response replayed, for rejections such as a missing
+// step-up code that the client is expected to fix and resend.
+func releaseIdempotencyKey(ctx iris.Context) {
+	ctx.Values().Set(idempotencyReleaseKey, true)
+}
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key and rejects reuse of a key with a different body. Server
+// errors are not replayed; the key is released so the retry runs again. When
+// required is true, requests without the header are rejected. It must run
+// after AuthMiddleware so keys are scoped to the authenticated user.
+func Idempotency(svc *services.IdempotencyService, required bool) iris.Handler {
+	return func(ctx iris.Context) {
+		key := ctx.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			if required {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.JSON(map[string]string{"error": "Idempotency-Key header is required"})
+				return
+			}
+			ctx.Next()
+			return
+		}
+		if len(key) > 255 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]string{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		userID := ctx.Values().GetString("UserID")
+
+		// Keep the body readable for the handler after fingerprinting it
+		ctx.RecordRequestBody(true)
+		body, err := ctx.GetBody()
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		record, replay, err := svc.Begin(ctx.Request().Context(), userID, ctx.Method(), ctx.Path(), key, services.Fingerprint(body))
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrIdempotencyKeyReused):
+				ctx.StatusCode(iris.StatusUnprocessableEntity)
+			case errors.Is(err, services.ErrIdempotencyInProgress):
+				ctx.StatusCode(iris.StatusConflict)
+			default:
+				ctx.StatusCode(iris.StatusInternalServerError)
+			}
+			ctx.JSON(map[string]string{"error": err.Error()})
+			return
+		}
+
+		if replay {
+			ctx.Header("Idempotent-Replayed", "true")
+			ctx.ContentType("application/json")
+			ctx.StatusCode(record.StatusCode)
+			ctx.WriteString(record.ResponseBody)
+			return
+		}
+
+		ctx.Values().Set("IdempotencyKey", key)
+		ctx.Record()
+
+		defer func() {
+			if r := recover(); r != nil {
+				if err := svc.Release(ctx.Request().Context(), record); err != nil {
+					log.Printf("Failed to release idempotency key: %v", err)
+				}
+				panic(r)
+			}
+		}()
+
+		ctx.Next()
+
+		// Server errors are not stored either, so the retry runs again
+		recorder := ctx.Recorder()
+		if ctx.Values().GetBoolDefault(idempotencyReleaseKey, false) || recorder.StatusCode() >= iris.StatusInternalServerError {
+			if err := svc.Release(ctx.Request().Context(), record); err != nil {
+				log.Printf("Failed to release idempotency key: %v", err)
+			}
+			return
+		}
+		if err := svc.Complete(ctx.Request().Context(), record, recorder.StatusCode(), recorder.Body()); err != nil {
+			log.Printf("Failed to store idempotent response: %v", err)
+		}
+	}
+}
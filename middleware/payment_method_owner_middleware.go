@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"log"
+
+	"github.com/kataras/iris/v12"
+)
+
+// PaymentMethodOwnerChecker reports whether a payment method belongs to a payer.
+type PaymentMethodOwnerChecker interface {
+	IsPaymentMethodOwner(ctx context.Context, payerID, paymentMethodID string) (bool, error)
+}
+
+// RequirePaymentMethodOwner stops requests for a {paymentMethodID} that does
+// not belong to the authenticated user. Someone else's method is reported as
+// not found so IDs cannot be probed. It must run after AuthMiddleware.
+func RequirePaymentMethodOwner(checker PaymentMethodOwnerChecker) iris.Handler {
+	return func(ctx iris.Context) {
+		owner, err := checker.IsPaymentMethodOwner(ctx.Request().Context(), ctx.Values().GetString("UserID"), ctx.Params().GetString("paymentMethodID"))
+		if err != nil {
+			log.Printf("RequirePaymentMethodOwner: %v", err)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(map[string]string{"error": "Failed to check payment method"})
+			return
+		}
+		if !owner {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(map[string]string{"error": "Payment method not found"})
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"github.com/kataras/iris/v12"
+)
+
+// RequireRole lets the request through only if the token's role is one of
+// roles. It must run after AuthMiddleware, which puts the role in the context.
+func RequireRole(roles ...string) iris.Handler {
+	return func(ctx iris.Context) {
+		role := ctx.Values().GetString("Role")
+		for _, allowed := range roles {
+			if role == allowed {
+				ctx.Next()
+				return
+			}
+		}
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.JSON(map[string]string{"error": "Insufficient permissions"})
+	}
+}
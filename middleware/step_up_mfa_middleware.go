@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"poc/model"
+	"poc/services"
+	"strconv"
+
+	"github.com/kataras/iris/v12"
+)
+
+// MFACodeHeader carries a TOTP or recovery code for step-up authentication.
+const MFACodeHeader = "X-MFA-Code"
+
+// RequireStepUpMFA asks for a fresh MFA code in the X-MFA-Code header when
+// the request's amount is above the configured threshold. Wrong codes count
+// towards the account lockout. It must run after AuthMiddleware and
+// Idempotency: a retry of a request that already went through is replayed
+// before its spent code is checked again, and a rejected attempt leaves the
+// Idempotency-Key free to retry with a code.
+func RequireStepUpMFA(svc *services.MFAService) iris.Handler {
+	return func(ctx iris.Context) {
+		// Keep the body readable for the handler after peeking at the amount
+		ctx.RecordRequestBody(true)
+		body, err := ctx.GetBody()
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]string{"error": "Invalid request body"})
+			return
+		}
+		var req struct {
+			Amount model.Money `json:"amount"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || !svc.RequiresStepUp(req.Amount) {
+			// Malformed bodies are rejected by the handler itself
+			ctx.Next()
+			return
+		}
+		verifyStepUp(svc, ctx)
+	}
+}
+
+// RequireStepUpMFAFor is RequireStepUpMFA for requests that move an amount
+// stored on our side, such as approving a UPI collect request, so the client
+// cannot send a smaller one to dodge the check. If amountOf fails the
+// request goes on and the handler reports the problem.
+func RequireStepUpMFAFor(svc *services.MFAService, amountOf func(ctx iris.Context) (model.Money, error)) iris.Handler {
+	return func(ctx iris.Context) {
+		amount, err := amountOf(ctx)
+		if err != nil || !svc.RequiresStepUp(amount) {
+			ctx.Next()
+			return
+		}
+		verifyStepUp(svc, ctx)
+	}
+}
+
+// verifyStepUp checks the X-MFA-Code header and lets the request through or
+// rejects it.
+func verifyStepUp(svc *services.MFAService, ctx iris.Context) {
+	err := svc.Verify(ctx.Request().Context(), ctx.Values().GetString("UserID"), ctx.GetHeader(MFACodeHeader))
+	if err == nil {
+		ctx.Next()
+		return
+	}
+
+	releaseIdempotencyKey(ctx)
+	var blocked *services.LoginBlockedError
+	switch {
+	case errors.As(err, &blocked):
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+		ctx.StatusCode(iris.StatusTooManyRequests)
+		ctx.JSON(iris.Map{"error": err.Error(), "mfa_required": true})
+	case errors.Is(err, services.ErrMFANotEnrolled):
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.JSON(iris.Map{"error": "Enable multi-factor authentication to make payments above " + svc.StepUpThreshold.String(), "mfa_enrollment_required": true})
+	case errors.Is(err, services.ErrMFARequired), errors.Is(err, services.ErrInvalidMFACode):
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(iris.Map{"error": err.Error(), "mfa_required": true})
+	default:
+		log.Printf("RequireStepUpMFA: %v", err)
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(map[string]string{"error": "Failed to verify multi-factor authentication"})
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"context"
+	"log"
+
+	"github.com/kataras/iris/v12"
+)
+
+// VerificationChecker reports whether a user has verified their email address.
+type VerificationChecker interface {
+	IsVerified(ctx context.Context, userID string) (bool, error)
+}
+
+// RequireVerifiedEmail is a policy hook that stops users who have not verified
+// their email address. It must run after AuthMiddleware.
+func RequireVerifiedEmail(checker VerificationChecker) iris.Handler {
+	return func(ctx iris.Context) {
+		verified, err := checker.IsVerified(ctx.Request().Context(), ctx.Values().GetString("UserID"))
+		if err != nil {
+			log.Printf("RequireVerifiedEmail: %v", err)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(map[string]string{"error": "Failed to check email verification"})
+			return
+		}
+		if !verified {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.JSON(map[string]string{"error": "Verify your email address before continuing"})
+			return
+		}
+		ctx.Next()
+	}
+}
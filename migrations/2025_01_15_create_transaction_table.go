@@ -40,6 +40,106 @@ func MigrateUp(db *gorm.DB) error {
 		return err
 	}
 
+	// Convert float balances and amounts to minor-unit money columns
+	if err := MigrateMoneyColumns(db); err != nil {
+		return err
+	}
+
+	// Create ledger tables and carry existing balances into the journal
+	if err := MigrateLedger(db); err != nil {
+		return err
+	}
+
+	// Store Idempotency-Key responses for safe client retries
+	if err := MigrateIdempotencyKeys(db); err != nil {
+		return err
+	}
+
+	// Add authorization hold columns to transactions
+	if err := MigrateAuthorizations(db); err != nil {
+		return err
+	}
+
+	// Link refunds to their original transaction
+	if err := MigrateRefunds(db); err != nil {
+		return err
+	}
+
+	// Record every transaction status transition
+	if err := MigrateTransactionStatusHistory(db); err != nil {
+		return err
+	}
+
+	// Refresh tokens and the access token revocation list
+	if err := MigrateAuthTokens(db); err != nil {
+		return err
+	}
+
+	// Single-use password reset tokens
+	if err := MigratePasswordResetTokens(db); err != nil {
+		return err
+	}
+
+	// TOTP two-factor authentication
+	if err := MigrateMFA(db); err != nil {
+		return err
+	}
+
+	// Account and actor columns for security audit entries
+	if err := MigrateAuditLogUsers(db); err != nil {
+		return err
+	}
+
+	// Roles for access control
+	if err := MigrateUserRoles(db); err != nil {
+		return err
+	}
+
+	// Encrypted card and account numbers
+	if err := MigrateVault(db); err != nil {
+		return err
+	}
+
+	// Card brand detected from the BIN
+	if err := MigrateCardBrands(db); err != nil {
+		return err
+	}
+
+	// Default payment method per payer
+	if err := MigrateDefaultPaymentMethods(db); err != nil {
+		return err
+	}
+
+	// Payment method lifecycle states
+	if err := MigratePaymentMethodLifecycle(db); err != nil {
+		return err
+	}
+
+	// Micro-deposit bank account verification
+	if err := MigrateBankAccountVerifications(db); err != nil {
+		return err
+	}
+
+	// UPI holder names and collect requests
+	if err := MigrateUPI(db); err != nil {
+		return err
+	}
+
+	// Cheque clearing
+	if err := MigrateCheques(db); err != nil {
+		return err
+	}
+
+	// Payment processor references on transactions
+	if err := MigrateProcessorReferences(db); err != nil {
+		return err
+	}
+
+	// Payment saga progress
+	if err := MigrateSagas(db); err != nil {
+		return err
+	}
+
 	// Add additional tables here as needed
 
 	log.Println("All tables migrated successfully.")
@@ -0,0 +1,13 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateAuditLogUsers adds the account and actor columns used by security
+// audit entries such as lockouts.
+func MigrateAuditLogUsers(db *gorm.DB) error {
+	return migrateTable(db, &model.AuditLog{}, "AuditLogs")
+}
@@ -0,0 +1,13 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateAuthorizations adds the captured amount and hold expiry columns used
+// by the authorize / capture / void flow to Transactions.
+func MigrateAuthorizations(db *gorm.DB) error {
+	return migrateTable(db, &model.Transaction{}, "Transactions")
+}
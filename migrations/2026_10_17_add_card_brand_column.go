@@ -0,0 +1,13 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateCardBrands adds the Brand column to PaymentMethods. Cards vaulted
+// before it existed keep an empty brand, which accepts either CVV length.
+func MigrateCardBrands(db *gorm.DB) error {
+	return migrateTable(db, &model.PaymentMethod{}, "PaymentMethods")
+}
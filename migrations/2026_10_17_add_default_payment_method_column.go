@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"fmt"
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateDefaultPaymentMethods adds the is_default column to PaymentMethods
+// and makes the oldest active method of each payer without one the default.
+func MigrateDefaultPaymentMethods(db *gorm.DB) error {
+	if err := migrateTable(db, &model.PaymentMethod{}, "PaymentMethods"); err != nil {
+		return err
+	}
+
+	var methods []model.PaymentMethod
+	if err := db.Where("status = ?", "active").Order("payer_id, created_at").Find(&methods).Error; err != nil {
+		return fmt.Errorf("failed to fetch payment methods: %v", err)
+	}
+	hasDefault := make(map[string]bool)
+	for _, method := range methods {
+		if method.IsDefault {
+			hasDefault[method.PayerID] = true
+		}
+	}
+	for _, method := range methods {
+		if hasDefault[method.PayerID] {
+			continue
+		}
+		err := db.Model(&model.PaymentMethod{}).Where("payment_method_id = ?", method.PaymentMethodID).Update("is_default", true).Error
+		if err != nil {
+			return fmt.Errorf("failed to backfill default payment method: %v", err)
+		}
+		hasDefault[method.PayerID] = true
+	}
+	return nil
+}
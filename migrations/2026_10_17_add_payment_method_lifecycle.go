@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"fmt"
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigratePaymentMethodLifecycle adds the removed_at column to PaymentMethods
+// and maps the old inactive status onto suspended.
+func MigratePaymentMethodLifecycle(db *gorm.DB) error {
+	if err := migrateTable(db, &model.PaymentMethod{}, "PaymentMethods"); err != nil {
+		return err
+	}
+	err := db.Model(&model.PaymentMethod{}).Where("status = ?", "inactive").
+		Updates(map[string]interface{}{"status": model.PaymentMethodSuspended, "is_default": false}).Error
+	if err != nil {
+		return fmt.Errorf("failed to convert inactive payment methods: %v", err)
+	}
+	return nil
+}
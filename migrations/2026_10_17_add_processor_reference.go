@@ -0,0 +1,12 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateProcessorReferences adds the processor_reference column to Transactions.
+func MigrateProcessorReferences(db *gorm.DB) error {
+	return migrateTable(db, &model.Transaction{}, "Transactions")
+}
@@ -0,0 +1,13 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateRefunds adds the original transaction link and cumulative refunded
+// amount columns to Transactions.
+func MigrateRefunds(db *gorm.DB) error {
+	return migrateTable(db, &model.Transaction{}, "Transactions")
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"fmt"
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateUserRoles adds the Role column to Users and makes every existing
+// user a customer. Staff roles are granted afterwards by an admin.
+func MigrateUserRoles(db *gorm.DB) error {
+	if err := migrateTable(db, &model.User{}, "Users"); err != nil {
+		return err
+	}
+	err := db.Model(&model.User{}).Where("Role IS NULL OR Role = ''").Update("Role", model.RoleCustomer).Error
+	if err != nil {
+		return fmt.Errorf("failed to backfill user roles: %v", err)
+	}
+	return nil
+}
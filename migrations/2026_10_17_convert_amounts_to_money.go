@@ -0,0 +1,94 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// legacyMoneyColumn describes a FLOAT64 column that is replaced by an embedded model.Money.
+type legacyMoneyColumn struct {
+	model        interface{}
+	table        string
+	keyColumn    string
+	legacyColumn string
+	unitsColumn  string
+	currencyCol  string
+}
+
+var legacyMoneyColumns = []legacyMoneyColumn{
+	{&model.Payer{}, "Payers", "PayerID", "Balance", "balance_minor_units", "balance_currency"},
+	{&model.Payee{}, "Payees", "PayeeID", "Balance", "balance_minor_units", "balance_currency"},
+	{&model.Transaction{}, "Transactions", "transaction_id", "amount", "amount_minor_units", "amount_currency"},
+	{&model.Transaction{}, "Transactions", "transaction_id", "reserved_amount", "reserved_amount_minor_units", "reserved_amount_currency"},
+}
+
+// MigrateMoneyColumns adds the minor-unit Money columns to Payers, Payees and
+// Transactions, converts every legacy FLOAT64 value into integer minor units in
+// the default currency and then drops the legacy column.
+//
+// The float is converted through its shortest decimal representation, so values
+// that were written as e.g. 10.1 become exactly 1010 paise instead of 1009.
+// Rows that carry more precision than the currency allows are rounded
+// half-to-even and logged so they can be reviewed.
+func MigrateMoneyColumns(db *gorm.DB) error {
+	for _, m := range []interface{}{&model.Payer{}, &model.Payee{}, &model.Transaction{}} {
+		if err := db.AutoMigrate(m); err != nil {
+			return fmt.Errorf("failed to add money columns: %v", err)
+		}
+	}
+
+	for _, col := range legacyMoneyColumns {
+		if !db.Migrator().HasColumn(col.model, col.legacyColumn) {
+			continue
+		}
+		if err := convertMoneyColumn(db, col); err != nil {
+			return err
+		}
+		if err := db.Migrator().DropColumn(col.model, col.legacyColumn); err != nil {
+			return fmt.Errorf("failed to drop legacy column %s.%s: %v", col.table, col.legacyColumn, err)
+		}
+		log.Printf("Converted %s.%s to minor units.\n", col.table, col.legacyColumn)
+	}
+	return nil
+}
+
+func convertMoneyColumn(db *gorm.DB, col legacyMoneyColumn) error {
+	type legacyRow struct {
+		Key   string
+		Value *float64
+	}
+
+	var rows []legacyRow
+	query := fmt.Sprintf("SELECT %s AS `key`, %s AS value FROM %s", col.keyColumn, col.legacyColumn, col.table)
+	if err := db.Raw(query).Scan(&rows).Error; err != nil {
+		return fmt.Errorf("failed to read %s.%s: %v", col.table, col.legacyColumn, err)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			var value float64
+			if row.Value != nil {
+				value = *row.Value
+			}
+			money, exact, err := model.MoneyFromFloat(value, model.DefaultCurrency)
+			if err != nil {
+				return fmt.Errorf("failed to convert %s %s: %v", col.table, row.Key, err)
+			}
+			if !exact {
+				log.Printf("Rounded %s %s %s from %v to %s\n", col.table, row.Key, col.legacyColumn, value, money)
+			}
+
+			err = tx.Table(col.table).Where(col.keyColumn+" = ?", row.Key).Updates(map[string]interface{}{
+				col.unitsColumn: money.MinorUnits,
+				col.currencyCol: money.Currency,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to update %s %s: %v", col.table, row.Key, err)
+			}
+		}
+		return nil
+	})
+}
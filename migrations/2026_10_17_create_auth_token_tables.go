@@ -0,0 +1,15 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateAuthTokens creates the refresh token and access token revocation tables.
+func MigrateAuthTokens(db *gorm.DB) error {
+	if err := migrateTable(db, &model.RefreshToken{}, "RefreshTokens"); err != nil {
+		return err
+	}
+	return migrateTable(db, &model.RevokedToken{}, "RevokedTokens")
+}
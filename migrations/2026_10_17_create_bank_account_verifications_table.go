@@ -0,0 +1,12 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateBankAccountVerifications creates the micro-deposit verification table.
+func MigrateBankAccountVerifications(db *gorm.DB) error {
+	return migrateTable(db, &model.BankAccountVerification{}, "BankAccountVerifications")
+}
@@ -0,0 +1,12 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateCheques creates the table tracking cheques through clearing.
+func MigrateCheques(db *gorm.DB) error {
+	return migrateTable(db, &model.Cheque{}, "Cheques")
+}
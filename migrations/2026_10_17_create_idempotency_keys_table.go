@@ -0,0 +1,16 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateIdempotencyKeys creates the IdempotencyKeys table and adds the
+// idempotency_key column to Transactions.
+func MigrateIdempotencyKeys(db *gorm.DB) error {
+	if err := migrateTable(db, &model.IdempotencyKey{}, "IdempotencyKeys"); err != nil {
+		return err
+	}
+	return migrateTable(db, &model.Transaction{}, "Transactions")
+}
@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MigrateLedger creates the ledger tables and seeds an opening-balance journal
+// entry for every payer and payee whose balance predates the ledger, so that
+// rebuilding the cached balances from the journal reproduces today's values.
+func MigrateLedger(db *gorm.DB) error {
+	if err := migrateTable(db, &model.LedgerAccount{}, "LedgerAccounts"); err != nil {
+		return err
+	}
+	if err := migrateTable(db, &model.JournalEntry{}, "JournalEntries"); err != nil {
+		return err
+	}
+	if err := migrateTable(db, &model.Posting{}, "Postings"); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var payers []model.Payer
+		if err := tx.Find(&payers).Error; err != nil {
+			return fmt.Errorf("failed to fetch payers: %v", err)
+		}
+		for _, payer := range payers {
+			if err := seedOpeningBalance(tx, model.PayerAccount(payer.PayerID), payer.Balance); err != nil {
+				return err
+			}
+		}
+
+		var payees []model.Payee
+		if err := tx.Find(&payees).Error; err != nil {
+			return fmt.Errorf("failed to fetch payees: %v", err)
+		}
+		for _, payee := range payees {
+			if err := seedOpeningBalance(tx, model.PayeeAccount(payee.PayeeID), payee.Balance); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// seedOpeningBalance writes the postings directly rather than through the
+// ledger service, because the cached balance already holds this amount.
+func seedOpeningBalance(tx *gorm.DB, account model.LedgerAccount, balance model.Money) error {
+	if balance.IsZero() {
+		return nil
+	}
+	err := tx.First(&model.LedgerAccount{}, "account_id = ?", account.AccountID).Error
+	if err == nil {
+		return nil // Already seeded
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to fetch ledger account: %v", err)
+	}
+	if balance.Currency == "" {
+		balance.Currency = model.DefaultCurrency
+	}
+
+	now := time.Now()
+	system := model.SystemAccount(model.LedgerSystemOpeningBalance)
+	for _, acc := range []model.LedgerAccount{account, system} {
+		acc.Currency = balance.Currency
+		acc.CreatedAt = now
+		if err := tx.Where("account_id = ?", acc.AccountID).FirstOrCreate(&acc).Error; err != nil {
+			return fmt.Errorf("failed to open ledger account %s: %v", acc.AccountID, err)
+		}
+	}
+
+	entry := model.JournalEntry{
+		JournalEntryID: utils.GenerateUniqueID(),
+		EntryType:      model.JournalEntryOpeningBalance,
+		Description:    "Balance carried over from before the ledger",
+		CreatedAt:      now,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to create opening balance entry: %v", err)
+	}
+
+	postings := []model.Posting{
+		{PostingID: utils.GenerateUniqueID(), JournalEntryID: entry.JournalEntryID, AccountID: account.AccountID, Amount: balance, CreatedAt: now},
+		{PostingID: utils.GenerateUniqueID(), JournalEntryID: entry.JournalEntryID, AccountID: system.AccountID, Amount: balance.Neg(), CreatedAt: now},
+	}
+	if err := tx.Create(&postings).Error; err != nil {
+		return fmt.Errorf("failed to create opening balance postings: %v", err)
+	}
+
+	log.Printf("Seeded opening balance %s for %s.\n", balance, account.AccountID)
+	return nil
+}
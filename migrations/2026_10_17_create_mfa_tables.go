@@ -0,0 +1,15 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateMFA creates the TOTP enrollment and recovery code tables.
+func MigrateMFA(db *gorm.DB) error {
+	if err := migrateTable(db, &model.MFAEnrollment{}, "MFAEnrollments"); err != nil {
+		return err
+	}
+	return migrateTable(db, &model.MFARecoveryCode{}, "MFARecoveryCodes")
+}
@@ -0,0 +1,12 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigratePasswordResetTokens creates the table of outstanding password reset tokens.
+func MigratePasswordResetTokens(db *gorm.DB) error {
+	return migrateTable(db, &model.PasswordResetToken{}, "PasswordResetTokens")
+}
@@ -0,0 +1,15 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateSagas creates the tables that persist saga progress.
+func MigrateSagas(db *gorm.DB) error {
+	if err := migrateTable(db, &model.Saga{}, "Sagas"); err != nil {
+		return err
+	}
+	return migrateTable(db, &model.SagaStepLog{}, "SagaSteps")
+}
@@ -0,0 +1,13 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateTransactionStatusHistory creates the table that records every
+// transaction status transition.
+func MigrateTransactionStatusHistory(db *gorm.DB) error {
+	return migrateTable(db, &model.TransactionStatusHistory{}, "TransactionStatusHistory")
+}
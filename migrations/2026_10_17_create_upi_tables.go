@@ -0,0 +1,16 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateUPI adds the holder_name column to PaymentMethods and creates the
+// UPI collect request table.
+func MigrateUPI(db *gorm.DB) error {
+	if err := migrateTable(db, &model.PaymentMethod{}, "PaymentMethods"); err != nil {
+		return err
+	}
+	return migrateTable(db, &model.UPICollectRequest{}, "UPICollectRequests")
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// MigrateVault creates the vault table and adds the token, fingerprint and
+// last-four columns to PaymentMethods. Plaintext numbers already stored are
+// moved into the vault at startup by PaymentMethodService.VaultLegacyNumbers,
+// since that needs the KMS.
+func MigrateVault(db *gorm.DB) error {
+	if err := migrateTable(db, &model.VaultEntry{}, "VaultEntries"); err != nil {
+		return err
+	}
+	return migrateTable(db, &model.PaymentMethod{}, "PaymentMethods")
+}
@@ -2,10 +2,13 @@ package model
 
 import "time"
 
-// AuditLog represents an entry in the audit log, tracking actions on transactions.
+// AuditLog represents an entry in the audit log, tracking actions on transactions
+// and security events on accounts.
 type AuditLog struct {
 	AuditLogID    string    `gorm:"primaryKey;size:36"` // Unique identifier for the audit log
 	TransactionID string    `gorm:"size:36;index"`      // Associated transaction ID
+	UserID        string    `gorm:"size:36;index"`      // Account the action concerns, for non-transaction events
+	ActorID       string    `gorm:"size:36"`            // Who performed the action, when not the account owner
 	Action        string    `gorm:"size:255;not null"`  // Description of the action performed
 	CreatedAt     time.Time `gorm:"autoCreateTime"`     // Timestamp when the log entry was created
 	Details       string    `gorm:"size:255"`           // Additional details of the action
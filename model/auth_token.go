@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// RefreshToken is one link in a session's chain of rotating refresh tokens.
+// Only a hash of the token is stored. Each refresh revokes the presented
+// token and issues a new one in the same session.
+type RefreshToken struct {
+	RefreshTokenID string     `gorm:"primaryKey;size:64"`     // SHA-256 of the opaque refresh token
+	UserID         string     `gorm:"size:36;not null;index"` // Owner of the session
+	SessionID      string     `gorm:"size:36;not null;index"` // Login session the token belongs to
+	AccessTokenID  string     `gorm:"size:36"`                // jti of the access token issued alongside it
+	ExpiresAt      time.Time  `gorm:"index"`                  // After this the token can no longer be used
+	RevokedAt      *time.Time // Set once the token is rotated or the session logged out
+	ReplacedBy     string     `gorm:"size:64"` // Hash of the token that replaced this one on rotation
+	CreatedAt      time.Time  `gorm:"autoCreateTime"`
+}
+
+// TableName explicitly sets the table name to "RefreshTokens"
+func (RefreshToken) TableName() string {
+	return "RefreshTokens"
+}
+
+// RevokedToken is an entry in the access token revocation list. Entries are
+// kept until the token would have expired anyway.
+type RevokedToken struct {
+	TokenID   string    `gorm:"primaryKey;size:36"` // jti of the revoked access token
+	UserID    string    `gorm:"size:36;not null;index"`
+	ExpiresAt time.Time `gorm:"index"` // Expiry of the revoked token
+	RevokedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// TableName explicitly sets the table name to "RevokedTokens"
+func (RevokedToken) TableName() string {
+	return "RevokedTokens"
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// Bank account verification states.
+const (
+	BankVerificationPending  = "pending"
+	BankVerificationVerified = "verified"
+	BankVerificationFailed   = "failed" // Out of attempts
+)
+
+// BankAccountVerification tracks the micro-deposits sent to prove a payer
+// owns a bank account. The payment method stays pending_verification until
+// the payer confirms both amounts.
+type BankAccountVerification struct {
+	PaymentMethodID string    `gorm:"primaryKey;size:36"`                     // Bank account being verified
+	PayerID         string    `gorm:"size:36;not null;index"`                 // Owner of the account
+	Fingerprint     string    `gorm:"size:64;index"`                          // Keyed hash of the account number, so attempts add up across re-adds
+	FirstAmount     Money     `gorm:"embedded;embeddedPrefix:first_amount_"`  // First test credit
+	SecondAmount    Money     `gorm:"embedded;embeddedPrefix:second_amount_"` // Second test credit
+	RailReference   string    `gorm:"size:64"`                                // Reference returned by the bank rail; empty until the deposits are sent
+	Attempts        int       `gorm:"not null;default:0"`                     // Wrong confirmations so far
+	Status          string    `gorm:"size:20;not null"`                       // pending, verified or failed
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+}
+
+// TableName explicitly sets the table name to "BankAccountVerifications"
+func (BankAccountVerification) TableName() string {
+	return "BankAccountVerifications"
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// Cheque states. A cheque is deposited, presented to the drawer's bank
+// (in_clearing) and then either cleared or bounced.
+const (
+	ChequeDeposited  = "deposited"
+	ChequeInClearing = "in_clearing"
+	ChequeCleared    = "cleared"
+	ChequeBounced    = "bounced"
+)
+
+// Cheque tracks a cheque payment through clearing. While it is in clearing
+// the amount sits in the payee's uncleared account; it becomes available when
+// the cheque clears and goes back to the payer if it bounces.
+type Cheque struct {
+	ChequeID        string     `gorm:"primaryKey;size:36"`                  // Unique identifier for the cheque
+	TransactionID   string     `gorm:"size:36;not null;index"`              // Transaction the cheque pays
+	PayerID         string     `gorm:"size:36;not null;index"`              // Drawer of the cheque
+	PayeeID         string     `gorm:"size:36;not null;index"`              // Who deposited it
+	PaymentMethodID string     `gorm:"size:36;not null;index"`              // Payer's cheque payment method
+	ChequeNumber    string     `gorm:"size:20;not null"`                    // Number printed on the cheque
+	Amount          Money      `gorm:"embedded;embeddedPrefix:amount_"`     // Amount of the cheque
+	Status          string     `gorm:"size:20;not null;index"`              // deposited, in_clearing, cleared or bounced
+	ClearsAt        *time.Time `gorm:"index"`                               // When clearing completes unless it bounces first
+	BounceReason    string     `gorm:"size:255"`                            // Why the cheque was returned
+	BounceFee       Money      `gorm:"embedded;embeddedPrefix:bounce_fee_"` // Fee charged to the payer for the bounce
+	ClearedAt       *time.Time // When the funds became available to the payee
+	BouncedAt       *time.Time // When the cheque was returned
+	CreatedAt       time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime"`
+}
+
+// TableName explicitly sets the table name to "Cheques"
+func (Cheque) TableName() string {
+	return "Cheques"
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// Idempotency record states.
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyKey stores the outcome of a mutating request so that a client
+// retrying with the same Idempotency-Key header gets the original response.
+type IdempotencyKey struct {
+	IdempotencyKeyID string    `gorm:"primaryKey;size:64"` // SHA-256 of user, method, path and client key
+	UserID           string    `gorm:"size:36;not null;index"`
+	Key              string    `gorm:"size:255;not null"` // Key as sent by the client
+	Method           string    `gorm:"size:10;not null"`  // HTTP method of the original request
+	Path             string    `gorm:"size:255;not null"` // Request path of the original request
+	RequestHash      string    `gorm:"size:64;not null"`  // SHA-256 fingerprint of the request body
+	State            string    `gorm:"size:20;not null"`  // in_progress or completed
+	StatusCode       int       // HTTP status of the stored response
+	ResponseBody     string    // Stored response body, replayed on retry
+	CreatedAt        time.Time `gorm:"autoCreateTime"`
+	LockedAt         time.Time // When the request holding an in_progress key took it; a stale lease may be taken over
+	ExpiresAt        time.Time `gorm:"index"` // After this the key may be reused
+}
+
+// TableName explicitly sets the table name to "IdempotencyKeys"
+func (IdempotencyKey) TableName() string {
+	return "IdempotencyKeys"
+}
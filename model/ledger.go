@@ -0,0 +1,128 @@
+package model
+
+import "time"
+
+// Ledger account owners and purposes. A payer has an "available" account that
+// backs Payer.Balance and a "held" account for reserved funds; a payee has an
+// "available" account that backs Payee.Balance and an "uncleared" account
+// for cheques still in clearing.
+const (
+	LedgerOwnerPayer  = "payer"
+	LedgerOwnerPayee  = "payee"
+	LedgerOwnerSystem = "system"
+
+	LedgerPurposeAvailable = "available"
+	LedgerPurposeHeld      = "held"
+	LedgerPurposeUncleared = "uncleared"
+)
+
+// System accounts that sit on the other side of money entering or leaving customer accounts.
+const (
+	LedgerSystemFunding        = "funding"         // External money deposited into payer accounts
+	LedgerSystemSettlement     = "settlement"      // Money credited to payees from outside the platform
+	LedgerSystemAdjustments    = "adjustments"     // Manual balance corrections
+	LedgerSystemOpeningBalance = "opening_balance" // Balances that existed before the ledger was introduced
+	LedgerSystemFees           = "fees"            // Fees charged to customers
+)
+
+// Journal entry types, one per kind of money movement.
+const (
+	JournalEntryDeposit        = "deposit"
+	JournalEntryReserve        = "reserve"
+	JournalEntryRelease        = "release"
+	JournalEntryPayment        = "payment"
+	JournalEntryCredit         = "credit"
+	JournalEntryRefund         = "refund"
+	JournalEntryAdjustment     = "adjustment"
+	JournalEntryOpeningBalance = "opening_balance"
+	JournalEntryChequeDeposit  = "cheque_deposit"
+	JournalEntryChequeClearing = "cheque_clearing"
+	JournalEntryChequeReturn   = "cheque_return"
+	JournalEntryFee            = "fee"
+	JournalEntryReversal       = "reversal"
+)
+
+// LedgerAccount is a bucket of money owned by a payer, a payee or the system.
+type LedgerAccount struct {
+	AccountID string    `gorm:"primaryKey;size:100"` // Deterministic ID: owner_type:owner_id:purpose
+	OwnerType string    `gorm:"size:20;not null"`    // payer, payee or system
+	OwnerID   string    `gorm:"size:36;index"`       // PayerID/PayeeID, or the system account name
+	Purpose   string    `gorm:"size:20;not null"`    // available, held, ...
+	Currency  string    `gorm:"size:3;not null"`     // Every posting to the account must be in this currency
+	CreatedAt time.Time `gorm:"autoCreateTime"`      // Timestamp for when the account was opened
+}
+
+// TableName explicitly sets the table name to "LedgerAccounts"
+func (LedgerAccount) TableName() string {
+	return "LedgerAccounts"
+}
+
+// JournalEntry groups the postings of a single money movement.
+type JournalEntry struct {
+	JournalEntryID string    `gorm:"primaryKey;size:36"` // Unique identifier for the entry
+	TransactionID  string    `gorm:"size:36;index"`      // Transaction that caused the movement, if any
+	EntryType      string    `gorm:"size:30;not null"`   // deposit, reserve, payment, refund, adjustment, ...
+	Description    string    `gorm:"size:255"`           // Human readable reason for the movement
+	CreatedAt      time.Time `gorm:"autoCreateTime"`     // Timestamp for when the entry was posted
+}
+
+// TableName explicitly sets the table name to "JournalEntries"
+func (JournalEntry) TableName() string {
+	return "JournalEntries"
+}
+
+// Posting is one leg of a journal entry. Positive amounts increase the
+// account's balance; the postings of an entry always sum to zero.
+type Posting struct {
+	PostingID      string    `gorm:"primaryKey;size:36"`              // Unique identifier for the posting
+	JournalEntryID string    `gorm:"size:36;not null;index"`          // Entry this posting belongs to
+	AccountID      string    `gorm:"size:100;not null;index"`         // Account whose balance changes
+	Amount         Money     `gorm:"embedded;embeddedPrefix:amount_"` // Signed amount applied to the account
+	CreatedAt      time.Time `gorm:"autoCreateTime"`                  // Timestamp for when the posting was written
+}
+
+// TableName explicitly sets the table name to "Postings"
+func (Posting) TableName() string {
+	return "Postings"
+}
+
+// PayerAccount returns the account backing a payer's available balance.
+func PayerAccount(payerID string) LedgerAccount {
+	return newLedgerAccount(LedgerOwnerPayer, payerID, LedgerPurposeAvailable)
+}
+
+// PayerHoldAccount returns the account holding a payer's reserved funds.
+func PayerHoldAccount(payerID string) LedgerAccount {
+	return newLedgerAccount(LedgerOwnerPayer, payerID, LedgerPurposeHeld)
+}
+
+// PayeeAccount returns the account backing a payee's available balance.
+func PayeeAccount(payeeID string) LedgerAccount {
+	return newLedgerAccount(LedgerOwnerPayee, payeeID, LedgerPurposeAvailable)
+}
+
+// PayeeUnclearedAccount returns the account holding cheque funds that have
+// not cleared yet. It does not count towards Payee.Balance.
+func PayeeUnclearedAccount(payeeID string) LedgerAccount {
+	return newLedgerAccount(LedgerOwnerPayee, payeeID, LedgerPurposeUncleared)
+}
+
+// SystemAccount returns one of the platform's own accounts.
+func SystemAccount(name string) LedgerAccount {
+	return newLedgerAccount(LedgerOwnerSystem, name, LedgerPurposeAvailable)
+}
+
+// IsCustomerAccount reports whether the account belongs to a payer or payee
+// and therefore may never go negative.
+func (a LedgerAccount) IsCustomerAccount() bool {
+	return a.OwnerType == LedgerOwnerPayer || a.OwnerType == LedgerOwnerPayee
+}
+
+func newLedgerAccount(ownerType, ownerID, purpose string) LedgerAccount {
+	return LedgerAccount{
+		AccountID: ownerType + ":" + ownerID + ":" + purpose,
+		OwnerType: ownerType,
+		OwnerID:   ownerID,
+		Purpose:   purpose,
+	}
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// MFAEnrollment holds a user's TOTP secret. MFA is enforced once the
+// enrollment is confirmed with a first valid code.
+type MFAEnrollment struct {
+	UserID       string     `gorm:"primaryKey;size:36"` // User the secret belongs to
+	Secret       string     `gorm:"size:64;not null"`   // Base32 TOTP secret
+	ConfirmedAt  *time.Time // Set when the user proves the authenticator works
+	LastUsedStep int64      // Last accepted TOTP time step, so a code works only once
+	CreatedAt    time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime"`
+}
+
+// TableName explicitly sets the table name to "MFAEnrollments"
+func (MFAEnrollment) TableName() string {
+	return "MFAEnrollments"
+}
+
+// MFARecoveryCode is a single-use code that stands in for a TOTP code when the
+// authenticator is lost. Only a hash of the code is stored.
+type MFARecoveryCode struct {
+	CodeID    string     `gorm:"primaryKey;size:64"`     // SHA-256 of the normalized code
+	UserID    string     `gorm:"size:36;not null;index"` // Owner of the code
+	UsedAt    *time.Time // Set once the code is redeemed
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+// TableName explicitly sets the table name to "MFARecoveryCodes"
+func (MFARecoveryCode) TableName() string {
+	return "MFARecoveryCodes"
+}
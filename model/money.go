@@ -0,0 +1,225 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when a request or a legacy row carries no currency code.
+const DefaultCurrency = "INR"
+
+// ErrCurrencyMismatch is returned when arithmetic mixes two different currencies.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// currencyExponents maps supported ISO 4217 codes to the number of minor-unit digits.
+var currencyExponents = map[string]int{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"SGD": 2,
+	"AED": 2,
+	"JPY": 0,
+	"KWD": 3,
+}
+
+// Money is an exact monetary amount stored as integer minor units (paise, cents)
+// plus an ISO 4217 currency code. It is embedded into models as two columns.
+type Money struct {
+	MinorUnits int64  `gorm:"not null;default:0" json:"minor_units"` // Amount in the currency's smallest unit
+	Currency   string `gorm:"size:3" json:"currency"`                // ISO 4217 currency code (e.g. INR)
+}
+
+// NewMoney builds a Money value from minor units and a currency code.
+func NewMoney(minorUnits int64, currency string) Money {
+	return Money{MinorUnits: minorUnits, Currency: strings.ToUpper(currency)}
+}
+
+// ZeroMoney returns a zero amount in the given currency.
+func ZeroMoney(currency string) Money {
+	return NewMoney(0, currency)
+}
+
+// CurrencyExponent returns the number of minor-unit digits for a currency.
+func CurrencyExponent(currency string) (int, error) {
+	exp, ok := currencyExponents[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+	return exp, nil
+}
+
+// ParseMoney parses a decimal string such as "10.50" into Money. It rejects
+// values with more fractional digits than the currency allows.
+func ParseMoney(value, currency string) (Money, error) {
+	exp, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	value = strings.TrimSpace(value)
+	digits := value
+	negative := strings.HasPrefix(digits, "-")
+	if negative || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+
+	// At most one leading sign, then digits with an optional decimal point
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole+frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places for %s", value, exp, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %v", value, err)
+	}
+	if negative {
+		units = -units
+	}
+	return NewMoney(units, currency), nil
+}
+
+// MoneyFromFloat converts a legacy float64 amount into Money using its shortest
+// decimal representation, rounding half-to-even to the currency's minor unit.
+// The boolean result reports whether the conversion was exact.
+func MoneyFromFloat(value float64, currency string) (Money, bool, error) {
+	exp, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, false, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Money{}, false, fmt.Errorf("cannot convert %v to money", value)
+	}
+
+	rat, ok := new(big.Rat).SetString(strconv.FormatFloat(value, 'f', -1, 64))
+	if !ok {
+		return Money{}, false, fmt.Errorf("cannot convert %v to money", value)
+	}
+	rat.Mul(rat, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)))
+
+	quo, rem := new(big.Int).QuoRem(rat.Num(), rat.Denom(), new(big.Int))
+	exact := rem.Sign() == 0
+	if !exact {
+		// Round half-to-even on the remainder
+		twice := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2))
+		switch cmp := twice.Cmp(rat.Denom()); {
+		case cmp > 0, cmp == 0 && quo.Bit(0) == 1:
+			quo.Add(quo, big.NewInt(int64(rem.Sign())))
+		}
+	}
+	if !quo.IsInt64() {
+		return Money{}, false, fmt.Errorf("amount %v overflows minor units", value)
+	}
+	return NewMoney(quo.Int64(), currency), exact, nil
+}
+
+// Validate checks that the currency code is supported.
+func (m Money) Validate() error {
+	_, err := CurrencyExponent(m.Currency)
+	return err
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool { return m.MinorUnits == 0 }
+
+// IsPositive reports whether the amount is greater than zero.
+func (m Money) IsPositive() bool { return m.MinorUnits > 0 }
+
+// IsNegative reports whether the amount is below zero.
+func (m Money) IsNegative() bool { return m.MinorUnits < 0 }
+
+// Neg returns the amount with its sign flipped.
+func (m Money) Neg() Money { return Money{MinorUnits: -m.MinorUnits, Currency: m.Currency} }
+
+// Add returns m + other. A zero value with no currency (an unset balance)
+// adopts the currency of the other operand.
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.commonCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	sum := m.MinorUnits + other.MinorUnits
+	if (sum > m.MinorUnits) != (other.MinorUnits > 0) {
+		return Money{}, errors.New("money overflow")
+	}
+	return Money{MinorUnits: sum, Currency: currency}, nil
+}
+
+// Sub returns m - other.
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(other.Neg())
+}
+
+// Cmp compares m with other and returns -1, 0 or +1.
+func (m Money) Cmp(other Money) (int, error) {
+	if _, err := m.commonCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.MinorUnits < other.MinorUnits:
+		return -1, nil
+	case m.MinorUnits > other.MinorUnits:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// LessThan reports whether m < other; mismatched currencies return an error.
+func (m Money) LessThan(other Money) (bool, error) {
+	c, err := m.Cmp(other)
+	return c < 0, err
+}
+
+// Decimal renders the amount as a decimal string, e.g. "10.50".
+func (m Money) Decimal() string {
+	exp, err := CurrencyExponent(m.Currency)
+	if err != nil || exp == 0 {
+		return strconv.FormatInt(m.MinorUnits, 10)
+	}
+	sign := ""
+	units := m.MinorUnits
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	digits := fmt.Sprintf("%0*d", exp+1, units)
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// String renders the amount with its currency, e.g. "10.50 INR".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) commonCurrency(other Money) (string, error) {
+	switch {
+	case m.Currency == other.Currency:
+		return m.Currency, nil
+	case m.Currency == "" && m.MinorUnits == 0:
+		return other.Currency, nil
+	case other.Currency == "" && other.MinorUnits == 0:
+		return m.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s vs %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+}
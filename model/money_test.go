@@ -0,0 +1,145 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{"10.50", "INR", 1050, false},
+		{"10.5", "INR", 1050, false},
+		{"10", "INR", 1000, false},
+		{".75", "INR", 75, false},
+		{"7.", "INR", 700, false},
+		{"+5", "INR", 500, false},
+		{"-5.01", "INR", -501, false},
+		{"  3.00 ", "usd", 300, false},
+		{"1500", "JPY", 1500, false},
+		{"1.234", "KWD", 1234, false},
+		{"10.505", "INR", 0, true}, // Too many decimal places
+		{"1.5", "JPY", 0, true},
+		{"-+5", "INR", 0, true},
+		{"+-5", "INR", 0, true},
+		{"--5", "INR", 0, true},
+		{"5-", "INR", 0, true},
+		{"1.-5", "INR", 0, true},
+		{"1.2.3", "INR", 0, true},
+		{"1e3", "INR", 0, true},
+		{"abc", "INR", 0, true},
+		{"", "INR", 0, true},
+		{".", "INR", 0, true},
+		{"-", "INR", 0, true},
+		{"99999999999999999999", "INR", 0, true}, // Overflows int64
+		{"10.00", "XYZ", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value, tt.currency)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q, %q) = %v, want an error", tt.value, tt.currency, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMoney(%q, %q) failed: %v", tt.value, tt.currency, err)
+			continue
+		}
+		if got.MinorUnits != tt.want {
+			t.Errorf("ParseMoney(%q, %q) = %d minor units, want %d", tt.value, tt.currency, got.MinorUnits, tt.want)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	tests := []struct {
+		value     float64
+		currency  string
+		want      int64
+		wantExact bool
+	}{
+		{10.5, "INR", 1050, true},
+		{0.1, "INR", 10, true},
+		{0.125, "INR", 12, false}, // Half rounds to even
+		{0.135, "INR", 14, false},
+		{1.005, "INR", 100, false},
+		{-0.125, "INR", -12, false},
+		{-0.135, "INR", -14, false},
+		{0.126, "INR", 13, false},
+		{2.5, "JPY", 2, false},
+		{3.5, "JPY", 4, false},
+	}
+	for _, tt := range tests {
+		got, exact, err := MoneyFromFloat(tt.value, tt.currency)
+		if err != nil {
+			t.Errorf("MoneyFromFloat(%v, %q) failed: %v", tt.value, tt.currency, err)
+			continue
+		}
+		if got.MinorUnits != tt.want || exact != tt.wantExact {
+			t.Errorf("MoneyFromFloat(%v, %q) = %d, exact %v; want %d, exact %v", tt.value, tt.currency, got.MinorUnits, exact, tt.want, tt.wantExact)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	tenRupees := NewMoney(1000, "INR")
+
+	sum, err := tenRupees.Add(NewMoney(250, "inr"))
+	if err != nil || sum != NewMoney(1250, "INR") {
+		t.Errorf("Add = %v, %v; want 12.50 INR", sum, err)
+	}
+	diff, err := tenRupees.Sub(NewMoney(1500, "INR"))
+	if err != nil || diff.MinorUnits != -500 || !diff.IsNegative() {
+		t.Errorf("Sub = %v, %v; want -5.00 INR", diff, err)
+	}
+
+	// An unset balance adopts the other currency
+	if sum, err := (Money{}).Add(tenRupees); err != nil || sum != tenRupees {
+		t.Errorf("Money{}.Add = %v, %v; want 10.00 INR", sum, err)
+	}
+
+	if _, err := tenRupees.Add(NewMoney(100, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add across currencies: got %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := tenRupees.Cmp(NewMoney(100, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Cmp across currencies: got %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := (Money{MinorUnits: 5}).Add(tenRupees); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("non-zero amount without currency: got %v, want ErrCurrencyMismatch", err)
+	}
+
+	if _, err := NewMoney(1<<63-1, "INR").Add(NewMoney(1, "INR")); err == nil {
+		t.Error("Add past the int64 maximum succeeded")
+	}
+
+	if less, err := tenRupees.LessThan(NewMoney(1001, "INR")); err != nil || !less {
+		t.Errorf("LessThan = %v, %v; want true", less, err)
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(1050, "INR"), "10.50"},
+		{NewMoney(5, "INR"), "0.05"},
+		{NewMoney(-5, "INR"), "-0.05"},
+		{NewMoney(0, "INR"), "0.00"},
+		{NewMoney(1500, "JPY"), "1500"},
+		{NewMoney(1234, "KWD"), "1.234"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.want {
+			t.Errorf("%#v.Decimal() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+	if got := NewMoney(1050, "INR").String(); got != "10.50 INR" {
+		t.Errorf("String() = %q, want %q", got, "10.50 INR")
+	}
+}
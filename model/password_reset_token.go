@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// PasswordResetToken is a single-use, time-limited password reset token. Only
+// a hash of the token is stored.
+type PasswordResetToken struct {
+	TokenID   string     `gorm:"primaryKey;size:64"`     // SHA-256 of the token sent to the user
+	UserID    string     `gorm:"size:36;not null;index"` // User whose password the token resets
+	ExpiresAt time.Time  `gorm:"index"`                  // After this the token can no longer be used
+	UsedAt    *time.Time // Set once the token is redeemed or superseded
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+// TableName explicitly sets the table name to "PasswordResetTokens"
+func (PasswordResetToken) TableName() string {
+	return "PasswordResetTokens"
+}
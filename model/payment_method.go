@@ -2,18 +2,38 @@ package model
 
 import "time"
 
-// PaymentMethod represents a payment method associated with a payer.
+// PaymentMethod represents a payment method associated with a payer. Card
+// and account numbers live in the vault; the row only keeps their tokens and
+// the masked values that are safe to show.
 type PaymentMethod struct {
-	PaymentMethodID string    `gorm:"primaryKey;column:payment_method_id;size:36"` // Unique identifier for each payment method
-	PayerID         string    `gorm:"not null;index"`                              // Foreign key referencing the payer
-	MethodType      string    `gorm:"size:20;not null"`                            // Type of payment method (e.g., card, bank_transfer, wallet)
-	CardNumber      string    `gorm:"size:16"`                                     // Card number for card-based payments (only for card method)
-	ExpiryDate      string    `gorm:"size:5"`                                      // Expiry date for cards (e.g., "12/25", only for card method)
-	AccountNumber   string    `gorm:"size:20"`                                     // Account number for bank transfer (only for bank transfer method)
-	Details         string    `gorm:"size:255;not null"`                           // Details (tokenized or masked payment info)
-	Status          string    `gorm:"size:20;not null"`                            // Status of the payment method (e.g., active, inactive)
-	CreatedAt       time.Time `gorm:"autoCreateTime"`                              // Timestamp for when the payment method was created
-	UpdatedAt       time.Time `gorm:"autoUpdateTime"`                              // Timestamp for when the payment method was last updated
+	PaymentMethodID string              `gorm:"primaryKey;column:payment_method_id;size:36"` // Unique identifier for each payment method
+	PayerID         string              `gorm:"not null;index"`                              // Foreign key referencing the payer
+	MethodType      string              `gorm:"size:20;not null"`                            // Type of payment method (e.g., card, bank_transfer, wallet)
+	CardNumber      string              `gorm:"-" json:"-"`                                  // Card number as submitted; never stored, see CardToken
+	CardToken       string              `gorm:"size:64" json:"-"`                            // Vault token for the card number (only for card method)
+	ExpiryDate      string              `gorm:"size:5"`                                      // Expiry date for cards (e.g., "12/25", only for card method)
+	AccountNumber   string              `gorm:"-" json:"-"`                                  // Account number as submitted; never stored, see AccountToken
+	AccountToken    string              `gorm:"size:64" json:"-"`                            // Vault token for the account number (only for bank transfer method)
+	Fingerprint     string              `gorm:"size:64;index" json:"-"`                      // Keyed hash of the card or account number, for duplicate checks
+	Last4           string              `gorm:"size:4"`                                      // Last four digits of the card or account number, for display
+	Brand           string              `gorm:"size:20"`                                     // Card network detected from the BIN (e.g., visa, amex, rupay)
+	Details         string              `gorm:"size:255;not null"`                           // Details (tokenized or masked payment info)
+	HolderName      string              `gorm:"size:255"`                                    // Account holder's name as resolved by the PSP (only for UPI method)
+	Status          PaymentMethodStatus `gorm:"size:20;not null"`                            // Lifecycle state (see PaymentMethodStatus)
+	IsDefault       bool                `gorm:"not null;default:false"`                      // Used for payments that do not name a payment method; one per payer
+	RemovedAt       *time.Time          // When the owner removed the method
+	CreatedAt       time.Time           `gorm:"autoCreateTime"` // Timestamp for when the payment method was created
+	UpdatedAt       time.Time           `gorm:"autoUpdateTime"` // Timestamp for when the payment method was last updated
+}
+
+// PaymentMethodUpdate lists the fields an owner may change on a payment method.
+// Nil fields are left as they are; anything else on the row is not updatable.
+type PaymentMethodUpdate struct {
+	ExpiryDate *string `json:"expiry_date,omitempty"` // Cards only, MM/YY
+	Details    *string `json:"details,omitempty"`     // UPI ID, wallet ID or chequebook account; label for cards and bank accounts
+	Status     *string `json:"status,omitempty"`      // active or suspended
+	CardNumber *string `json:"card_number,omitempty"` // Cards only; with CVV, needed to bring an expired card back
+	CVV        *string `json:"cvv,omitempty"`         // Checked against the card's brand, never stored
 }
 
 // TableName explicitly sets the table name to "PaymentMethods"
@@ -0,0 +1,46 @@
+package model
+
+import "fmt"
+
+// PaymentMethodStatus is the lifecycle state of a payment method.
+type PaymentMethodStatus string
+
+const (
+	PaymentMethodPendingVerification PaymentMethodStatus = "pending_verification" // Added, ownership not yet proven
+	PaymentMethodActive              PaymentMethodStatus = "active"               // Usable for payments
+	PaymentMethodSuspended           PaymentMethodStatus = "suspended"            // Paused by the owner or by us
+	PaymentMethodExpired             PaymentMethodStatus = "expired"              // Card past its expiry month
+	PaymentMethodRemoved             PaymentMethodStatus = "removed"              // Deleted by the owner; secrets purged
+)
+
+// paymentMethodTransitions is the table of legal status moves. The empty
+// status stands for a payment method that has not been created yet.
+var paymentMethodTransitions = map[PaymentMethodStatus][]PaymentMethodStatus{
+	"":                               {PaymentMethodPendingVerification, PaymentMethodActive},
+	PaymentMethodPendingVerification: {PaymentMethodActive, PaymentMethodRemoved},
+	PaymentMethodActive:              {PaymentMethodSuspended, PaymentMethodExpired, PaymentMethodRemoved},
+	PaymentMethodSuspended:           {PaymentMethodActive, PaymentMethodExpired, PaymentMethodRemoved},
+	PaymentMethodExpired:             {PaymentMethodActive, PaymentMethodRemoved},
+}
+
+// CanTransitionTo reports whether moving from s to next is allowed.
+func (s PaymentMethodStatus) CanTransitionTo(next PaymentMethodStatus) bool {
+	for _, allowed := range paymentMethodTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTransition returns an error describing an illegal move.
+func (s PaymentMethodStatus) ValidateTransition(next PaymentMethodStatus) error {
+	if !s.CanTransitionTo(next) {
+		from := s
+		if from == "" {
+			from = "new"
+		}
+		return fmt.Errorf("payment method cannot move from %s to %s", from, next)
+	}
+	return nil
+}
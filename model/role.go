@@ -0,0 +1,18 @@
+package model
+
+// User roles carried in access token claims.
+const (
+	RoleCustomer = "customer" // Default for every signup
+	RoleSupport  = "support"  // Helps customers, e.g. unlocks accounts
+	RoleFinance  = "finance"  // Reconciles money movement
+	RoleAdmin    = "admin"    // Full access, including balance adjustments
+)
+
+// IsValidRole reports whether role is one of the defined roles.
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleCustomer, RoleSupport, RoleFinance, RoleAdmin:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+// Saga kinds.
+const (
+	SagaKindPayment = "payment" // InitializeTransaction: create, check balance, reserve, process, complete
+)
+
+// Saga states. A saga that fails part way, or is interrupted before its
+// pivot step, is compensated; one interrupted after the pivot is finished.
+const (
+	SagaRunning      = "running"
+	SagaCompleted    = "completed"
+	SagaCompensating = "compensating" // Also where a saga waits when a compensation failed
+	SagaCompensated  = "compensated"
+)
+
+// Saga step outcomes.
+const (
+	SagaStepCompleted   = "completed"
+	SagaStepFailed      = "failed"
+	SagaStepCompensated = "compensated"
+)
+
+// Saga tracks a multi-step operation across separate database transactions
+// so it can be finished or undone after a failure or a restart.
+type Saga struct {
+	SagaID        string    `gorm:"primaryKey;size:36"`     // Unique identifier for the saga
+	Kind          string    `gorm:"size:30;not null"`       // Which steps the saga runs
+	TransactionID string    `gorm:"size:36;not null;index"` // Transaction the saga works on
+	Status        string    `gorm:"size:20;not null;index"` // running, completed, compensating or compensated
+	CurrentStep   string    `gorm:"size:50"`                // Step started most recently
+	LastError     string    `gorm:"size:1024"`              // Why the saga is compensating, or why compensation stalled
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime;index"`
+}
+
+// TableName explicitly sets the table name to "Sagas"
+func (Saga) TableName() string {
+	return "Sagas"
+}
+
+// SagaStepLog records the outcome of one step of a saga.
+type SagaStepLog struct {
+	SagaStepID string    `gorm:"primaryKey;size:36"`     // Unique identifier for the entry
+	SagaID     string    `gorm:"size:36;not null;index"` // Saga the step belongs to
+	Step       string    `gorm:"size:50;not null"`       // Step name
+	Status     string    `gorm:"size:20;not null"`       // completed, failed or compensated
+	Error      string    `gorm:"size:1024"`              // Error of a failed step
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// TableName explicitly sets the table name to "SagaSteps"
+func (SagaStepLog) TableName() string {
+	return "SagaSteps"
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransactionStatus is the lifecycle state of a transaction.
+type TransactionStatus string
+
+const (
+	StatusPending           TransactionStatus = "Pending"           // Created, nothing moved yet
+	StatusReserved          TransactionStatus = "Reserved"          // Funds held for an immediate payment
+	StatusAuthorized        TransactionStatus = "Authorized"        // Funds held until captured, voided or expired
+	StatusPartiallyCaptured TransactionStatus = "PartiallyCaptured" // Part of an authorization paid to the payee
+	StatusCompleted         TransactionStatus = "Completed"         // Money settled to the payee
+	StatusFailed            TransactionStatus = "Failed"            // Processing failed, any hold released
+	StatusVoided            TransactionStatus = "Voided"            // Authorization cancelled before capture
+	StatusExpired           TransactionStatus = "Expired"           // Authorization hold lapsed before capture
+	StatusPartiallyRefunded TransactionStatus = "PartiallyRefunded" // Some of a completed payment refunded
+	StatusRefunded          TransactionStatus = "Refunded"          // Completed payment fully refunded
+	StatusReversed          TransactionStatus = "Reversed"          // Completed payment undone by saga compensation
+)
+
+// statusTransitions is the central table of legal status moves. The empty
+// status stands for a transaction that has not been created yet.
+var statusTransitions = map[TransactionStatus][]TransactionStatus{
+	"":                      {StatusPending, StatusCompleted},
+	StatusPending:           {StatusReserved, StatusAuthorized, StatusCompleted, StatusFailed},
+	StatusReserved:          {StatusCompleted, StatusFailed},
+	StatusAuthorized:        {StatusPartiallyCaptured, StatusCompleted, StatusVoided, StatusExpired, StatusFailed},
+	StatusPartiallyCaptured: {StatusPartiallyCaptured, StatusCompleted},
+	StatusCompleted:         {StatusPartiallyRefunded, StatusRefunded, StatusReversed},
+	StatusPartiallyRefunded: {StatusPartiallyRefunded, StatusRefunded},
+}
+
+// IsKnown reports whether s is one of the defined statuses.
+func (s TransactionStatus) IsKnown() bool {
+	switch s {
+	case StatusPending, StatusReserved, StatusAuthorized, StatusPartiallyCaptured, StatusCompleted,
+		StatusFailed, StatusVoided, StatusExpired, StatusPartiallyRefunded, StatusRefunded, StatusReversed:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether moving from s to next is allowed.
+func (s TransactionStatus) CanTransitionTo(next TransactionStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether no further transitions are possible.
+func (s TransactionStatus) IsTerminal() bool {
+	return s != "" && len(statusTransitions[s]) == 0
+}
+
+// ValidateTransition returns an error describing an illegal move.
+func (s TransactionStatus) ValidateTransition(next TransactionStatus) error {
+	if !s.CanTransitionTo(next) {
+		from := s
+		if from == "" {
+			from = "new"
+		}
+		return fmt.Errorf("illegal transaction status transition %s -> %s", from, next)
+	}
+	return nil
+}
+
+// TransactionStatusHistory records every status transition of a transaction.
+type TransactionStatusHistory struct {
+	StatusHistoryID string            `gorm:"primaryKey;size:36"`     // Unique identifier for the history entry
+	TransactionID   string            `gorm:"size:36;not null;index"` // Transaction whose status changed
+	FromStatus      TransactionStatus `gorm:"size:20"`                // Previous status, empty on creation
+	ToStatus        TransactionStatus `gorm:"size:20;not null"`       // New status
+	Reason          string            `gorm:"size:255"`               // Why the status changed
+	CreatedAt       time.Time         `gorm:"autoCreateTime"`         // When the transition happened
+}
+
+// TableName explicitly sets the table name to "TransactionStatusHistory"
+func (TransactionStatusHistory) TableName() string {
+	return "TransactionStatusHistory"
+}
@@ -0,0 +1,82 @@
+package model
+
+import "testing"
+
+func TestValidateTransition(t *testing.T) {
+	tests := []struct {
+		from, to TransactionStatus
+		allowed  bool
+	}{
+		{"", StatusPending, true},
+		{"", StatusCompleted, true},
+		{"", StatusReserved, false},
+		{StatusPending, StatusReserved, true},
+		{StatusPending, StatusAuthorized, true},
+		{StatusPending, StatusCompleted, true},
+		{StatusPending, StatusFailed, true},
+		{StatusPending, StatusRefunded, false},
+		{StatusReserved, StatusCompleted, true},
+		{StatusReserved, StatusFailed, true},
+		{StatusReserved, StatusPending, false},
+		{StatusAuthorized, StatusPartiallyCaptured, true},
+		{StatusAuthorized, StatusCompleted, true},
+		{StatusAuthorized, StatusVoided, true},
+		{StatusAuthorized, StatusExpired, true},
+		{StatusAuthorized, StatusReserved, false},
+		{StatusPartiallyCaptured, StatusPartiallyCaptured, true},
+		{StatusPartiallyCaptured, StatusCompleted, true},
+		{StatusPartiallyCaptured, StatusVoided, false},
+		{StatusCompleted, StatusPartiallyRefunded, true},
+		{StatusCompleted, StatusRefunded, true},
+		{StatusCompleted, StatusReversed, true},
+		{StatusCompleted, StatusFailed, false},
+		{StatusPartiallyRefunded, StatusPartiallyRefunded, true},
+		{StatusPartiallyRefunded, StatusRefunded, true},
+		{StatusPartiallyRefunded, StatusReversed, false},
+		{StatusFailed, StatusPending, false},
+		{StatusVoided, StatusCompleted, false},
+		{StatusExpired, StatusCompleted, false},
+		{StatusRefunded, StatusPartiallyRefunded, false},
+		{StatusReversed, StatusCompleted, false},
+	}
+	for _, tc := range tests {
+		err := tc.from.ValidateTransition(tc.to)
+		if tc.allowed && err != nil {
+			t.Errorf("%q -> %q: unexpected error %v", tc.from, tc.to, err)
+		}
+		if !tc.allowed && err == nil {
+			t.Errorf("%q -> %q: expected an error", tc.from, tc.to)
+		}
+		if got := tc.from.CanTransitionTo(tc.to); got != tc.allowed {
+			t.Errorf("%q.CanTransitionTo(%q) = %v, want %v", tc.from, tc.to, got, tc.allowed)
+		}
+	}
+}
+
+func TestValidateTransitionNamesNewTransactions(t *testing.T) {
+	err := TransactionStatus("").ValidateTransition(StatusRefunded)
+	if err == nil || err.Error() != "illegal transaction status transition new -> Refunded" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	terminal := map[TransactionStatus]bool{
+		StatusFailed: true, StatusVoided: true, StatusExpired: true, StatusRefunded: true, StatusReversed: true,
+	}
+	for _, s := range []TransactionStatus{StatusPending, StatusReserved, StatusAuthorized, StatusPartiallyCaptured,
+		StatusCompleted, StatusFailed, StatusVoided, StatusExpired, StatusPartiallyRefunded, StatusRefunded, StatusReversed} {
+		if !s.IsKnown() {
+			t.Errorf("%s is not known", s)
+		}
+		if got := s.IsTerminal(); got != terminal[s] {
+			t.Errorf("%s.IsTerminal() = %v, want %v", s, got, terminal[s])
+		}
+	}
+	if TransactionStatus("").IsTerminal() {
+		t.Error("a new transaction is not terminal")
+	}
+	if TransactionStatus("Bogus").IsKnown() {
+		t.Error("Bogus is known")
+	}
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// UPI collect request states.
+const (
+	CollectRequestPending  = "pending"
+	CollectRequestApproved = "approved"
+	CollectRequestDeclined = "declined"
+	CollectRequestExpired  = "expired"
+	CollectRequestFailed   = "failed" // The PSP could not deliver it
+)
+
+// UPICollectRequest is a payee asking a payer, by UPI ID, for money. The
+// payer approves or declines it in the window before ExpiresAt; the linked
+// transaction stays Pending until then.
+type UPICollectRequest struct {
+	CollectRequestID string     `gorm:"primaryKey;size:36"`              // Unique identifier for the request
+	TransactionID    string     `gorm:"size:36;not null;index"`          // Transaction the request settles
+	PayeeID          string     `gorm:"size:36;not null;index"`          // Who asked for the money
+	PayerID          string     `gorm:"size:36;not null;index"`          // Who is asked to pay
+	PayerVPA         string     `gorm:"size:255;not null"`               // UPI ID the request was sent to
+	PaymentMethodID  string     `gorm:"size:36;not null"`                // Payer's UPI payment method
+	Amount           Money      `gorm:"embedded;embeddedPrefix:amount_"` // Amount requested
+	Note             string     `gorm:"size:255"`                        // Shown to the payer
+	Status           string     `gorm:"size:20;not null"`                // pending, approved, declined, expired or failed
+	PSPReference     string     `gorm:"size:64"`                         // Reference returned by the PSP
+	ExpiresAt        time.Time  `gorm:"not null;index"`                  // The payer must respond before this
+	RespondedAt      *time.Time // When the payer approved or declined
+	CreatedAt        time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt        time.Time  `gorm:"autoUpdateTime"`
+}
+
+// TableName explicitly sets the table name to "UPICollectRequests"
+func (UPICollectRequest) TableName() string {
+	return "UPICollectRequests"
+}
@@ -10,6 +10,7 @@ type User struct {
 	FirstName    string    `gorm:"column:FirstName"`         // User's first name
 	LastName     string    `gorm:"column:LastName"`          // User's last name
 	IsVerified   bool      `gorm:"column:IsVerified"`        // Indicates if the user is verified
+	Role         string    `gorm:"column:Role;size:20"`      // Access role: customer, support, finance or admin
 	CreatedAt    time.Time `gorm:"column:CreatedAt"`         // When the user was created
 	UpdatedAt    time.Time `gorm:"column:UpdatedAt"`         // Last update timestamp for the user
 }
@@ -22,5 +23,5 @@ func (User) TableName() string {
 type EntityWithBalance struct {
 	ID string
 
-	Balance float64
+	Balance Money
 }
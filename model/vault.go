@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Kinds of secrets kept in the vault.
+const (
+	VaultKindCardNumber    = "card_number"
+	VaultKindAccountNumber = "account_number"
+)
+
+// VaultEntry is one encrypted secret, such as a card number. The value is
+// encrypted with its own data key, and the data key is stored wrapped by the
+// KMS key named in KeyID. The rest of the system only sees the token.
+type VaultEntry struct {
+	Token      string    `gorm:"primaryKey;size:64"`     // Opaque reference handed out instead of the value
+	PayerID    string    `gorm:"size:36;not null;index"` // Owner of the secret
+	Kind       string    `gorm:"size:20;not null"`       // card_number or account_number
+	Ciphertext string    `gorm:"size:512;not null"`      // Base64 AES-GCM nonce and ciphertext
+	WrappedKey string    `gorm:"size:512;not null"`      // Base64 data key wrapped by the KMS
+	KeyID      string    `gorm:"size:64;not null"`       // KMS key that wrapped the data key
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// TableName explicitly sets the table name to "VaultEntries"
+func (VaultEntry) TableName() string {
+	return "VaultEntries"
+}
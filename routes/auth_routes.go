@@ -33,34 +33,69 @@ package routes
 import (
 	"poc/controller"
 	"poc/middleware"
+	"poc/model"
 	"poc/services"
 
 	"github.com/kataras/iris/v12"
 )
 
 // RegisterAuthRoutes registers the authentication-related routes.
-func RegisterAuthRoutes(app *iris.Application, svc *services.UserService) {
+func RegisterAuthRoutes(app *iris.Application, svc *services.UserService, mfaSvc *services.MFAService, throttle *services.LoginThrottle, idempotencySvc *services.IdempotencyService) {
 	// Create a new instance of UserController
 	userController := &controller.UserController{
 		UserService: svc,
+		MFAService:  mfaSvc,
+		Throttle:    throttle,
+	}
+	mfaController := &controller.MFAController{
+		MFAService: mfaSvc,
 	}
 
 	// Public routes
-	app.Post("/signup", userController.Signup) // For signup
-	app.Post("/login", userController.Login)   // For login
-	app.Post("/logout", userController.Logout) // For logout
+	app.Post("/signup", userController.Signup)                   // For signup
+	app.Post("/login", userController.Login)                     // For login
+	app.Post("/login/mfa", userController.LoginMFA)              // Second login step when MFA is enabled
+	app.Post("/token/refresh", userController.RefreshToken)      // Rotate the refresh token
+	app.Get("/.well-known/jwks.json", controller.JWKSHandler)    // Public token verification keys
+	app.Get("/verify-email", userController.VerifyEmail)         // Follow an emailed verification link
+	app.Post("/password/forgot", userController.ForgotPassword)  // Email a password reset link
+	app.Get("/password/reset", userController.ResetPasswordForm) // Page the emailed reset link opens
+	app.Post("/password/reset", userController.ResetPassword)    // Redeem a password reset link
 
 	// Protected routes
 	auth := app.Party("/", middleware.AuthMiddleware)
+	idempotent := middleware.Idempotency(idempotencySvc, false)
+
+	// Revoke the current session, or all sessions
+	auth.Post("/logout", userController.Logout)
+
+	// Send a new email verification link
+	auth.Post("/verify-email/resend", userController.ResendVerificationEmail)
+
+	// Change password; signs out every session
+	auth.Put("/password", userController.ChangePassword)
+
+	// TOTP two-factor authentication
+	auth.Post("/mfa/enroll", mfaController.Enroll)
+	auth.Post("/mfa/confirm", mfaController.Confirm)
+	auth.Post("/mfa/disable", mfaController.Disable)
+	auth.Post("/mfa/recovery-codes", mfaController.RegenerateRecoveryCodes)
 
 	// Update user details
-	auth.Put("/user", userController.UpdateUser)
+	auth.Put("/user", idempotent, userController.UpdateUser)
+
+	// Staff routes; the role comes from the access token
+	admin := app.Party("/admin", middleware.AuthMiddleware)
+
+	// Lift a login lockout
+	admin.Post("/users/unlock", middleware.RequireRole(model.RoleAdmin, model.RoleSupport), userController.UnlockAccount)
 
-	// Update payer balance
-	auth.Put("/payer", userController.UpdatePayer)
+	// Grant a role
+	admin.Put("/users/{userID}/role", middleware.RequireRole(model.RoleAdmin), userController.SetRole)
 
-	// Update payee balance
-	auth.Put("/payee", userController.UpdatePayee)
+	// Balance adjustments; a reason is required and recorded
+	admin.Put("/payers/{payerID}/balance", middleware.RequireRole(model.RoleAdmin), idempotent, userController.UpdatePayer)
+	admin.Put("/payees/{payeeID}/balance", middleware.RequireRole(model.RoleAdmin), idempotent, userController.UpdatePayee)
 
 	// Example protected route
 	auth.Get("/profile", func(ctx iris.Context) {
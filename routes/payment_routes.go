@@ -8,12 +8,15 @@ import (
 	"github.com/kataras/iris/v12"
 )
 
-func RegisterPaymentRoutes(app *iris.Application, svc *services.PaymentMethodService) {
+func RegisterPaymentRoutes(app *iris.Application, svc *services.PaymentMethodService, idempotencySvc *services.IdempotencyService, userSvc *services.UserService) {
 	// Protected routes for payment methods
 	auth := app.Party("/payment-methods", middleware.AuthMiddleware) // Apply authentication middleware
+	idempotent := middleware.Idempotency(idempotencySvc, false)
+	verified := middleware.RequireVerifiedEmail(userSvc)
+	owner := middleware.RequirePaymentMethodOwner(svc)
 	{
-		// Route for creating a payment method
-		auth.Post("/", func(ctx iris.Context) {
+		// Route for creating a payment method; needs a verified email
+		auth.Post("/", verified, idempotent, func(ctx iris.Context) {
 			controller.CreatePaymentMethodHandler(svc, ctx)
 		})
 
@@ -22,13 +25,30 @@ func RegisterPaymentRoutes(app *iris.Application, svc *services.PaymentMethodSer
 			controller.GetPaymentMethodHandler(svc, ctx)
 		})
 
+		// Routes below act on a single payment method and check it belongs to the caller
+
 		// Route for updating payment method
-		auth.Put("/{paymentMethodID}", func(ctx iris.Context) {
+		auth.Put("/{paymentMethodID}", owner, idempotent, func(ctx iris.Context) {
 			controller.UpdatePaymentMethodHandler(svc, ctx)
 		})
 
+		// Route for confirming bank account micro-deposits
+		auth.Post("/{paymentMethodID}/verify", owner, idempotent, func(ctx iris.Context) {
+			controller.VerifyBankAccountHandler(svc, ctx)
+		})
+
+		// Route for removing payment method
+		auth.Delete("/{paymentMethodID}", owner, idempotent, func(ctx iris.Context) {
+			controller.DeletePaymentMethodHandler(svc, ctx)
+		})
+
+		// Route for making a payment method the default
+		auth.Put("/{paymentMethodID}/default", owner, func(ctx iris.Context) {
+			controller.SetDefaultPaymentMethodHandler(svc, ctx)
+		})
+
 		// Route for validating payment method
-		auth.Post("/validate/{paymentMethodID}", func(ctx iris.Context) {
+		auth.Post("/validate/{paymentMethodID}", owner, idempotent, func(ctx iris.Context) {
 			controller.ValidatePaymentMethodHandler(svc, ctx)
 		})
 	}
@@ -3,20 +3,83 @@ package routes
 import (
 	"poc/controller"
 	"poc/middleware"
+	"poc/model"
 	"poc/services"
 
 	"github.com/kataras/iris/v12"
 )
 
-func RegisterTransactionRoutes(app *iris.Application, svc *services.TransactionService) {
+func RegisterTransactionRoutes(app *iris.Application, svc *services.TransactionService, idempotencySvc *services.IdempotencyService, userSvc *services.UserService, mfaSvc *services.MFAService) {
 	// Protected routes for transactions
 	auth := app.Party("/transactions", middleware.AuthMiddleware)
+	verified := middleware.RequireVerifiedEmail(userSvc)
+	stepUp := middleware.RequireStepUpMFA(mfaSvc)
 	{
-		auth.Post("/", func(ctx iris.Context) {
+		// Creating a transaction requires a verified email and an Idempotency-Key so retries never double-charge.
+		// Amounts above the MFA threshold also need a fresh code in X-MFA-Code.
+		auth.Post("/", verified, middleware.Idempotency(idempotencySvc, true), stepUp, func(ctx iris.Context) {
 			controller.CreateTransactionHandler(svc, ctx)
 		})
 		auth.Get("/", func(ctx iris.Context) {
 			controller.ListTransactionsHandler(svc, ctx)
 		})
+		auth.Get("/{transactionID}", func(ctx iris.Context) {
+			controller.GetTransactionHandler(svc, ctx)
+		})
+
+		// Two-phase payments: place a hold, then capture or void it
+		auth.Post("/authorizations", verified, middleware.Idempotency(idempotencySvc, true), stepUp, func(ctx iris.Context) {
+			controller.AuthorizeTransactionHandler(svc, ctx)
+		})
+		auth.Post("/{transactionID}/capture", middleware.Idempotency(idempotencySvc, true), func(ctx iris.Context) {
+			controller.CaptureTransactionHandler(svc, ctx)
+		})
+		auth.Post("/{transactionID}/void", middleware.Idempotency(idempotencySvc, false), func(ctx iris.Context) {
+			controller.VoidTransactionHandler(svc, ctx)
+		})
+
+		// Clearing state of a cheque payment
+		auth.Get("/{transactionID}/cheque", func(ctx iris.Context) {
+			controller.GetChequeHandler(svc, ctx)
+		})
+
+		// UPI collect requests: the payee asks, the payer approves or declines
+		auth.Post("/upi/collect", verified, middleware.Idempotency(idempotencySvc, true), func(ctx iris.Context) {
+			controller.RequestUPICollectHandler(svc, ctx)
+		})
+		auth.Get("/upi/collect", func(ctx iris.Context) {
+			controller.ListUPICollectRequestsHandler(svc, ctx)
+		})
+		// Approving pays the stored amount, so step-up looks that up rather than the body
+		collectStepUp := middleware.RequireStepUpMFAFor(mfaSvc, func(ctx iris.Context) (model.Money, error) {
+			request, err := svc.GetUPICollectRequest(ctx, ctx.Values().GetString("UserID"), ctx.Params().GetString("collectRequestID"))
+			if err != nil {
+				return model.Money{}, err
+			}
+			return request.Amount, nil
+		})
+		auth.Post("/upi/collect/{collectRequestID}/approve", verified, middleware.Idempotency(idempotencySvc, true), collectStepUp, controller.RespondToUPICollectHandler(svc, true))
+		auth.Post("/upi/collect/{collectRequestID}/decline", middleware.Idempotency(idempotencySvc, false), controller.RespondToUPICollectHandler(svc, false))
+
+		// Partial and multiple refunds against a completed transaction
+		auth.Post("/{transactionID}/refunds", middleware.Idempotency(idempotencySvc, true), func(ctx iris.Context) {
+			controller.CreateRefundHandler(svc, ctx)
+		})
 	}
+
+	// Cheque clearing overrides for staff
+	admin := app.Party("/admin/cheques", middleware.AuthMiddleware, middleware.RequireRole(model.RoleAdmin))
+	{
+		admin.Post("/{chequeID}/clear", middleware.Idempotency(idempotencySvc, false), func(ctx iris.Context) {
+			controller.ClearChequeHandler(svc, ctx)
+		})
+		admin.Post("/{chequeID}/bounce", middleware.Idempotency(idempotencySvc, false), func(ctx iris.Context) {
+			controller.BounceChequeHandler(svc, ctx)
+		})
+	}
+
+	// Stuck transaction counts for monitoring
+	app.Get("/admin/metrics/stuck-transactions", middleware.AuthMiddleware, middleware.RequireRole(model.RoleAdmin, model.RoleSupport), func(ctx iris.Context) {
+		controller.RecoveryMetricsHandler(svc, ctx)
+	})
 }
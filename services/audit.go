@@ -0,0 +1,26 @@
+package services
+
+import (
+	"log"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// recordAudit writes an audit entry for an account-level event. Audit
+// failures are logged rather than failing the operation they describe.
+func recordAudit(db *gorm.DB, userID, actorID, action, details string) {
+	entry := model.AuditLog{
+		AuditLogID: utils.GenerateUniqueID(),
+		UserID:     userID,
+		ActorID:    actorID,
+		Action:     action,
+		Details:    details,
+		CreatedAt:  time.Now(),
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("Failed to log audit: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"poc/model"
+	"poc/utils"
+)
+
+// BankRail moves money to and from external bank accounts. Production
+// deployments plug in a real payment rail; the simulator below is for local
+// development.
+type BankRail interface {
+	// SendMicroDeposits credits each amount to the account and returns a
+	// reference for the batch.
+	SendMicroDeposits(ctx context.Context, accountNumber string, amounts []model.Money) (string, error)
+}
+
+// NewBankRail returns the rail named by kind. Only "simulator" exists so far.
+func NewBankRail(kind string) (BankRail, error) {
+	switch kind {
+	case "", "simulator":
+		return SimulatedBankRail{}, nil
+	default:
+		return nil, fmt.Errorf("unknown bank rail %q", kind)
+	}
+}
+
+// SimulatedBankRail pretends to send deposits and logs them, so a developer
+// can read the amounts to confirm.
+type SimulatedBankRail struct{}
+
+// SendMicroDeposits logs the deposits against the masked account number.
+func (SimulatedBankRail) SendMicroDeposits(ctx context.Context, accountNumber string, amounts []model.Money) (string, error) {
+	reference := "sim_" + utils.GenerateUniqueID()
+	log.Printf("Simulated micro-deposits %v to account ending %s (reference %s)", amounts, LastFour(accountNumber), reference)
+	return reference, nil
+}
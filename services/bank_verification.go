@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"poc/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultMicroDepositAttempts is how many wrong confirmations are allowed
+// before a bank account has to be added again.
+const DefaultMicroDepositAttempts = 3
+
+// ErrMicroDepositMismatch is returned when the confirmed amounts are wrong.
+var ErrMicroDepositMismatch = errors.New("the amounts do not match the deposits")
+
+// ErrMicroDepositAttemptsExhausted is returned once a bank account has used
+// up its wrong confirmations, across every time it was added.
+var ErrMicroDepositAttemptsExhausted = errors.New("too many wrong attempts to verify this bank account")
+
+// startBankVerification picks two random micro-deposits of 1 to 99 minor
+// units for a new bank account and records them inside tx. The deposits are
+// sent by sendMicroDeposits once tx has committed.
+func (s *PaymentMethodService) startBankVerification(tx *gorm.DB, paymentMethod *model.PaymentMethod) (*model.BankAccountVerification, error) {
+	attempts, err := verificationAttempts(tx, paymentMethod.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if attempts >= s.MicroDepositAttempts {
+		return nil, ErrMicroDepositAttemptsExhausted
+	}
+
+	verification := model.BankAccountVerification{
+		PaymentMethodID: paymentMethod.PaymentMethodID,
+		PayerID:         paymentMethod.PayerID,
+		Fingerprint:     paymentMethod.Fingerprint,
+		Status:          model.BankVerificationPending,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	for _, amount := range []*model.Money{&verification.FirstAmount, &verification.SecondAmount} {
+		n, err := rand.Int(rand.Reader, big.NewInt(99))
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick micro-deposit amount: %v", err)
+		}
+		*amount = model.NewMoney(n.Int64()+1, model.DefaultCurrency)
+	}
+	if err := tx.Create(&verification).Error; err != nil {
+		return nil, fmt.Errorf("failed to record micro-deposits: %v", err)
+	}
+	return &verification, nil
+}
+
+// sendMicroDeposits asks the bank rail to send the recorded deposits. It runs
+// after the payment method is committed, so deposits are never sent for a
+// rolled-back account. If the rail fails the new bank account is deleted
+// again and the payer can add it once more.
+func (s *PaymentMethodService) sendMicroDeposits(ctx context.Context, paymentMethod *model.PaymentMethod, verification *model.BankAccountVerification, accountNumber string) error {
+	reference, err := s.BankRail.SendMicroDeposits(ctx, accountNumber, []model.Money{verification.FirstAmount, verification.SecondAmount})
+	if err != nil {
+		undo := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(verification).Error; err != nil {
+				return err
+			}
+			if err := s.Vault.Purge(ctx, tx, paymentMethod.AccountToken); err != nil {
+				return err
+			}
+			return tx.Delete(paymentMethod).Error
+		})
+		if undo != nil {
+			log.Printf("failed to remove bank account %s after the rail failed: %v", paymentMethod.PaymentMethodID, undo)
+		}
+		return fmt.Errorf("failed to send micro-deposits: %v", err)
+	}
+
+	verification.RailReference = reference
+	err = s.DB.WithContext(ctx).Model(verification).
+		Updates(map[string]interface{}{"rail_reference": reference, "updated_at": time.Now()}).Error
+	if err != nil {
+		// The deposits are out; the payer can still confirm them
+		log.Printf("failed to record rail reference %s for bank account %s: %v", reference, paymentMethod.PaymentMethodID, err)
+	}
+	return nil
+}
+
+// verificationAttempts counts the wrong confirmations made for an account
+// number under every payment method it was added as.
+func verificationAttempts(tx *gorm.DB, fingerprint string) (int, error) {
+	var attempts int64
+	err := tx.Model(&model.BankAccountVerification{}).Where("fingerprint = ?", fingerprint).
+		Select("COALESCE(SUM(attempts), 0)").Scan(&attempts).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count verification attempts: %v", err)
+	}
+	return int(attempts), nil
+}
+
+// VerifyBankAccount checks the two micro-deposit amounts the payer read off
+// their statement, in either order. A match activates the bank account. Wrong
+// tries count against the account number, so removing and adding it again
+// does not earn more.
+func (s *PaymentMethodService) VerifyBankAccount(ctx context.Context, payerID, paymentMethodID string, amounts []model.Money) (model.PaymentMethod, error) {
+	var paymentMethod model.PaymentMethod
+	var mismatch bool
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&paymentMethod, "payment_method_id = ? AND payer_id = ? AND status <> ?", paymentMethodID, payerID, model.PaymentMethodRemoved).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPaymentMethodNotFound
+			}
+			return fmt.Errorf("failed to fetch payment method: %v", err)
+		}
+		if paymentMethod.Status != model.PaymentMethodPendingVerification {
+			return errors.New("payment method is not awaiting verification")
+		}
+
+		var verification model.BankAccountVerification
+		if err := tx.First(&verification, "payment_method_id = ?", paymentMethodID).Error; err != nil {
+			return fmt.Errorf("failed to fetch verification: %v", err)
+		}
+		// Rows from before fingerprints were recorded count on their own
+		attempts := verification.Attempts
+		if verification.Fingerprint != "" {
+			if attempts, err = verificationAttempts(tx, verification.Fingerprint); err != nil {
+				return err
+			}
+		}
+		if verification.Status == model.BankVerificationFailed || attempts >= s.MicroDepositAttempts {
+			return ErrMicroDepositAttemptsExhausted
+		}
+
+		if !microDepositsMatch(verification, amounts) {
+			verification.Attempts++
+			if attempts+1 >= s.MicroDepositAttempts {
+				verification.Status = model.BankVerificationFailed
+			}
+			mismatch = true
+			return tx.Save(&verification).Error
+		}
+
+		verification.Status = model.BankVerificationVerified
+		if err := tx.Save(&verification).Error; err != nil {
+			return err
+		}
+		if err := paymentMethod.Status.ValidateTransition(model.PaymentMethodActive); err != nil {
+			return err
+		}
+		paymentMethod.Status = model.PaymentMethodActive
+		paymentMethod.UpdatedAt = time.Now()
+
+		// Becomes the default if the payer has none yet
+		var defaults int64
+		if err := tx.Model(&model.PaymentMethod{}).Where("payer_id = ? AND is_default = ?", payerID, true).Count(&defaults).Error; err != nil {
+			return err
+		}
+		paymentMethod.IsDefault = defaults == 0
+		return tx.Model(&model.PaymentMethod{}).Where("payment_method_id = ?", paymentMethodID).
+			Updates(map[string]interface{}{"status": paymentMethod.Status, "is_default": paymentMethod.IsDefault, "updated_at": paymentMethod.UpdatedAt}).Error
+	})
+	if err != nil {
+		return paymentMethod, err
+	}
+	if mismatch {
+		return paymentMethod, ErrMicroDepositMismatch
+	}
+	return paymentMethod, nil
+}
+
+// microDepositsMatch compares the confirmed amounts with the deposits in
+// either order. Amounts without a currency are taken to be in the deposits'.
+func microDepositsMatch(verification model.BankAccountVerification, amounts []model.Money) bool {
+	if len(amounts) != 2 {
+		return false
+	}
+	for i := range amounts {
+		if amounts[i].Currency == "" {
+			amounts[i].Currency = verification.FirstAmount.Currency
+		}
+	}
+	first, second := verification.FirstAmount, verification.SecondAmount
+	return (amounts[0] == first && amounts[1] == second) || (amounts[0] == second && amounts[1] == first)
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultChequeClearingPeriod is how long a presented cheque takes to clear.
+const DefaultChequeClearingPeriod = 48 * time.Hour
+
+// ErrChequeNotFound is returned for unknown cheques and for cheques the
+// caller is not a party to.
+var ErrChequeNotFound = errors.New("cheque not found")
+
+var errChequeAlreadyDeposited = errors.New("cheque has already been deposited")
+
+// DepositCheque records a cheque payment. Nothing moves until the cheque is
+// presented for clearing; the transaction stays Pending until then, Reserved
+// while in clearing, and ends Completed or Failed.
+func (svc *TransactionService) DepositCheque(ctx context.Context, transaction *model.Transaction, chequeNumber string) (*model.Cheque, error) {
+	cheque := &model.Cheque{
+		ChequeID:        utils.GenerateUniqueID(),
+		TransactionID:   transaction.TransactionID,
+		PayerID:         transaction.PayerID,
+		PayeeID:         transaction.PayeeID,
+		PaymentMethodID: transaction.PaymentMethodID,
+		ChequeNumber:    chequeNumber,
+		Amount:          transaction.Amount,
+		Status:          model.ChequeDeposited,
+		BounceFee:       model.ZeroMoney(transaction.Amount.Currency),
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		// Checked in the same transaction as the insert, so two deposits of
+		// the same cheque cannot both pass
+		var existing int64
+		err := tx.Model(&model.Cheque{}).
+			Where("payment_method_id = ? AND cheque_number = ? AND status <> ?", transaction.PaymentMethodID, chequeNumber, model.ChequeBounced).
+			Count(&existing).Error
+		if err != nil {
+			return fmt.Errorf("failed to check cheque: %v", err)
+		}
+		if existing > 0 {
+			return errChequeAlreadyDeposited
+		}
+		if err := svc.createTransaction(tx, transaction, model.StatusPending, "Cheque deposited"); err != nil {
+			return err
+		}
+		return tx.Create(cheque).Error
+	})
+	if errors.Is(err, errChequeAlreadyDeposited) {
+		return nil, fmt.Errorf("cheque %s has already been deposited", chequeNumber)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to deposit cheque: %v", err)
+	}
+
+	svc.logAudit(transaction.TransactionID, "Cheque Deposited", fmt.Sprintf("Cheque %s for %s deposited", chequeNumber, cheque.Amount))
+	return cheque, nil
+}
+
+// GetCheque returns the cheque paying a transaction the user takes part in.
+func (svc *TransactionService) GetCheque(ctx context.Context, transactionID, userID string) (*model.Cheque, error) {
+	var cheque model.Cheque
+	err := svc.DB.WithContext(ctx).
+		First(&cheque, "transaction_id = ? AND (payer_id = ? OR payee_id = ?)", transactionID, userID, userID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrChequeNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch cheque: %v", err)
+	}
+	return &cheque, nil
+}
+
+// ProcessCheques is the clearing job: it presents deposited cheques and
+// clears the ones whose clearing period has passed. A cheque that fails is
+// logged and retried on the next run. It returns how many cheques changed
+// state.
+func (svc *TransactionService) ProcessCheques(ctx context.Context) (int, error) {
+	var due []model.Cheque
+	err := svc.DB.WithContext(ctx).
+		Where("status = ? OR (status = ? AND clears_at <= ?)", model.ChequeDeposited, model.ChequeInClearing, time.Now()).
+		Find(&due).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch cheques due for clearing: %v", err)
+	}
+
+	processed := 0
+	for _, candidate := range due {
+		var err error
+		if candidate.Status == model.ChequeDeposited {
+			_, err = svc.presentCheque(ctx, candidate.ChequeID)
+		} else {
+			_, err = svc.clearCheque(ctx, candidate.ChequeID, "Cheque cleared")
+		}
+		if err != nil {
+			// One bad cheque must not hold up the rest
+			log.Printf("Failed to process cheque %s: %v", candidate.ChequeID, err)
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// ClearCheque lets an administrator release a cheque's funds to the payee
+// before the clearing period ends. Deposited cheques are presented first.
+func (svc *TransactionService) ClearCheque(ctx context.Context, chequeID, actorID string) (*model.Cheque, error) {
+	cheque, err := svc.presentCheque(ctx, chequeID)
+	if err != nil {
+		return nil, err
+	}
+	if cheque.Status == model.ChequeBounced {
+		return nil, errors.New("cheque bounced when presented")
+	}
+	return svc.clearCheque(ctx, chequeID, fmt.Sprintf("Cheque cleared by administrator %s", actorID))
+}
+
+// BounceCheque returns an uncleared cheque unpaid: the payee's uncleared
+// funds go back to the payer, the transaction fails and the payer is charged
+// the bounce fee.
+func (svc *TransactionService) BounceCheque(ctx context.Context, chequeID, actorID, reason string) (*model.Cheque, error) {
+	if reason == "" {
+		return nil, errors.New("a reason is required")
+	}
+	var cheque model.Cheque
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&cheque, "cheque_id = ?", chequeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrChequeNotFound
+			}
+			return err
+		}
+		return svc.bounce(tx, &cheque, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	svc.logAudit(cheque.TransactionID, "Cheque Bounced", fmt.Sprintf("Cheque %s returned by administrator %s: %s; fee %s", cheque.ChequeNumber, actorID, reason, cheque.BounceFee))
+	return &cheque, nil
+}
+
+// presentCheque sends a deposited cheque for clearing, moving the amount from
+// the payer to the payee's uncleared account. A payer who cannot cover it
+// bounces the cheque. Cheques past the deposited state are returned as is.
+func (svc *TransactionService) presentCheque(ctx context.Context, chequeID string) (*model.Cheque, error) {
+	var cheque model.Cheque
+	presented, bounced := false, false
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&cheque, "cheque_id = ?", chequeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrChequeNotFound
+			}
+			return err
+		}
+		if cheque.Status != model.ChequeDeposited {
+			return nil
+		}
+
+		// Check first so a failed posting does not leave half an entry behind.
+		// A payer without a ledger account yet has a zero balance.
+		balance, err := svc.Ledger.Balance(tx, model.PayerAccount(cheque.PayerID).AccountID)
+		if err != nil {
+			return err
+		}
+		if balance.MinorUnits < cheque.Amount.MinorUnits {
+			bounced = true
+			return svc.bounce(tx, &cheque, "Insufficient funds")
+		}
+
+		if _, err := svc.Ledger.Transfer(tx, cheque.TransactionID, model.JournalEntryChequeDeposit, "Cheque in clearing",
+			model.PayerAccount(cheque.PayerID), model.PayeeUnclearedAccount(cheque.PayeeID), cheque.Amount); err != nil {
+			return err
+		}
+		transaction, err := svc.loadChequeTransaction(tx, &cheque)
+		if err != nil {
+			return err
+		}
+		if err := transitionStatus(tx, transaction, model.StatusReserved, "Cheque presented for clearing"); err != nil {
+			return err
+		}
+		transaction.ReservedAmount = cheque.Amount
+		if err := tx.Save(transaction).Error; err != nil {
+			return err
+		}
+
+		clearsAt := time.Now().Add(svc.ChequeClearingPeriod)
+		cheque.Status, cheque.ClearsAt = model.ChequeInClearing, &clearsAt
+		presented = true
+		return tx.Save(&cheque).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to present cheque %s: %v", chequeID, err)
+	}
+	if bounced {
+		svc.logAudit(cheque.TransactionID, "Cheque Bounced", fmt.Sprintf("Cheque %s returned: %s; fee %s", cheque.ChequeNumber, cheque.BounceReason, cheque.BounceFee))
+	} else if presented {
+		svc.logAudit(cheque.TransactionID, "Cheque In Clearing", fmt.Sprintf("Cheque %s clears at %s", cheque.ChequeNumber, cheque.ClearsAt.Format(time.RFC3339)))
+	}
+	return &cheque, nil
+}
+
+// clearCheque makes the funds of a cheque in clearing available to the payee
+// and completes its transaction.
+func (svc *TransactionService) clearCheque(ctx context.Context, chequeID, reason string) (*model.Cheque, error) {
+	var cheque model.Cheque
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&cheque, "cheque_id = ?", chequeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrChequeNotFound
+			}
+			return err
+		}
+		if cheque.Status != model.ChequeInClearing {
+			return fmt.Errorf("cheque is %s, not in clearing", cheque.Status)
+		}
+
+		if _, err := svc.Ledger.Transfer(tx, cheque.TransactionID, model.JournalEntryChequeClearing, "Cheque cleared",
+			model.PayeeUnclearedAccount(cheque.PayeeID), model.PayeeAccount(cheque.PayeeID), cheque.Amount); err != nil {
+			return err
+		}
+		transaction, err := svc.loadChequeTransaction(tx, &cheque)
+		if err != nil {
+			return err
+		}
+		if err := transitionStatus(tx, transaction, model.StatusCompleted, reason); err != nil {
+			return err
+		}
+		transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
+		if err := tx.Save(transaction).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		cheque.Status, cheque.ClearedAt = model.ChequeCleared, &now
+		return tx.Save(&cheque).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear cheque %s: %v", chequeID, err)
+	}
+	svc.logAudit(cheque.TransactionID, "Cheque Cleared", reason)
+	return &cheque, nil
+}
+
+// bounce returns a deposited or in-clearing cheque inside tx and charges the
+// bounce fee, capped at what the payer has available.
+func (svc *TransactionService) bounce(tx *gorm.DB, cheque *model.Cheque, reason string) error {
+	if cheque.Status != model.ChequeDeposited && cheque.Status != model.ChequeInClearing {
+		return fmt.Errorf("cheque is already %s", cheque.Status)
+	}
+
+	// Reverse the movement into the payee's uncleared account
+	if cheque.Status == model.ChequeInClearing {
+		if _, err := svc.Ledger.Transfer(tx, cheque.TransactionID, model.JournalEntryChequeReturn, "Cheque returned",
+			model.PayeeUnclearedAccount(cheque.PayeeID), model.PayerAccount(cheque.PayerID), cheque.Amount); err != nil {
+			return err
+		}
+	}
+
+	transaction, err := svc.loadChequeTransaction(tx, cheque)
+	if err != nil {
+		return err
+	}
+	if err := transitionStatus(tx, transaction, model.StatusFailed, "Cheque bounced: "+reason); err != nil {
+		return err
+	}
+	transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
+	if err := tx.Save(transaction).Error; err != nil {
+		return err
+	}
+
+	fee, err := svc.chequeBounceFee(tx, cheque)
+	if err != nil {
+		return err
+	}
+	if fee.IsPositive() {
+		if _, err := svc.Ledger.Transfer(tx, cheque.TransactionID, model.JournalEntryFee, "Cheque bounce fee",
+			model.PayerAccount(cheque.PayerID), model.SystemAccount(model.LedgerSystemFees), fee); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	cheque.Status, cheque.BounceReason, cheque.BounceFee, cheque.BouncedAt = model.ChequeBounced, reason, fee, &now
+	return tx.Save(cheque).Error
+}
+
+// chequeBounceFee is the configured fee, capped at the payer's available
+// balance. Fees in another currency than the cheque are not charged.
+func (svc *TransactionService) chequeBounceFee(tx *gorm.DB, cheque *model.Cheque) (model.Money, error) {
+	none := model.ZeroMoney(cheque.Amount.Currency)
+	if !svc.ChequeBounceFee.IsPositive() || svc.ChequeBounceFee.Currency != cheque.Amount.Currency {
+		return none, nil
+	}
+	balance, err := svc.Ledger.Balance(tx, model.PayerAccount(cheque.PayerID).AccountID)
+	if err != nil {
+		return none, err
+	}
+	if balance.MinorUnits < svc.ChequeBounceFee.MinorUnits {
+		if !balance.IsPositive() {
+			return none, nil
+		}
+		return model.NewMoney(balance.MinorUnits, cheque.Amount.Currency), nil
+	}
+	return svc.ChequeBounceFee, nil
+}
+
+func (svc *TransactionService) loadChequeTransaction(tx *gorm.DB, cheque *model.Cheque) (*model.Transaction, error) {
+	var transaction model.Transaction
+	if err := tx.First(&transaction, "transaction_id = ?", cheque.TransactionID).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+	return &transaction, nil
+}
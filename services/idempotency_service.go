@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"poc/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is replayed with a different request body.
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used with a different request")
+	// ErrIdempotencyInProgress is returned when the original request is still being processed.
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// IdempotencyTTL is how long a stored response is replayed for.
+const IdempotencyTTL = 24 * time.Hour
+
+// DefaultIdempotencyLease is how long a request may hold a key in progress
+// before a retry may take it over, e.g. after the server crashed mid-request.
+const DefaultIdempotencyLease = 5 * time.Minute
+
+// IdempotencyService stores request fingerprints and responses keyed by the
+// client-supplied Idempotency-Key header.
+type IdempotencyService struct {
+	DB    *gorm.DB
+	Lease time.Duration // How long an in-progress key stays locked to its request
+}
+
+// NewIdempotencyService creates a new instance of IdempotencyService
+func NewIdempotencyService(db *gorm.DB) *IdempotencyService {
+	return &IdempotencyService{DB: db, Lease: DefaultIdempotencyLease}
+}
+
+// Fingerprint hashes a request body so a reused key can be matched against it.
+func Fingerprint(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin claims a key for a request. If the key was already used for the same
+// request and completed, the stored record is returned with replay set to true.
+// A key still in progress after its lease has run out is taken over by the
+// retry.
+func (s *IdempotencyService) Begin(ctx context.Context, userID, method, path, key, requestHash string) (record *model.IdempotencyKey, replay bool, err error) {
+	id := idempotencyRecordID(userID, method, path, key)
+
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing model.IdempotencyKey
+		err := tx.First(&existing, "idempotency_key_id = ?", id).Error
+		switch {
+		case err == nil && existing.ExpiresAt.After(time.Now()):
+			if existing.RequestHash != requestHash {
+				return ErrIdempotencyKeyReused
+			}
+			if existing.State == model.IdempotencyCompleted {
+				record, replay = &existing, true
+				return nil
+			}
+			if time.Since(existing.LockedAt) < s.Lease {
+				return ErrIdempotencyInProgress
+			}
+			// The request holding the key never finished; this retry takes over
+			lockedAt := time.Now()
+			result := tx.Model(&model.IdempotencyKey{}).
+				Where("idempotency_key_id = ? AND state = ? AND locked_at = ?", id, model.IdempotencyInProgress, existing.LockedAt).
+				Update("locked_at", lockedAt)
+			if result.Error != nil {
+				return fmt.Errorf("failed to take over idempotency key: %v", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return ErrIdempotencyInProgress
+			}
+			existing.LockedAt = lockedAt
+			record = &existing
+			return nil
+		case err == nil:
+			// The old record has expired, so the key is free again
+			if err := tx.Delete(&existing).Error; err != nil {
+				return fmt.Errorf("failed to expire idempotency key: %v", err)
+			}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return fmt.Errorf("failed to fetch idempotency key: %v", err)
+		}
+
+		record = &model.IdempotencyKey{
+			IdempotencyKeyID: id,
+			UserID:           userID,
+			Key:              key,
+			Method:           method,
+			Path:             path,
+			RequestHash:      requestHash,
+			State:            model.IdempotencyInProgress,
+			CreatedAt:        time.Now(),
+			LockedAt:         time.Now(),
+			ExpiresAt:        time.Now().Add(IdempotencyTTL),
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return fmt.Errorf("failed to store idempotency key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return record, replay, nil
+}
+
+// Complete stores the response that will be replayed for the key. Nothing is
+// stored if a retry has taken the key over in the meantime.
+func (s *IdempotencyService) Complete(ctx context.Context, record *model.IdempotencyKey, statusCode int, body []byte) error {
+	err := s.DB.WithContext(ctx).Model(&model.IdempotencyKey{}).
+		Where("idempotency_key_id = ? AND state = ? AND locked_at = ?", record.IdempotencyKeyID, model.IdempotencyInProgress, record.LockedAt).
+		Updates(map[string]interface{}{
+			"state":         model.IdempotencyCompleted,
+			"status_code":   statusCode,
+			"response_body": string(body),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %v", err)
+	}
+	return nil
+}
+
+// Release drops an in-progress key so the client can retry, e.g. after a panic.
+func (s *IdempotencyService) Release(ctx context.Context, record *model.IdempotencyKey) error {
+	return s.DB.WithContext(ctx).
+		Where("idempotency_key_id = ? AND state = ? AND locked_at = ?", record.IdempotencyKeyID, model.IdempotencyInProgress, record.LockedAt).
+		Delete(&model.IdempotencyKey{}).Error
+}
+
+func idempotencyRecordID(userID, method, path, key string) string {
+	sum := sha256.Sum256([]byte(userID + "\x00" + method + "\x00" + path + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
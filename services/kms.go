@@ -0,0 +1,180 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// KMS wraps and unwraps the data keys the vault encrypts secrets with. The
+// key-encryption keys never leave the KMS; production deployments plug in a
+// cloud KMS, and LocalKMS below keeps them in a file for development.
+type KMS interface {
+	// WrapKey encrypts a data key and returns the ID of the key used.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped by the key named keyID.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKMS is a KMS backed by a key file holding one base64-encoded 256-bit
+// key per line. The first key wraps new data keys; the others are kept so
+// entries wrapped before a rotation can still be read.
+type LocalKMS struct {
+	active string
+	keys   map[string][]byte
+}
+
+// NewLocalKMS loads the key file at path. If the file does not exist a new
+// key is generated and written to it.
+func NewLocalKMS(path string) (*LocalKMS, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate vault key: %v", err)
+		}
+		if err := writeKeyFile(path, key); err != nil {
+			return nil, fmt.Errorf("failed to write vault key file: %v", err)
+		}
+		log.Printf("Generated a new vault key in %s; back it up, encrypted card data cannot be read without it", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault key file: %v", err)
+	}
+	defer file.Close()
+
+	kms := &LocalKMS{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("vault key file must hold base64-encoded 32-byte keys, one per line")
+		}
+		id := localKeyID(key)
+		kms.keys[id] = key
+		if kms.active == "" {
+			kms.active = id
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vault key file: %v", err)
+	}
+	if kms.active == "" {
+		return nil, errors.New("vault key file holds no keys")
+	}
+	return kms, nil
+}
+
+// WrapKey encrypts dataKey with the active key.
+func (k *LocalKMS) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := sealAESGCM(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+	return k.active, wrapped, nil
+}
+
+// UnwrapKey decrypts a data key wrapped by the key named keyID.
+func (k *LocalKMS) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("vault key %s is not in the key file", keyID)
+	}
+	dataKey, err := openAESGCM(key, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	return dataKey, nil
+}
+
+// DeriveKey returns a key for another purpose derived from the active key.
+// It changes when the key file is rotated, so anything stored under it must
+// be pinned separately; see LoadFingerprintKey.
+func (k *LocalKMS) DeriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, k.keys[k.active])
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// LoadFingerprintKey reads the HMAC key for payment method fingerprints from
+// the file at path. Fingerprints are stored and compared later, so the key
+// lives in its own file and does not change when the vault key is rotated.
+// If the file does not exist it is created with the key earlier versions
+// derived from the active vault key, so existing fingerprints keep matching.
+func LoadFingerprintKey(path string, kms *LocalKMS) ([]byte, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := writeKeyFile(path, kms.DeriveKey("payment-method-fingerprint")); err != nil {
+			return nil, fmt.Errorf("failed to write fingerprint key file: %v", err)
+		}
+		log.Printf("Pinned the payment method fingerprint key in %s; back it up with the vault key", path)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fingerprint key file: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("fingerprint key file must hold one base64-encoded 32-byte key")
+	}
+	return key, nil
+}
+
+func writeKeyFile(path string, key []byte) error {
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600)
+}
+
+// localKeyID names a key by a short hash so rotated files stay unambiguous.
+func localKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "local-" + hex.EncodeToString(sum[:8])
+}
+
+// sealAESGCM encrypts plaintext with AES-256-GCM and returns nonce||ciphertext.
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM reverses sealAESGCM.
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInsufficientFunds is returned when a posting would take a payer or payee account below zero.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// LedgerLeg is one side of a money movement handed to LedgerService.Post.
+type LedgerLeg struct {
+	Account model.LedgerAccount
+	Amount  model.Money
+}
+
+// LedgerService records every money movement as a balanced journal entry and
+// keeps Payer.Balance and Payee.Balance as cached projections of the journal.
+type LedgerService struct {
+	DB *gorm.DB
+}
+
+// NewLedgerService creates a new instance of LedgerService
+func NewLedgerService(db *gorm.DB) *LedgerService {
+	return &LedgerService{DB: db}
+}
+
+// Transfer posts a two-legged entry moving a positive amount from one account to another.
+func (l *LedgerService) Transfer(tx *gorm.DB, transactionID, entryType, description string, from, to model.LedgerAccount, amount model.Money) (*model.JournalEntry, error) {
+	if !amount.IsPositive() {
+		return nil, errors.New("transfer amount must be greater than zero")
+	}
+	return l.Post(tx, transactionID, entryType, description,
+		LedgerLeg{Account: from, Amount: amount.Neg()},
+		LedgerLeg{Account: to, Amount: amount},
+	)
+}
+
+// Post writes a journal entry with the given legs inside tx. The legs must share
+// a currency and sum to zero. Customer accounts may not go negative, and the
+// cached balance of every payer/payee available account touched is updated.
+func (l *LedgerService) Post(tx *gorm.DB, transactionID, entryType, description string, legs ...LedgerLeg) (*model.JournalEntry, error) {
+	if len(legs) < 2 {
+		return nil, errors.New("a journal entry needs at least two postings")
+	}
+
+	currency := legs[0].Amount.Currency
+	var total int64
+	for _, leg := range legs {
+		if leg.Amount.Currency != currency {
+			return nil, fmt.Errorf("%w: journal entry mixes %s and %s", model.ErrCurrencyMismatch, currency, leg.Amount.Currency)
+		}
+		total += leg.Amount.MinorUnits
+	}
+	if total != 0 {
+		return nil, fmt.Errorf("unbalanced journal entry: postings sum to %d", total)
+	}
+
+	entry := &model.JournalEntry{
+		JournalEntryID: utils.GenerateUniqueID(),
+		TransactionID:  transactionID,
+		EntryType:      entryType,
+		Description:    description,
+		CreatedAt:      time.Now(),
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to create journal entry: %v", err)
+	}
+
+	for _, leg := range legs {
+		if err := l.ensureAccount(tx, leg.Account, currency); err != nil {
+			return nil, err
+		}
+		if leg.Account.IsCustomerAccount() && leg.Amount.IsNegative() {
+			balance, err := l.Balance(tx, leg.Account.AccountID)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkOverdraft(leg, balance); err != nil {
+				return nil, err
+			}
+		}
+
+		posting := &model.Posting{
+			PostingID:      utils.GenerateUniqueID(),
+			JournalEntryID: entry.JournalEntryID,
+			AccountID:      leg.Account.AccountID,
+			Amount:         leg.Amount,
+			CreatedAt:      entry.CreatedAt,
+		}
+		if err := tx.Create(posting).Error; err != nil {
+			return nil, fmt.Errorf("failed to create posting: %v", err)
+		}
+
+		if err := l.applyToProjection(tx, leg); err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+// checkOverdraft returns ErrInsufficientFunds if posting leg to an account
+// holding balance would take it below zero.
+func checkOverdraft(leg LedgerLeg, balance model.Money) error {
+	if balance.MinorUnits+leg.Amount.MinorUnits < 0 {
+		return fmt.Errorf("%w in account %s", ErrInsufficientFunds, leg.Account.AccountID)
+	}
+	return nil
+}
+
+// AdjustTo posts whatever amount moves an account from current to target,
+// balanced against the system adjustments account.
+func (l *LedgerService) AdjustTo(tx *gorm.DB, account model.LedgerAccount, current, target model.Money, description string) error {
+	delta, err := target.Sub(current)
+	if err != nil {
+		return err
+	}
+	if delta.IsZero() {
+		return nil
+	}
+
+	from, to := model.SystemAccount(model.LedgerSystemAdjustments), account
+	if delta.IsNegative() {
+		from, to, delta = to, from, delta.Neg()
+	}
+	_, err = l.Transfer(tx, "", model.JournalEntryAdjustment, description, from, to, delta)
+	return err
+}
+
+// Balance sums the postings of an account. Accounts with no postings have a zero balance.
+func (l *LedgerService) Balance(tx *gorm.DB, accountID string) (model.Money, error) {
+	var account model.LedgerAccount
+	if err := tx.First(&account, "account_id = ?", accountID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.Money{}, nil
+		}
+		return model.Money{}, fmt.Errorf("failed to fetch ledger account: %v", err)
+	}
+
+	var sum struct{ Total int64 }
+	if err := tx.Model(&model.Posting{}).Select("COALESCE(SUM(amount_minor_units), 0) AS total").
+		Where("account_id = ?", accountID).Scan(&sum).Error; err != nil {
+		return model.Money{}, fmt.Errorf("failed to sum postings: %v", err)
+	}
+	return model.NewMoney(sum.Total, account.Currency), nil
+}
+
+// RebuildBalances recomputes every cached Payer.Balance and Payee.Balance from the journal.
+func (l *LedgerService) RebuildBalances(ctx context.Context) error {
+	return l.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var payers []model.Payer
+		if err := tx.Find(&payers).Error; err != nil {
+			return fmt.Errorf("failed to fetch payers: %v", err)
+		}
+		for _, payer := range payers {
+			balance, err := l.projectedBalance(tx, model.PayerAccount(payer.PayerID), payer.Balance.Currency)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&model.Payer{}).Where("PayerID = ?", payer.PayerID).Updates(map[string]interface{}{
+				"balance_minor_units": balance.MinorUnits,
+				"balance_currency":    balance.Currency,
+				"UpdatedAt":           time.Now(),
+			}).Error; err != nil {
+				return fmt.Errorf("failed to rebuild payer balance: %v", err)
+			}
+		}
+
+		var payees []model.Payee
+		if err := tx.Find(&payees).Error; err != nil {
+			return fmt.Errorf("failed to fetch payees: %v", err)
+		}
+		for _, payee := range payees {
+			balance, err := l.projectedBalance(tx, model.PayeeAccount(payee.PayeeID), payee.Balance.Currency)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&model.Payee{}).Where("PayeeID = ?", payee.PayeeID).Updates(map[string]interface{}{
+				"balance_minor_units": balance.MinorUnits,
+				"balance_currency":    balance.Currency,
+				"UpdatedAt":           time.Now(),
+			}).Error; err != nil {
+				return fmt.Errorf("failed to rebuild payee balance: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (l *LedgerService) projectedBalance(tx *gorm.DB, account model.LedgerAccount, fallbackCurrency string) (model.Money, error) {
+	balance, err := l.Balance(tx, account.AccountID)
+	if err != nil {
+		return model.Money{}, err
+	}
+	if balance.Currency == "" {
+		balance.Currency = fallbackCurrency
+	}
+	if balance.Currency == "" {
+		balance.Currency = model.DefaultCurrency
+	}
+	return balance, nil
+}
+
+// ensureAccount opens the ledger account on first use and checks its currency.
+func (l *LedgerService) ensureAccount(tx *gorm.DB, account model.LedgerAccount, currency string) error {
+	var existing model.LedgerAccount
+	err := tx.First(&existing, "account_id = ?", account.AccountID).Error
+	if err == nil {
+		if existing.Currency != currency {
+			return fmt.Errorf("%w: account %s is in %s", model.ErrCurrencyMismatch, account.AccountID, existing.Currency)
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to fetch ledger account: %v", err)
+	}
+
+	account.Currency = currency
+	account.CreatedAt = time.Now()
+	if err := tx.Create(&account).Error; err != nil {
+		return fmt.Errorf("failed to open ledger account: %v", err)
+	}
+	return nil
+}
+
+// applyToProjection keeps the cached balance columns in step with the journal.
+func (l *LedgerService) applyToProjection(tx *gorm.DB, leg LedgerLeg) error {
+	if leg.Account.Purpose != model.LedgerPurposeAvailable {
+		return nil
+	}
+
+	var target *gorm.DB
+	switch leg.Account.OwnerType {
+	case model.LedgerOwnerPayer:
+		target = tx.Model(&model.Payer{}).Where("PayerID = ?", leg.Account.OwnerID)
+	case model.LedgerOwnerPayee:
+		target = tx.Model(&model.Payee{}).Where("PayeeID = ?", leg.Account.OwnerID)
+	default:
+		return nil
+	}
+
+	if err := target.Updates(map[string]interface{}{
+		"balance_minor_units": gorm.Expr("balance_minor_units + ?", leg.Amount.MinorUnits),
+		"balance_currency":    leg.Amount.Currency,
+		"UpdatedAt":           time.Now(),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update cached balance: %v", err)
+	}
+	return nil
+}
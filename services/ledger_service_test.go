@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"poc/model"
+	"strings"
+	"testing"
+)
+
+// The checks below all run before Post touches the database, so no
+// transaction is needed.
+func TestPostRejectsInvalidEntries(t *testing.T) {
+	ledger := &LedgerService{}
+	payer, payee := model.PayerAccount("payer"), model.PayeeAccount("payee")
+
+	tests := []struct {
+		name string
+		legs []LedgerLeg
+		want string
+	}{
+		{"single leg", []LedgerLeg{{payer, model.NewMoney(-100, "INR")}}, "at least two postings"},
+		{"unbalanced", []LedgerLeg{{payer, model.NewMoney(-100, "INR")}, {payee, model.NewMoney(99, "INR")}}, "postings sum to -1"},
+		{"unbalanced three legs", []LedgerLeg{
+			{payer, model.NewMoney(-100, "INR")},
+			{payee, model.NewMoney(60, "INR")},
+			{model.SystemAccount(model.LedgerSystemSettlement), model.NewMoney(50, "INR")},
+		}, "postings sum to 10"},
+		{"mixed currencies", []LedgerLeg{{payer, model.NewMoney(-100, "INR")}, {payee, model.NewMoney(100, "USD")}}, "mixes INR and USD"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ledger.Post(nil, "txn", model.JournalEntryPayment, tc.name, tc.legs...)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestPostMixedCurrenciesIsCurrencyMismatch(t *testing.T) {
+	_, err := (&LedgerService{}).Post(nil, "txn", model.JournalEntryPayment, "fx",
+		LedgerLeg{model.PayerAccount("payer"), model.NewMoney(-100, "INR")},
+		LedgerLeg{model.PayeeAccount("payee"), model.NewMoney(100, "USD")})
+	if !errors.Is(err, model.ErrCurrencyMismatch) {
+		t.Errorf("got %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestTransferRequiresPositiveAmount(t *testing.T) {
+	ledger := &LedgerService{}
+	for _, amount := range []model.Money{model.ZeroMoney("INR"), model.NewMoney(-1, "INR")} {
+		_, err := ledger.Transfer(nil, "txn", model.JournalEntryPayment, "transfer",
+			model.PayerAccount("payer"), model.PayeeAccount("payee"), amount)
+		if err == nil {
+			t.Errorf("Transfer of %s succeeded", amount)
+		}
+	}
+}
+
+func TestCheckOverdraft(t *testing.T) {
+	payer := model.PayerAccount("payer")
+	tests := []struct {
+		name     string
+		balance  int64
+		amount   int64
+		overdraw bool
+	}{
+		{"within balance", 500, -200, false},
+		{"exactly the balance", 500, -500, false},
+		{"one unit over", 500, -501, true},
+		{"empty account", 0, -1, true},
+		{"credit to empty account", 0, 100, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkOverdraft(LedgerLeg{payer, model.NewMoney(tc.amount, "INR")}, model.NewMoney(tc.balance, "INR"))
+			if got := errors.Is(err, ErrInsufficientFunds); got != tc.overdraw {
+				t.Errorf("got %v, want overdraw %v", err, tc.overdraw)
+			}
+		})
+	}
+}
+
+func TestOnlyCustomerAccountsAreOverdraftChecked(t *testing.T) {
+	for _, account := range []model.LedgerAccount{model.PayerAccount("p"), model.PayerHoldAccount("p"), model.PayeeAccount("p"), model.PayeeUnclearedAccount("p")} {
+		if !account.IsCustomerAccount() {
+			t.Errorf("%s is not a customer account", account.AccountID)
+		}
+	}
+	for _, name := range []string{model.LedgerSystemSettlement, model.LedgerSystemFunding, model.LedgerSystemAdjustments, model.LedgerSystemFees} {
+		if account := model.SystemAccount(name); account.IsCustomerAccount() {
+			t.Errorf("%s is a customer account", account.AccountID)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"poc/model"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginAttempts is the failure counter kept for one account or client IP.
+type LoginAttempts struct {
+	Failures     int       // Consecutive failures since the last success or unlock
+	LastFailure  time.Time // When the most recent failure happened
+	BlockedUntil time.Time // Backoff: no attempts accepted before this
+	LockedUntil  time.Time // Lockout after too many failures
+}
+
+// AttemptStore keeps login failure counters. Update must apply fn atomically
+// for the key, starting from the zero value when the key is unknown.
+type AttemptStore interface {
+	Get(ctx context.Context, key string) (LoginAttempts, error)
+	Update(ctx context.Context, key string, fn func(*LoginAttempts)) (LoginAttempts, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// InMemoryAttemptStore is an AttemptStore for a single instance and for tests.
+type InMemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempts
+}
+
+// NewInMemoryAttemptStore creates an empty in-memory store.
+func NewInMemoryAttemptStore() *InMemoryAttemptStore {
+	return &InMemoryAttemptStore{attempts: make(map[string]LoginAttempts)}
+}
+
+// Get returns the counter for key.
+func (s *InMemoryAttemptStore) Get(ctx context.Context, key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+// Update applies fn to the counter for key under the store's lock.
+func (s *InMemoryAttemptStore) Update(ctx context.Context, key string, fn func(*LoginAttempts)) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := s.attempts[key]
+	fn(&attempts)
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+// Delete drops the counter for key.
+func (s *InMemoryAttemptStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// Prune drops counters idle for longer than maxAge so the map does not grow
+// without bound. It returns how many were removed.
+func (s *InMemoryAttemptStore) Prune(ctx context.Context, maxAge time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for key, attempts := range s.attempts {
+		if attempts.LastFailure.Before(cutoff) && attempts.LockedUntil.Before(time.Now()) {
+			delete(s.attempts, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// ThrottlePolicy sets how quickly failures are slowed down and locked out.
+type ThrottlePolicy struct {
+	MaxFailures int           // Failures before a lockout
+	Lockout     time.Duration // How long a lockout lasts
+	BaseDelay   time.Duration // Backoff after the first failure, doubled per further failure
+	MaxDelay    time.Duration // Upper bound on the backoff
+}
+
+// Default throttle policies. IPs get more room than accounts since several
+// users can share one address.
+var (
+	DefaultAccountThrottlePolicy = ThrottlePolicy{MaxFailures: 5, Lockout: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: time.Minute}
+	DefaultIPThrottlePolicy      = ThrottlePolicy{MaxFailures: 50, Lockout: 15 * time.Minute, BaseDelay: 0, MaxDelay: 0}
+)
+
+// LoginBlockedError is returned while an account or IP is backing off or
+// locked out.
+type LoginBlockedError struct {
+	Locked     bool          // True for a lockout, false for backoff
+	RetryAfter time.Duration // When the next attempt will be accepted
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed login attempts; try again in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many login attempts; retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginThrottle tracks failed logins per account and per client IP, slows
+// repeated failures down exponentially and locks out after too many.
+type LoginThrottle struct {
+	DB            *gorm.DB // For audit entries; nil skips them
+	Store         AttemptStore
+	AccountPolicy ThrottlePolicy
+	IPPolicy      ThrottlePolicy
+}
+
+// NewLoginThrottle creates a LoginThrottle with the default policies.
+func NewLoginThrottle(db *gorm.DB, store AttemptStore) *LoginThrottle {
+	return &LoginThrottle{DB: db, Store: store, AccountPolicy: DefaultAccountThrottlePolicy, IPPolicy: DefaultIPThrottlePolicy}
+}
+
+// AccountKeyForEmail identifies an account at the password step. Unknown
+// emails are tracked too, so responses do not reveal which accounts exist.
+func AccountKeyForEmail(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// AccountKeyForUser identifies an account at the MFA step.
+func AccountKeyForUser(userID string) string {
+	return "user:" + userID
+}
+
+// Check returns a *LoginBlockedError if the account or the IP may not try
+// to log in right now.
+func (t *LoginThrottle) Check(ctx context.Context, account, ip string) error {
+	return t.check(ctx, account, ipKey(ip))
+}
+
+// CheckAccount is Check for callers that act on an authenticated account, such
+// as step-up MFA, where the account is what needs protecting.
+func (t *LoginThrottle) CheckAccount(ctx context.Context, account string) error {
+	return t.check(ctx, account)
+}
+
+func (t *LoginThrottle) check(ctx context.Context, keys ...string) error {
+	now := time.Now()
+	for _, key := range keys {
+		attempts, err := t.Store.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to check login attempts: %v", err)
+		}
+		if now.Before(attempts.LockedUntil) {
+			return &LoginBlockedError{Locked: true, RetryAfter: attempts.LockedUntil.Sub(now)}
+		}
+		if now.Before(attempts.BlockedUntil) {
+			return &LoginBlockedError{RetryAfter: attempts.BlockedUntil.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt against the account and the IP,
+// applying backoff and locking out when a policy's limit is reached.
+func (t *LoginThrottle) RecordFailure(ctx context.Context, account, ip, userID string) error {
+	if err := t.recordAccountFailure(ctx, account, userID, "failed logins, last from "+ip); err != nil {
+		return err
+	}
+
+	attempts, locked, err := t.fail(ctx, ipKey(ip), t.IPPolicy)
+	if err != nil {
+		return err
+	}
+	if locked {
+		t.audit("", "", "IP Locked", fmt.Sprintf("IP %s locked for %s after %d failed logins", ip, t.IPPolicy.Lockout, attempts.Failures))
+	}
+	return nil
+}
+
+// RecordAccountFailure counts a wrong credential against the account alone,
+// e.g. a bad step-up code or current password on an authenticated session.
+func (t *LoginThrottle) RecordAccountFailure(ctx context.Context, account, userID string) error {
+	return t.recordAccountFailure(ctx, account, userID, "wrong multi-factor codes")
+}
+
+func (t *LoginThrottle) recordAccountFailure(ctx context.Context, account, userID, what string) error {
+	attempts, locked, err := t.fail(ctx, account, t.AccountPolicy)
+	if err != nil {
+		return err
+	}
+	if locked {
+		t.audit(userID, "", "Account Locked", fmt.Sprintf("%s locked for %s after %d %s", account, t.AccountPolicy.Lockout, attempts.Failures, what))
+	}
+	return nil
+}
+
+// RecordSuccess clears the account's counter. The IP counter is left alone so
+// an attacker cannot reset it by logging in to an account of their own.
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, account string) error {
+	return t.Store.Delete(ctx, account)
+}
+
+// Unlock lifts an account's lockout and backoff. actorID is the admin doing it.
+func (t *LoginThrottle) Unlock(ctx context.Context, email, actorID string) error {
+	var user model.User
+	userID := ""
+	if err := t.DB.Where("email = ?", email).First(&user).Error; err == nil {
+		userID = user.UserID
+		if err := t.Store.Delete(ctx, AccountKeyForUser(userID)); err != nil {
+			return err
+		}
+	}
+	if err := t.Store.Delete(ctx, AccountKeyForEmail(email)); err != nil {
+		return err
+	}
+	t.audit(userID, actorID, "Account Unlocked", fmt.Sprintf("%s unlocked by an administrator", AccountKeyForEmail(email)))
+	return nil
+}
+
+// fail records one failure under policy and reports whether it started a lockout.
+func (t *LoginThrottle) fail(ctx context.Context, key string, policy ThrottlePolicy) (LoginAttempts, bool, error) {
+	now := time.Now()
+	locked := false
+	attempts, err := t.Store.Update(ctx, key, func(a *LoginAttempts) {
+		// A lockout that has run out starts the count again
+		if !a.LockedUntil.IsZero() && now.After(a.LockedUntil) {
+			*a = LoginAttempts{}
+		}
+		a.Failures++
+		a.LastFailure = now
+		if policy.BaseDelay > 0 {
+			delay := policy.BaseDelay << uint(min(a.Failures-1, 30))
+			if delay > policy.MaxDelay || delay <= 0 {
+				delay = policy.MaxDelay
+			}
+			a.BlockedUntil = now.Add(delay)
+		}
+		if policy.MaxFailures > 0 && a.Failures >= policy.MaxFailures && now.After(a.LockedUntil) {
+			a.LockedUntil = now.Add(policy.Lockout)
+			locked = true
+		}
+	})
+	if err != nil {
+		return attempts, false, fmt.Errorf("failed to record login attempt: %v", err)
+	}
+	return attempts, locked, nil
+}
+
+func (t *LoginThrottle) audit(userID, actorID, action, details string) {
+	if t.DB != nil {
+		recordAudit(t.DB, userID, actorID, action, details)
+	}
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
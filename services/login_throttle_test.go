@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestThrottle returns a throttle over an in-memory store with no audit log.
+func newTestThrottle(account, ip ThrottlePolicy) (*LoginThrottle, *InMemoryAttemptStore) {
+	store := NewInMemoryAttemptStore()
+	return &LoginThrottle{Store: store, AccountPolicy: account, IPPolicy: ip}, store
+}
+
+// elapse moves a counter d into the past, as if that much time had gone by.
+func elapse(t *testing.T, store *InMemoryAttemptStore, key string, d time.Duration) {
+	t.Helper()
+	_, err := store.Update(context.Background(), key, func(a *LoginAttempts) {
+		shift := func(ts *time.Time) {
+			if !ts.IsZero() {
+				*ts = ts.Add(-d)
+			}
+		}
+		shift(&a.LastFailure)
+		shift(&a.BlockedUntil)
+		shift(&a.LockedUntil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func blockedError(t *testing.T, err error) *LoginBlockedError {
+	t.Helper()
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("got %v, want a *LoginBlockedError", err)
+	}
+	return blocked
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	policy := ThrottlePolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		failures  int
+		wantDelay time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second}, // Capped at MaxDelay
+		{40, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		throttle, store := newTestThrottle(policy, ThrottlePolicy{})
+		account := AccountKeyForEmail("payer@example.com")
+		for i := 0; i < tt.failures; i++ {
+			if err := throttle.RecordFailure(ctx, account, "10.0.0.1", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		blocked := blockedError(t, throttle.Check(ctx, account, "10.0.0.1"))
+		if blocked.Locked {
+			t.Errorf("%d failures: locked out, want backoff only", tt.failures)
+		}
+		if blocked.RetryAfter > tt.wantDelay || blocked.RetryAfter < tt.wantDelay-time.Second {
+			t.Errorf("%d failures: retry after %s, want about %s", tt.failures, blocked.RetryAfter, tt.wantDelay)
+		}
+
+		// Once the window has passed the next attempt is let through
+		elapse(t, store, account, tt.wantDelay)
+		if err := throttle.Check(ctx, account, "10.0.0.1"); err != nil {
+			t.Errorf("%d failures: still blocked after the backoff window: %v", tt.failures, err)
+		}
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     ThrottlePolicy
+		failures   int
+		wantLocked bool
+	}{
+		{"below the limit", ThrottlePolicy{MaxFailures: 3, Lockout: time.Minute}, 2, false},
+		{"at the limit", ThrottlePolicy{MaxFailures: 3, Lockout: time.Minute}, 3, true},
+		{"past the limit", ThrottlePolicy{MaxFailures: 3, Lockout: time.Minute}, 5, true},
+		{"no limit", ThrottlePolicy{}, 100, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			throttle, _ := newTestThrottle(tt.policy, ThrottlePolicy{})
+			account := AccountKeyForUser("user-1")
+			for i := 0; i < tt.failures; i++ {
+				if err := throttle.RecordAccountFailure(ctx, account, "user-1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := throttle.CheckAccount(ctx, account)
+			if !tt.wantLocked {
+				if err != nil {
+					t.Fatalf("got %v, want no block", err)
+				}
+				return
+			}
+			blocked := blockedError(t, err)
+			if !blocked.Locked {
+				t.Error("got backoff, want a lockout")
+			}
+			if blocked.RetryAfter > tt.policy.Lockout || blocked.RetryAfter < tt.policy.Lockout-time.Second {
+				t.Errorf("retry after %s, want about %s", blocked.RetryAfter, tt.policy.Lockout)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleLockoutExpiry(t *testing.T) {
+	ctx := context.Background()
+	throttle, store := newTestThrottle(ThrottlePolicy{MaxFailures: 2, Lockout: time.Minute}, ThrottlePolicy{})
+	account := AccountKeyForUser("user-1")
+	for i := 0; i < 2; i++ {
+		if err := throttle.RecordAccountFailure(ctx, account, "user-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := throttle.CheckAccount(ctx, account); err == nil {
+		t.Fatal("not locked out after reaching the limit")
+	}
+
+	elapse(t, store, account, time.Minute)
+	if err := throttle.CheckAccount(ctx, account); err != nil {
+		t.Fatalf("still blocked after the lockout ran out: %v", err)
+	}
+
+	// The next failure starts counting from scratch
+	if err := throttle.RecordAccountFailure(ctx, account, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	attempts, _ := store.Get(ctx, account)
+	if attempts.Failures != 1 {
+		t.Errorf("failures after an expired lockout = %d, want 1", attempts.Failures)
+	}
+	if err := throttle.CheckAccount(ctx, account); err != nil {
+		t.Errorf("locked out again after one failure: %v", err)
+	}
+}
+
+func TestLoginThrottleReset(t *testing.T) {
+	ctx := context.Background()
+	throttle, store := newTestThrottle(
+		ThrottlePolicy{MaxFailures: 3, Lockout: time.Minute},
+		ThrottlePolicy{MaxFailures: 10, Lockout: time.Minute},
+	)
+	account := AccountKeyForEmail("Payer@Example.com ")
+	for i := 0; i < 2; i++ {
+		if err := throttle.RecordFailure(ctx, account, "10.0.0.1", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := throttle.RecordSuccess(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	if attempts, _ := store.Get(ctx, account); attempts.Failures != 0 {
+		t.Errorf("account failures after success = %d, want 0", attempts.Failures)
+	}
+	// Logging in does not clear the IP's count
+	if attempts, _ := store.Get(ctx, ipKey("10.0.0.1")); attempts.Failures != 2 {
+		t.Errorf("IP failures after success = %d, want 2", attempts.Failures)
+	}
+
+	// Two more failures do not reach the limit of three again
+	for i := 0; i < 2; i++ {
+		if err := throttle.RecordFailure(ctx, account, "10.0.0.1", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := throttle.Check(ctx, account, "10.0.0.1"); err != nil {
+		t.Errorf("blocked after a reset and two failures: %v", err)
+	}
+}
+
+func TestLoginThrottleIPLockout(t *testing.T) {
+	ctx := context.Background()
+	throttle, _ := newTestThrottle(
+		ThrottlePolicy{MaxFailures: 5, Lockout: time.Minute},
+		ThrottlePolicy{MaxFailures: 3, Lockout: time.Minute},
+	)
+
+	// One failure each on three accounts from the same address
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if err := throttle.RecordFailure(ctx, AccountKeyForEmail(email), "10.0.0.1", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fresh := AccountKeyForEmail("d@example.com")
+	if blocked := blockedError(t, throttle.Check(ctx, fresh, "10.0.0.1")); !blocked.Locked {
+		t.Error("IP not locked out after reaching its limit")
+	}
+	if err := throttle.Check(ctx, fresh, "10.0.0.2"); err != nil {
+		t.Errorf("another IP is blocked: %v", err)
+	}
+	// Step-up checks look at the account only
+	if err := throttle.CheckAccount(ctx, AccountKeyForEmail("a@example.com")); err != nil {
+		t.Errorf("account blocked by its IP's lockout: %v", err)
+	}
+}
+
+func TestInMemoryAttemptStorePrune(t *testing.T) {
+	ctx := context.Background()
+	throttle, store := newTestThrottle(ThrottlePolicy{MaxFailures: 1, Lockout: time.Hour}, ThrottlePolicy{})
+	if err := throttle.RecordAccountFailure(ctx, "idle", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := throttle.RecordAccountFailure(ctx, "locked", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := throttle.RecordAccountFailure(ctx, "recent", ""); err != nil {
+		t.Fatal(err)
+	}
+	elapse(t, store, "idle", 2*time.Hour)
+	_, err := store.Update(ctx, "locked", func(a *LoginAttempts) {
+		a.LastFailure = a.LastFailure.Add(-2 * time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := store.Prune(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("pruned %d counters, want 1", removed)
+	}
+	if attempts, _ := store.Get(ctx, "idle"); attempts.Failures != 0 {
+		t.Error("idle counter was kept")
+	}
+	// Still inside its lockout, so it must survive even though it is old
+	if attempts, _ := store.Get(ctx, "locked"); attempts.Failures != 1 {
+		t.Error("counter inside its lockout was pruned")
+	}
+	if attempts, _ := store.Get(ctx, "recent"); attempts.Failures != 1 {
+		t.Error("recent counter was pruned")
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EmailMessage is a plain-text email.
+type EmailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email. Production deployments plug in a real provider; the
+// console and file sinks below are for local development.
+type Mailer interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// NewMailer returns the sink named by kind: "console" logs messages and
+// "file" writes each one to dir.
+func NewMailer(kind, dir string) (Mailer, error) {
+	switch kind {
+	case "", "console":
+		return ConsoleMailer{}, nil
+	case "file":
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create mail directory: %v", err)
+		}
+		return FileMailer{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail sink %q", kind)
+	}
+}
+
+// ConsoleMailer writes messages to the log.
+type ConsoleMailer struct{}
+
+// Send logs the message.
+func (ConsoleMailer) Send(ctx context.Context, msg EmailMessage) error {
+	log.Printf("Email to %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes each message to its own file in Dir.
+type FileMailer struct {
+	Dir string
+}
+
+// Send writes the message to a timestamped .eml file.
+func (m FileMailer) Send(ctx context.Context, msg EmailMessage) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), filepath.Base(msg.To))
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", msg.To, msg.Subject, msg.Body)
+	if err := os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0o600); err != nil {
+		return fmt.Errorf("failed to write email: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"poc/utils"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MFALoginPurpose marks the challenge token returned by the first login step.
+	MFALoginPurpose = "mfa_login"
+	// MFAChallengeTTL is how long the user has to enter their code after the password.
+	MFAChallengeTTL = 5 * time.Minute
+	// RecoveryCodeCount is how many recovery codes are issued at a time.
+	RecoveryCodeCount = 10
+	// totpSkew accepts codes from one step either side, for clock drift.
+	totpSkew = 1
+)
+
+var (
+	// ErrMFARequired is returned when an action needs a second factor the caller did not give.
+	ErrMFARequired = errors.New("multi-factor authentication code required")
+	// ErrInvalidMFACode is returned for wrong, reused or expired codes.
+	ErrInvalidMFACode = errors.New("invalid multi-factor authentication code")
+	// ErrMFANotEnrolled is returned when the user has not set up MFA.
+	ErrMFANotEnrolled = errors.New("multi-factor authentication is not enabled")
+)
+
+// MFAService manages TOTP enrollment and checks second factors on login and
+// for high-value payments.
+type MFAService struct {
+	DB              *gorm.DB
+	Issuer          string      // Shown in authenticator apps
+	StepUpThreshold model.Money // Payments above this need a fresh code
+	// Throttle limits wrong codes per account, together with the login MFA
+	// step. Nil means no limit.
+	Throttle *LoginThrottle
+}
+
+// NewMFAService creates a new instance of MFAService
+func NewMFAService(db *gorm.DB, stepUpThreshold model.Money) *MFAService {
+	return &MFAService{DB: db, Issuer: "Payment System", StepUpThreshold: stepUpThreshold}
+}
+
+// Enroll starts enrollment with a new secret. MFA is not enforced until the
+// user confirms a code from their authenticator with ConfirmEnrollment.
+func (s *MFAService) Enroll(ctx context.Context, userID string) (secret, uri string, err error) {
+	var user model.User
+	if err := s.DB.First(&user, "UserID = ?", userID).Error; err != nil {
+		return "", "", errors.New("user not found")
+	}
+
+	secret, err = utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var existing model.MFAEnrollment
+		err := tx.First(&existing, "user_id = ?", userID).Error
+		switch {
+		case err == nil && existing.ConfirmedAt != nil:
+			return errors.New("multi-factor authentication is already enabled")
+		case err == nil:
+			// Restarting an unfinished enrollment replaces the secret
+			existing.Secret = secret
+			existing.LastUsedStep = 0
+			return tx.Save(&existing).Error
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return fmt.Errorf("failed to fetch MFA enrollment: %v", err)
+		}
+		return tx.Create(&model.MFAEnrollment{UserID: userID, Secret: secret}).Error
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return secret, utils.TOTPURI(s.Issuer, user.Email, secret), nil
+}
+
+// ConfirmEnrollment turns MFA on once the user enters a valid code and
+// returns the recovery codes, which are shown only this once.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, userID, code string) ([]string, error) {
+	var codes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var enrollment model.MFAEnrollment
+		if err := tx.First(&enrollment, "user_id = ?", userID).Error; err != nil {
+			return errors.New("start enrollment first")
+		}
+		if enrollment.ConfirmedAt != nil {
+			return errors.New("multi-factor authentication is already enabled")
+		}
+		if err := consumeTOTP(tx, &enrollment, code); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		enrollment.ConfirmedAt = &now
+		if err := tx.Save(&enrollment).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns MFA off after checking a current code or recovery code.
+func (s *MFAService) Disable(ctx context.Context, userID, code string) error {
+	return s.throttled(ctx, userID, func() error {
+		return s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := s.verify(tx, userID, code); err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", userID).Delete(&model.MFARecoveryCode{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", userID).Delete(&model.MFAEnrollment{}).Error
+		})
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a current code.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	var codes []string
+	err := s.throttled(ctx, userID, func() error {
+		return s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := s.verify(tx, userID, code); err != nil {
+				return err
+			}
+			var err error
+			codes, err = replaceRecoveryCodes(tx, userID)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// IsEnabled reports whether the user has confirmed MFA enrollment.
+func (s *MFAService) IsEnabled(ctx context.Context, userID string) (bool, error) {
+	var count int64
+	err := s.DB.WithContext(ctx).Model(&model.MFAEnrollment{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check MFA enrollment: %v", err)
+	}
+	return count > 0, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code for the user. Too
+// many wrong codes lock the account out with a *LoginBlockedError.
+func (s *MFAService) Verify(ctx context.Context, userID, code string) error {
+	return s.throttled(ctx, userID, func() error {
+		return s.verifyCode(ctx, userID, code)
+	})
+}
+
+// BeginLogin returns the short-lived challenge the client exchanges, together
+// with a code, for tokens at the second login step.
+func (s *MFAService) BeginLogin(userID string) (string, error) {
+	challenge, _, err := utils.GenerateActionToken(userID, MFALoginPurpose, "", MFAChallengeTTL)
+	return challenge, err
+}
+
+// ChallengeUser returns the user a login challenge was issued to.
+func (s *MFAService) ChallengeUser(challenge string) (string, error) {
+	claims, err := utils.ParseActionToken(challenge, MFALoginPurpose)
+	if err != nil {
+		return "", errors.New("invalid or expired MFA challenge")
+	}
+	return claims.Subject, nil
+}
+
+// CompleteLogin checks the challenge and the code and returns the user ID to
+// issue tokens for.
+func (s *MFAService) CompleteLogin(ctx context.Context, challenge, code string) (string, error) {
+	userID, err := s.ChallengeUser(challenge)
+	if err != nil {
+		return "", err
+	}
+	// The login handler throttles this step by account and IP itself
+	if err := s.verifyCode(ctx, userID, code); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// RequiresStepUp reports whether a payment of amount needs a fresh MFA code.
+// Amounts in another currency than the threshold always do, since they
+// cannot be compared.
+func (s *MFAService) RequiresStepUp(amount model.Money) bool {
+	if amount.Currency == "" {
+		amount.Currency = model.DefaultCurrency
+	}
+	above, err := s.StepUpThreshold.LessThan(amount)
+	return err != nil || above
+}
+
+// throttled runs check unless the account is locked out, counting a wrong
+// code as a failure and a right one as a success.
+func (s *MFAService) throttled(ctx context.Context, userID string, check func() error) error {
+	if s.Throttle == nil {
+		return check()
+	}
+	account := AccountKeyForUser(userID)
+	if err := s.Throttle.CheckAccount(ctx, account); err != nil {
+		return err
+	}
+
+	err := check()
+	switch {
+	case errors.Is(err, ErrInvalidMFACode):
+		if recordErr := s.Throttle.RecordAccountFailure(ctx, account, userID); recordErr != nil {
+			log.Printf("Failed to record MFA failure: %v", recordErr)
+		}
+	case err == nil:
+		if recordErr := s.Throttle.RecordSuccess(ctx, account); recordErr != nil {
+			log.Printf("Failed to reset MFA attempts: %v", recordErr)
+		}
+	}
+	return err
+}
+
+func (s *MFAService) verifyCode(ctx context.Context, userID, code string) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.verify(tx, userID, code)
+	})
+}
+
+func (s *MFAService) verify(tx *gorm.DB, userID, code string) error {
+	var enrollment model.MFAEnrollment
+	if err := tx.First(&enrollment, "user_id = ? AND confirmed_at IS NOT NULL", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMFANotEnrolled
+		}
+		return fmt.Errorf("failed to fetch MFA enrollment: %v", err)
+	}
+	if code == "" {
+		return ErrMFARequired
+	}
+
+	// Six digits is a TOTP code; anything else is tried as a recovery code
+	if len(strings.TrimSpace(code)) == utils.TOTPDigits {
+		if err := consumeTOTP(tx, &enrollment, code); err != nil {
+			return err
+		}
+		return tx.Save(&enrollment).Error
+	}
+	return consumeRecoveryCode(tx, userID, code)
+}
+
+// consumeTOTP accepts a code at most once by remembering its time step.
+func consumeTOTP(tx *gorm.DB, enrollment *model.MFAEnrollment, code string) error {
+	step, ok := utils.ValidateTOTP(enrollment.Secret, code, time.Now(), totpSkew)
+	if !ok || step <= enrollment.LastUsedStep {
+		return ErrInvalidMFACode
+	}
+	enrollment.LastUsedStep = step
+	return nil
+}
+
+func consumeRecoveryCode(tx *gorm.DB, userID, code string) error {
+	var record model.MFARecoveryCode
+	err := tx.First(&record, "code_id = ? AND user_id = ? AND used_at IS NULL", hashOpaqueToken(normalizeRecoveryCode(code)), userID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidMFACode
+		}
+		return fmt.Errorf("failed to fetch recovery code: %v", err)
+	}
+	now := time.Now()
+	record.UsedAt = &now
+	return tx.Save(&record).Error
+}
+
+// replaceRecoveryCodes drops the user's old recovery codes and stores new ones.
+func replaceRecoveryCodes(tx *gorm.DB, userID string) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.MFARecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to remove recovery codes: %v", err)
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		encoded := strings.ToLower(base32.StdEncoding.EncodeToString(raw))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+
+		record := model.MFARecoveryCode{
+			CodeID:    hashOpaqueToken(normalizeRecoveryCode(code)),
+			UserID:    userID,
+			CreatedAt: time.Now(),
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %v", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type codes without the dash or in any case.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"poc/model"
+	"poc/utils"
+	"testing"
+	"time"
+)
+
+func TestConsumeTOTPRefusesReplay(t *testing.T) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment := &model.MFAEnrollment{Secret: secret}
+	step := utils.TOTPStep(time.Now())
+	code, err := utils.TOTPCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := consumeTOTP(nil, enrollment, code); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if enrollment.LastUsedStep != step {
+		t.Errorf("LastUsedStep = %d, want %d", enrollment.LastUsedStep, step)
+	}
+	if err := consumeTOTP(nil, enrollment, code); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("replay: got %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestConsumeTOTPRefusesOlderStep(t *testing.T) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := utils.TOTPStep(time.Now())
+	// A code from the previous step is within skew but older than the last one used
+	enrollment := &model.MFAEnrollment{Secret: secret, LastUsedStep: step}
+	code, err := utils.TOTPCode(secret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumeTOTP(nil, enrollment, code); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("got %v, want ErrInvalidMFACode", err)
+	}
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPasswordResetTTL is how long a password reset link stays valid.
+	DefaultPasswordResetTTL = time.Hour
+	// MinPasswordLength is the shortest password accepted on reset or change.
+	MinPasswordLength = 8
+)
+
+// ErrInvalidResetToken is returned for unknown, expired or already used reset tokens.
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// ErrIncorrectPassword is returned when the current password given to
+// ChangePassword is wrong.
+var ErrIncorrectPassword = errors.New("current password is incorrect")
+
+// ForgotPassword mails a single-use reset link if the email belongs to a user.
+// Unknown emails succeed silently so the endpoint does not reveal accounts.
+func (svc *UserService) ForgotPassword(ctx context.Context, email string) error {
+	var user model.User
+	if err := svc.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch user: %v", err)
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %v", err)
+	}
+
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the newest link works
+		if err := supersedeResetTokens(tx, user.UserID); err != nil {
+			return err
+		}
+		record := model.PasswordResetToken{
+			TokenID:   hashOpaqueToken(token),
+			UserID:    user.UserID,
+			ExpiresAt: time.Now().Add(svc.PasswordResetTTL),
+			CreatedAt: time.Now(),
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %v", err)
+	}
+
+	link := fmt.Sprintf("%s/password/reset?token=%s", svc.PublicURL, url.QueryEscape(token))
+	return svc.Mailer.Send(ctx, EmailMessage{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nReset your password with this link:\n\n%s\n\nThe link expires in %s and works once. If you did not ask for this, ignore this email.",
+			user.FirstName, link, svc.PasswordResetTTL),
+	})
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out of every session.
+func (svc *UserService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	var userID string
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		var record model.PasswordResetToken
+		if err := tx.First(&record, "token_id = ?", hashOpaqueToken(token)).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidResetToken
+			}
+			return fmt.Errorf("failed to fetch reset token: %v", err)
+		}
+		if record.UsedAt != nil || time.Now().After(record.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+		userID = record.UserID
+
+		if err := supersedeResetTokens(tx, userID); err != nil {
+			return err
+		}
+		return svc.setPassword(tx, userID, newPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	return svc.revokeAfterPasswordChange(ctx, userID)
+}
+
+// ChangePassword replaces the user's password after checking the current one
+// and signs the user out of every session, including the current one. Wrong
+// current passwords count towards the account's login lockout, so a stolen
+// session cannot be used to guess the password.
+func (svc *UserService) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error {
+	var user model.User
+	if err := svc.DB.First(&user, "UserID = ?", userID).Error; err != nil {
+		return errors.New("user not found")
+	}
+	if err := svc.checkCurrentPassword(ctx, &user, currentPassword); err != nil {
+		return err
+	}
+	if currentPassword == newPassword {
+		return errors.New("new password must differ from the current password")
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := supersedeResetTokens(tx, userID); err != nil {
+			return err
+		}
+		return svc.setPassword(tx, userID, newPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	return svc.revokeAfterPasswordChange(ctx, userID)
+}
+
+// checkCurrentPassword verifies the user's password, throttled per account.
+func (svc *UserService) checkCurrentPassword(ctx context.Context, user *model.User, password string) error {
+	if svc.Throttle == nil {
+		if !utils.CheckPasswordHash(password, user.PasswordHash) {
+			return ErrIncorrectPassword
+		}
+		return nil
+	}
+	account := AccountKeyForUser(user.UserID)
+	if err := svc.Throttle.CheckAccount(ctx, account); err != nil {
+		return err
+	}
+
+	if !utils.CheckPasswordHash(password, user.PasswordHash) {
+		if recordErr := svc.Throttle.RecordAccountFailure(ctx, account, user.UserID); recordErr != nil {
+			log.Printf("Failed to record password failure: %v", recordErr)
+		}
+		return ErrIncorrectPassword
+	}
+	if recordErr := svc.Throttle.RecordSuccess(ctx, account); recordErr != nil {
+		log.Printf("Failed to reset password attempts: %v", recordErr)
+	}
+	return nil
+}
+
+func (svc *UserService) setPassword(tx *gorm.DB, userID, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.User{}).Where("UserID = ?", userID).Updates(map[string]interface{}{
+		"PasswordHash": hashedPassword,
+		"UpdatedAt":    time.Now(),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+	return nil
+}
+
+// revokeAfterPasswordChange ends every session of the user. The password has
+// already changed by then, so the error says so.
+func (svc *UserService) revokeAfterPasswordChange(ctx context.Context, userID string) error {
+	if err := svc.Tokens.RevokeAllSessions(ctx, userID); err != nil {
+		log.Printf("Failed to revoke sessions for user %s after password change: %v", userID, err)
+		return fmt.Errorf("password updated but existing sessions could not be revoked: %v", err)
+	}
+	return nil
+}
+
+// supersedeResetTokens marks every outstanding reset token of the user as used.
+func supersedeResetTokens(tx *gorm.DB, userID string) error {
+	err := tx.Model(&model.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to invalidate reset tokens: %v", err)
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"poc/card"
 	"poc/model"
 	"poc/utils"
 	"time"
@@ -10,31 +12,191 @@ import (
 	"gorm.io/gorm"
 )
 
+// ErrPaymentMethodNotFound is returned for unknown payment methods and for
+// methods that belong to another payer.
+var ErrPaymentMethodNotFound = errors.New("payment method not found")
+
+// ErrPaymentMethodInUse is returned when removing a method that in-flight
+// transactions still reference.
+var ErrPaymentMethodInUse = errors.New("payment method is used by transactions still in progress")
+
+// inFlightStatuses are the transaction states that may still charge the
+// payment method.
+var inFlightStatuses = []model.TransactionStatus{model.StatusPending, model.StatusReserved, model.StatusAuthorized, model.StatusPartiallyCaptured}
+
 // PaymentMethodService provides methods for working with payment methods
 type PaymentMethodService struct {
-	DB *gorm.DB
+	DB                   *gorm.DB
+	Vault                *Vault   // Holds card and account numbers
+	BankRail             BankRail // Sends micro-deposits to verify bank accounts
+	PSP                  PSP      // Resolves UPI IDs
+	MicroDepositAttempts int      // Wrong confirmations allowed per bank account
 }
 
 // NewPaymentMethodService creates a new instance of PaymentMethodService
-func NewPaymentMethodService(db *gorm.DB) *PaymentMethodService {
-	return &PaymentMethodService{DB: db}
+func NewPaymentMethodService(db *gorm.DB, vault *Vault, bankRail BankRail, psp PSP) *PaymentMethodService {
+	return &PaymentMethodService{DB: db, Vault: vault, BankRail: bankRail, PSP: psp, MicroDepositAttempts: DefaultMicroDepositAttempts}
 }
 
-func (s *PaymentMethodService) CreatePaymentMethod(paymentMethod model.PaymentMethod) error {
-
+// CreatePaymentMethod validates a new payment method, moves its card or
+// account number into the vault and stores the method with the token.
+func (s *PaymentMethodService) CreatePaymentMethod(ctx context.Context, paymentMethod model.PaymentMethod) (model.PaymentMethod, error) {
 	if paymentMethod.PaymentMethodID == "" {
 		paymentMethod.PaymentMethodID = utils.GenerateUniqueID()
 	}
 
+	if err := validatePaymentMethod(&paymentMethod); err != nil {
+		return paymentMethod, err
+	}
+	if err := s.resolveUPIHolder(ctx, &paymentMethod); err != nil {
+		return paymentMethod, err
+	}
+	// Bank accounts are only usable once the micro-deposits are confirmed
+	paymentMethod.Status = model.PaymentMethodActive
+	if paymentMethod.MethodType == "bank_transfer" {
+		paymentMethod.Status = model.PaymentMethodPendingVerification
+	}
+
+	kind, number := sensitiveNumber(&paymentMethod)
+	if number != "" {
+		paymentMethod.Fingerprint = s.Vault.Fingerprint(kind, number)
+		paymentMethod.Last4 = LastFour(number)
+	}
+
 	// Call CheckPaymentMethodExists to validate if payment method already exists
-	exists, err := s.CheckPaymentMethodExists(paymentMethod.PayerID, paymentMethod.MethodType, paymentMethod.CardNumber, paymentMethod.AccountNumber)
+	exists, err := s.CheckPaymentMethodExists(paymentMethod.PayerID, paymentMethod.MethodType, paymentMethod.Fingerprint, paymentMethod.Details)
 	if err != nil {
-		return err
+		return paymentMethod, err
 	}
 	if exists {
-		return errors.New("payment method already exists for this payer")
+		return paymentMethod, errors.New("payment method already exists for this payer")
+	}
+
+	// Insert payment method into the database, with the number in the vault
+	paymentMethod.CreatedAt = time.Now()
+	paymentMethod.UpdatedAt = time.Now()
+	var verification *model.BankAccountVerification
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		// The payer's first active method becomes the default
+		if paymentMethod.Status == model.PaymentMethodActive {
+			var defaults int64
+			if err := tx.Model(&model.PaymentMethod{}).Where("payer_id = ? AND is_default = ?", paymentMethod.PayerID, true).Count(&defaults).Error; err != nil {
+				return err
+			}
+			paymentMethod.IsDefault = defaults == 0
+		}
+		if number != "" {
+			token, err := s.Vault.Tokenize(ctx, tx, paymentMethod.PayerID, kind, number)
+			if err != nil {
+				return err
+			}
+			if kind == model.VaultKindCardNumber {
+				paymentMethod.CardToken = token
+			} else {
+				paymentMethod.AccountToken = token
+			}
+		}
+		if err := tx.Create(&paymentMethod).Error; err != nil {
+			return err
+		}
+		if paymentMethod.MethodType == "bank_transfer" {
+			verification, err = s.startBankVerification(tx, &paymentMethod)
+			return err
+		}
+		return nil
+	})
+	if err == nil && verification != nil {
+		err = s.sendMicroDeposits(ctx, &paymentMethod, verification, number)
+	}
+	paymentMethod.CardNumber, paymentMethod.AccountNumber = "", ""
+	if err != nil {
+		return paymentMethod, fmt.Errorf("failed to create payment method: %v", err)
+	}
+	return paymentMethod, nil
+}
+
+// VaultLegacyNumbers moves card and account numbers stored in plaintext
+// before the vault existed into the vault and blanks the old columns. It is
+// safe to run on every start.
+func (s *PaymentMethodService) VaultLegacyNumbers(ctx context.Context) (int, error) {
+	if !s.DB.Migrator().HasColumn(&model.PaymentMethod{}, "card_number") {
+		return 0, nil
+	}
+
+	var legacy []struct {
+		PaymentMethodID string
+		PayerID         string
+		CardNumber      string
+		AccountNumber   string
+	}
+	err := s.DB.WithContext(ctx).Table(model.PaymentMethod{}.TableName()).
+		Select("payment_method_id, payer_id, card_number, account_number").
+		Where("(card_number IS NOT NULL AND card_number <> '') OR (account_number IS NOT NULL AND account_number <> '')").
+		Find(&legacy).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch legacy payment methods: %v", err)
+	}
+
+	for _, row := range legacy {
+		err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			kind, number, tokenColumn := model.VaultKindCardNumber, row.CardNumber, "card_token"
+			if number == "" {
+				kind, number, tokenColumn = model.VaultKindAccountNumber, row.AccountNumber, "account_token"
+			}
+			token, err := s.Vault.Tokenize(ctx, tx, row.PayerID, kind, number)
+			if err != nil {
+				return err
+			}
+			return tx.Table(model.PaymentMethod{}.TableName()).
+				Where("payment_method_id = ?", row.PaymentMethodID).
+				Updates(map[string]interface{}{
+					tokenColumn:      token,
+					"fingerprint":    s.Vault.Fingerprint(kind, number),
+					"last4":          LastFour(number),
+					"brand":          string(card.Detect(row.CardNumber)),
+					"card_number":    "",
+					"account_number": "",
+				}).Error
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to vault payment method %s: %v", row.PaymentMethodID, err)
+		}
+	}
+	return len(legacy), nil
+}
+
+// resolveUPIHolder looks up the account holder behind a UPI ID, so payers
+// can see who they are paying from and typos are caught before saving.
+func (s *PaymentMethodService) resolveUPIHolder(ctx context.Context, paymentMethod *model.PaymentMethod) error {
+	if paymentMethod.MethodType != "upi" {
+		return nil
+	}
+	name, err := s.PSP.ResolveVPA(ctx, paymentMethod.Details)
+	if err != nil {
+		if errors.Is(err, ErrVPANotFound) {
+			return fmt.Errorf("UPI ID %s could not be verified", paymentMethod.Details)
+		}
+		return fmt.Errorf("failed to verify UPI ID: %v", err)
+	}
+	paymentMethod.HolderName = name
+	return nil
+}
+
+// sensitiveNumber returns the number of a new payment method that belongs in
+// the vault, if it has one.
+func sensitiveNumber(paymentMethod *model.PaymentMethod) (kind, number string) {
+	switch paymentMethod.MethodType {
+	case "card":
+		return model.VaultKindCardNumber, paymentMethod.CardNumber
+	case "bank_transfer":
+		return model.VaultKindAccountNumber, paymentMethod.AccountNumber
 	}
-	fmt.Println(paymentMethod, "-paymentMethod")
+	return "", ""
+}
+
+// validatePaymentMethod checks the fields required by the method's type. Card
+// and account numbers are only checked before they are vaulted.
+func validatePaymentMethod(paymentMethod *model.PaymentMethod) error {
 	// Validate MethodType
 	if paymentMethod.MethodType == "" {
 		return errors.New("payment method type is required")
@@ -44,16 +206,21 @@ func (s *PaymentMethodService) CreatePaymentMethod(paymentMethod model.PaymentMe
 	switch paymentMethod.MethodType {
 	case "card":
 		// Validate CardNumber and ExpiryDate for card payment method
-		if len(paymentMethod.CardNumber) != 16 || !utils.IsNumeric(paymentMethod.CardNumber) {
-			return errors.New("invalid card number, must be 16 digits")
+		if paymentMethod.CardToken == "" {
+			paymentMethod.CardNumber = card.Normalize(paymentMethod.CardNumber)
+			brand, err := card.Validate(paymentMethod.CardNumber)
+			if err != nil {
+				return err
+			}
+			paymentMethod.Brand = string(brand)
 		}
-		if paymentMethod.ExpiryDate == "" || len(paymentMethod.ExpiryDate) != 5 || !utils.IsValidExpiryDate(paymentMethod.ExpiryDate) {
-			return errors.New("invalid expiry date, format should be MM/YY")
+		if err := card.CheckExpiry(paymentMethod.ExpiryDate, time.Now()); err != nil {
+			return err
 		}
 
 	case "bank_transfer":
 		// Validate AccountNumber for bank transfer
-		if paymentMethod.AccountNumber == "" || len(paymentMethod.AccountNumber) < 11 || len(paymentMethod.AccountNumber) > 16 {
+		if paymentMethod.AccountToken == "" && (len(paymentMethod.AccountNumber) < 11 || len(paymentMethod.AccountNumber) > 16) {
 			return errors.New("invalid account number")
 		}
 
@@ -79,60 +246,33 @@ func (s *PaymentMethodService) CreatePaymentMethod(paymentMethod model.PaymentMe
 		}
 
 	case "cheque":
-		// Validate Cheque number
+		// The chequebook's account; cheque numbers come with each payment
 		if paymentMethod.Details == "" {
-			return errors.New("cheque number is required")
-		}
-		// Optional: Cheque number validation, for example checking if it's numeric
-		if !utils.IsNumeric(paymentMethod.Details) {
-			return errors.New("cheque number must be numeric")
+			return errors.New("chequebook account is required")
 		}
 
 	default:
 		return errors.New("invalid payment method type")
 	}
-
-	// Insert payment method into the database
-	paymentMethod.CreatedAt = time.Now()
-	paymentMethod.UpdatedAt = time.Now()
-	return s.DB.Create(&paymentMethod).Error
+	return nil
 }
 
-// CheckPaymentMethodExists checks if a payment method already exists for the given payer.
-func (s *PaymentMethodService) CheckPaymentMethodExists(payerID, methodType, cardNumber, accountNumber string) (bool, error) {
-	var existingPaymentMethod model.PaymentMethod
-	var err error
-	// Adjust the query based on the method_type
-	if methodType == "card" {
-		// Only check for card_number
-		err := s.DB.Where("payer_id = ? AND method_type = ? AND card_number = ?", payerID, methodType, cardNumber).First(&existingPaymentMethod).Error
-		if err == nil {
-			// Payment method already exists for this card
-			return true, nil
-		}
-	} else if methodType == "bank_transfer" {
-		// Only check for account_number
-		err := s.DB.Where("payer_id = ? AND method_type = ? AND account_number = ?", payerID, methodType, accountNumber).First(&existingPaymentMethod).Error
-		if err == nil {
-			// Payment method already exists for this account number
-			return true, nil
-		}
+// CheckPaymentMethodExists checks if a payment method already exists for the
+// given payer. Cards and bank accounts are matched on the fingerprint of their
+// number, other methods on their details.
+func (s *PaymentMethodService) CheckPaymentMethodExists(payerID, methodType, fingerprint, details string) (bool, error) {
+	query := s.DB.Model(&model.PaymentMethod{}).Where("payer_id = ? AND method_type = ? AND status <> ?", payerID, methodType, model.PaymentMethodRemoved)
+	if methodType == "card" || methodType == "bank_transfer" {
+		query = query.Where("fingerprint = ?", fingerprint)
 	} else {
-		// For other types, check both fields (if required)
-		err := s.DB.Where("payer_id = ? AND method_type = ? AND (card_number = ? OR account_number = ?)", payerID, methodType, cardNumber, accountNumber).First(&existingPaymentMethod).Error
-		if err == nil {
-			// Payment method already exists
-			return true, nil
-		}
+		query = query.Where("details = ?", details)
 	}
 
-	// If the record is not found, it's valid to add a new one
-	if err == gorm.ErrRecordNotFound {
-		return false, nil
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
 	}
-
-	// Handle other DB errors
-	return false, err
+	return count > 0, nil
 }
 
 /* one
@@ -186,7 +326,7 @@ func (s *PaymentMethodService) CreatePaymentMethod(paymentMethod model.PaymentMe
 	case "cheque":
 		// Validate for cheque method if required
 		if paymentMethod.Details == "" {
-			return errors.New("cheque number is required")
+			return errors.New("chequebook account is required")
 		}
 
 	default:
@@ -237,10 +377,11 @@ func (s *PaymentMethodService) CheckPaymentMethodExists(payerID, methodType, car
 }
 old working with already exist error
 */
-// GetPaymentMethods fetches all payment methods for a given payer ID
+// GetPaymentMethods fetches all payment methods for a given payer ID, except
+// removed ones
 func (s *PaymentMethodService) GetPaymentMethods(payerID string) ([]model.PaymentMethod, error) {
 	var paymentMethods []model.PaymentMethod
-	err := s.DB.Where("payer_id = ?", payerID).Find(&paymentMethods).Error
+	err := s.DB.Where("payer_id = ? AND status <> ?", payerID, model.PaymentMethodRemoved).Find(&paymentMethods).Error
 	if err != nil {
 		return nil, err
 	}
@@ -248,7 +389,7 @@ func (s *PaymentMethodService) GetPaymentMethods(payerID string) ([]model.Paymen
 }
 func (svc *TransactionService) VerifyFetchedPaymentMethod(paymentMethod *model.PaymentMethod) error {
 
-	if paymentMethod == nil || paymentMethod.Status != "active" {
+	if paymentMethod == nil || paymentMethod.Status != model.PaymentMethodActive {
 		return errors.New("invalid or inactive payment method")
 	}
 	return nil
@@ -300,24 +441,297 @@ func (svc *TransactionService) VerifyFetchedPaymentMethod(paymentMethod *model.P
 // 	return paymentMethod, nil
 // }
 
-// ********** NEED TO WORK ON IT LATER ******************//
-// UpdatePaymentMethod updates the payment method details
-func (s *PaymentMethodService) UpdatePaymentMethod(paymentMethodID string, updates map[string]interface{}) error {
-	// Update the payment method in the database using the given updates
-	updates["updated_at"] = time.Now()
-	return s.DB.Model(&model.PaymentMethod{}).
-		Where("payment_method_id = ?", paymentMethodID).
-		Updates(updates).Error
+// GetPaymentMethod fetches one of the payer's payment methods. Methods that
+// belong to someone else, or were removed, are reported as not found.
+func (s *PaymentMethodService) GetPaymentMethod(payerID, paymentMethodID string) (model.PaymentMethod, error) {
+	var paymentMethod model.PaymentMethod
+	err := s.DB.First(&paymentMethod, "payment_method_id = ? AND payer_id = ? AND status <> ?", paymentMethodID, payerID, model.PaymentMethodRemoved).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return paymentMethod, ErrPaymentMethodNotFound
+		}
+		return paymentMethod, fmt.Errorf("failed to fetch payment method: %v", err)
+	}
+	return paymentMethod, nil
 }
 
-// ValidatePaymentMethod ensures the payment method is valid and active
-func (s *PaymentMethodService) ValidatePaymentMethod(paymentMethodID string) (model.PaymentMethod, error) {
+// IsPaymentMethodOwner reports whether the payment method belongs to the
+// payer and has not been removed.
+func (s *PaymentMethodService) IsPaymentMethodOwner(ctx context.Context, payerID, paymentMethodID string) (bool, error) {
+	var count int64
+	err := s.DB.WithContext(ctx).Model(&model.PaymentMethod{}).
+		Where("payment_method_id = ? AND payer_id = ? AND status <> ?", paymentMethodID, payerID, model.PaymentMethodRemoved).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check payment method owner: %v", err)
+	}
+	return count > 0, nil
+}
+
+// UpdatePaymentMethod applies the allowed changes to one of the payer's
+// payment methods and re-validates the result. An expired card only comes
+// back with a future expiry date and its card number and CVV, which are
+// checked against the stored card and re-tokenized.
+func (s *PaymentMethodService) UpdatePaymentMethod(ctx context.Context, payerID, paymentMethodID string, update model.PaymentMethodUpdate) (model.PaymentMethod, error) {
+	paymentMethod, err := s.GetPaymentMethod(payerID, paymentMethodID)
+	if err != nil {
+		return paymentMethod, err
+	}
+	reactivate := paymentMethod.Status == model.PaymentMethodExpired && update.ExpiryDate != nil
+	if (update.CardNumber != nil || update.CVV != nil) && !reactivate {
+		return paymentMethod, errors.New("card number and CVV are only accepted with a new expiry date for an expired card")
+	}
+
+	if update.ExpiryDate != nil {
+		if paymentMethod.MethodType != "card" {
+			return paymentMethod, errors.New("expiry date can only be set on cards")
+		}
+		paymentMethod.ExpiryDate = *update.ExpiryDate
+	}
+	if update.Details != nil && *update.Details != paymentMethod.Details {
+		paymentMethod.Details = *update.Details
+		if err := s.resolveUPIHolder(ctx, &paymentMethod); err != nil {
+			return paymentMethod, err
+		}
+	}
+	if update.Status != nil {
+		// Owners can only pause and resume; the other states are set by us
+		next := model.PaymentMethodStatus(*update.Status)
+		if next != model.PaymentMethodActive && next != model.PaymentMethodSuspended {
+			return paymentMethod, errors.New("status must be active or suspended")
+		}
+		if paymentMethod.Status == model.PaymentMethodExpired {
+			return paymentMethod, errors.New("an expired card is reactivated by sending its new expiry date")
+		}
+		if next != paymentMethod.Status {
+			if err := paymentMethod.Status.ValidateTransition(next); err != nil {
+				return paymentMethod, err
+			}
+			paymentMethod.Status = next
+		}
+	}
+	if err := validatePaymentMethod(&paymentMethod); err != nil {
+		return paymentMethod, err
+	}
+	// validatePaymentMethod has rejected past dates; the card itself is
+	// checked again before it leaves expired
+	number := ""
+	if reactivate {
+		if number, err = s.reverifyCard(&paymentMethod, update); err != nil {
+			return paymentMethod, err
+		}
+		paymentMethod.Status = model.PaymentMethodActive
+	}
+	// A method that cannot be used stops being the default
+	if paymentMethod.Status != model.PaymentMethodActive {
+		paymentMethod.IsDefault = false
+	}
+
+	paymentMethod.UpdatedAt = time.Now()
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		// The re-entered number replaces the old vault entry
+		if number != "" {
+			token, err := s.Vault.Tokenize(ctx, tx, payerID, model.VaultKindCardNumber, number)
+			if err != nil {
+				return err
+			}
+			if err := s.Vault.Purge(ctx, tx, paymentMethod.CardToken); err != nil {
+				return err
+			}
+			paymentMethod.CardToken = token
+		}
+		return tx.Model(&model.PaymentMethod{}).
+			Where("payment_method_id = ? AND payer_id = ?", paymentMethodID, payerID).
+			Updates(map[string]interface{}{
+				"card_token":  paymentMethod.CardToken,
+				"expiry_date": paymentMethod.ExpiryDate,
+				"details":     paymentMethod.Details,
+				"holder_name": paymentMethod.HolderName,
+				"status":      paymentMethod.Status,
+				"is_default":  paymentMethod.IsDefault,
+				"updated_at":  paymentMethod.UpdatedAt,
+			}).Error
+	})
+	if err != nil {
+		return paymentMethod, fmt.Errorf("failed to update payment method: %v", err)
+	}
+	return paymentMethod, nil
+}
+
+// reverifyCard checks that the card number and CVV sent to reactivate an
+// expired card belong to the stored card, and returns the normalized number.
+func (s *PaymentMethodService) reverifyCard(paymentMethod *model.PaymentMethod, update model.PaymentMethodUpdate) (string, error) {
+	if update.CardNumber == nil || update.CVV == nil {
+		return "", errors.New("card number and CVV are required to reactivate an expired card")
+	}
+	number := card.Normalize(*update.CardNumber)
+	brand, err := card.Validate(number)
+	if err != nil {
+		return "", err
+	}
+	if s.Vault.Fingerprint(model.VaultKindCardNumber, number) != paymentMethod.Fingerprint {
+		return "", errors.New("card number does not match this payment method")
+	}
+	if err := card.ValidateCVV(brand, *update.CVV); err != nil {
+		return "", err
+	}
+	return number, nil
+}
+
+// GetDefaultPaymentMethod returns the payer's default payment method.
+func (s *PaymentMethodService) GetDefaultPaymentMethod(payerID string) (model.PaymentMethod, error) {
 	var paymentMethod model.PaymentMethod
-	err := s.DB.First(&paymentMethod, "payment_method_id = ?", paymentMethodID).Error
+	err := s.DB.First(&paymentMethod, "payer_id = ? AND is_default = ?", payerID, true).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return paymentMethod, errors.New("no default payment method; pass payment_method_id or set a default")
+		}
+		return paymentMethod, fmt.Errorf("failed to fetch default payment method: %v", err)
+	}
+	return paymentMethod, nil
+}
+
+// SetDefaultPaymentMethod makes one of the payer's active payment methods the
+// default and clears the flag on the others.
+func (s *PaymentMethodService) SetDefaultPaymentMethod(payerID, paymentMethodID string) (model.PaymentMethod, error) {
+	paymentMethod, err := s.GetPaymentMethod(payerID, paymentMethodID)
+	if err != nil {
+		return paymentMethod, err
+	}
+	if paymentMethod.Status != model.PaymentMethodActive {
+		return paymentMethod, errors.New("only an active payment method can be the default")
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.PaymentMethod{}).
+			Where("payer_id = ? AND is_default = ? AND payment_method_id <> ?", payerID, true, paymentMethodID).
+			Updates(map[string]interface{}{"is_default": false, "updated_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&model.PaymentMethod{}).
+			Where("payment_method_id = ? AND payer_id = ?", paymentMethodID, payerID).
+			Updates(map[string]interface{}{"is_default": true, "updated_at": time.Now()}).Error
+	})
+	if err != nil {
+		return paymentMethod, fmt.Errorf("failed to set default payment method: %v", err)
+	}
+	paymentMethod.IsDefault = true
+	return paymentMethod, nil
+}
+
+// RemovePaymentMethod soft-deletes one of the payer's payment methods: the
+// row is kept for the transactions that reference it, but its vaulted numbers
+// are purged and it can no longer be used or seen. Methods that in-flight
+// transactions still reference cannot be removed.
+func (s *PaymentMethodService) RemovePaymentMethod(ctx context.Context, payerID, paymentMethodID string) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var paymentMethod model.PaymentMethod
+		err := tx.First(&paymentMethod, "payment_method_id = ? AND payer_id = ? AND status <> ?", paymentMethodID, payerID, model.PaymentMethodRemoved).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPaymentMethodNotFound
+			}
+			return fmt.Errorf("failed to fetch payment method: %v", err)
+		}
+		if err := paymentMethod.Status.ValidateTransition(model.PaymentMethodRemoved); err != nil {
+			return err
+		}
+
+		var inFlight int64
+		err = tx.Model(&model.Transaction{}).
+			Where("payment_method_id = ? AND status IN ?", paymentMethodID, inFlightStatuses).
+			Count(&inFlight).Error
+		if err != nil {
+			return fmt.Errorf("failed to check transactions: %v", err)
+		}
+		if inFlight > 0 {
+			return ErrPaymentMethodInUse
+		}
+
+		for _, token := range []string{paymentMethod.CardToken, paymentMethod.AccountToken} {
+			if err := s.Vault.Purge(ctx, tx, token); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		err = tx.Model(&model.PaymentMethod{}).
+			Where("payment_method_id = ?", paymentMethodID).
+			Updates(map[string]interface{}{
+				"status":        model.PaymentMethodRemoved,
+				"card_token":    "",
+				"account_token": "",
+				"is_default":    false,
+				"removed_at":    now,
+				"updated_at":    now,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to remove payment method: %v", err)
+		}
+
+		if paymentMethod.IsDefault {
+			return promoteDefault(tx, payerID)
+		}
+		return nil
+	})
+}
+
+// ExpireCards marks cards past their expiry month as expired. It returns how
+// many were marked.
+func (s *PaymentMethodService) ExpireCards(ctx context.Context) (int, error) {
+	var cards []model.PaymentMethod
+	err := s.DB.WithContext(ctx).
+		Where("method_type = ? AND status IN ?", "card", []model.PaymentMethodStatus{model.PaymentMethodActive, model.PaymentMethodSuspended}).
+		Find(&cards).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch cards: %v", err)
+	}
+
+	expired := 0
+	now := time.Now()
+	for _, paymentMethod := range cards {
+		if !errors.Is(card.CheckExpiry(paymentMethod.ExpiryDate, now), card.ErrExpired) {
+			continue
+		}
+		err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// The status guard skips cards changed since they were read
+			result := tx.Model(&model.PaymentMethod{}).
+				Where("payment_method_id = ? AND status = ?", paymentMethod.PaymentMethodID, paymentMethod.Status).
+				Updates(map[string]interface{}{"status": model.PaymentMethodExpired, "is_default": false, "updated_at": now})
+			if result.Error != nil || result.RowsAffected == 0 || !paymentMethod.IsDefault {
+				return result.Error
+			}
+			return promoteDefault(tx, paymentMethod.PayerID)
+		})
+		if err != nil {
+			return expired, fmt.Errorf("failed to expire payment method %s: %v", paymentMethod.PaymentMethodID, err)
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// promoteDefault makes the payer's oldest active method the default, if
+// there is one, after the default went away.
+func promoteDefault(tx *gorm.DB, payerID string) error {
+	var next model.PaymentMethod
+	err := tx.Where("payer_id = ? AND status = ?", payerID, model.PaymentMethodActive).Order("created_at").First(&next).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to pick a new default payment method: %v", err)
+	}
+	return tx.Model(&model.PaymentMethod{}).Where("payment_method_id = ?", next.PaymentMethodID).Update("is_default", true).Error
+}
+
+// ValidatePaymentMethod ensures the payer's payment method is valid and active
+func (s *PaymentMethodService) ValidatePaymentMethod(payerID, paymentMethodID string) (model.PaymentMethod, error) {
+	paymentMethod, err := s.GetPaymentMethod(payerID, paymentMethodID)
 	if err != nil {
 		return paymentMethod, err
 	}
-	if paymentMethod.Status != "active" {
+	if paymentMethod.Status != model.PaymentMethodActive {
 		return paymentMethod, errors.New("payment method is not active")
 	}
 	return paymentMethod, nil
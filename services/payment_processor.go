@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/card"
+	"poc/model"
+	"poc/utils"
+	"sync"
+	"time"
+)
+
+// DefaultProcessorTimeout bounds every call to a payment processor. Saga
+// recovery waits well past it before treating a payment as interrupted.
+const DefaultProcessorTimeout = 30 * time.Second
+
+var (
+	// ErrPaymentDeclined is returned when the processor refuses a payment.
+	ErrPaymentDeclined = errors.New("payment declined")
+	// ErrAuthenticationRequired is returned when the issuer wants the payer to
+	// complete 3-D Secure before it approves the payment.
+	ErrAuthenticationRequired = errors.New("payment requires 3-D Secure authentication")
+	// ErrProcessorTimeout is returned when the processor did not answer in
+	// time. The payment may or may not have gone through; Status tells.
+	ErrProcessorTimeout = errors.New("payment processor timed out")
+	// ErrProcessorPaymentNotFound is returned for references the processor does not know.
+	ErrProcessorPaymentNotFound = errors.New("payment not found at processor")
+)
+
+// Processor-side payment states.
+const (
+	ProcessorAuthorized     = "authorized"
+	ProcessorCaptured       = "captured"
+	ProcessorVoided         = "voided"
+	ProcessorRefunded       = "refunded" // Fully refunded; partial refunds stay captured
+	ProcessorDeclined       = "declined"
+	ProcessorActionRequired = "action_required" // Waiting on 3-D Secure
+)
+
+// ProcessorRequest is a payment sent to an acquirer, bank or wallet provider.
+// TransactionID doubles as the merchant reference, so a payment can be looked
+// up with Status even when the authorize call timed out.
+type ProcessorRequest struct {
+	TransactionID string
+	Amount        model.Money
+	MethodType    string
+	CardNumber    string // Full card number for card payments, straight from the vault
+	Details       string // UPI ID, wallet ID, ...
+}
+
+// ProcessorResult is the processor's view of a payment.
+type ProcessorResult struct {
+	Reference      string      // Processor's identifier for the payment
+	Status         string      // One of the Processor* states
+	DeclineCode    string      // Why the payment was declined, if it was
+	Amount         model.Money // Amount authorized
+	CapturedAmount model.Money // Captured so far
+	RefundedAmount model.Money // Refunded so far
+}
+
+// PaymentProcessor talks to whoever actually moves the money for a payment
+// method. Production deployments plug in a real acquirer per method type;
+// the simulator below is for local development.
+type PaymentProcessor interface {
+	// Authorize asks for approval of req.Amount without collecting it.
+	// Declines and 3-D Secure challenges come back as a result, not an error.
+	Authorize(ctx context.Context, req ProcessorRequest) (ProcessorResult, error)
+	// Capture collects amount of an authorized payment.
+	Capture(ctx context.Context, reference string, amount model.Money) (ProcessorResult, error)
+	// Refund returns amount of a captured payment.
+	Refund(ctx context.Context, reference string, amount model.Money) (ProcessorResult, error)
+	// Void cancels what is left of an authorization.
+	Void(ctx context.Context, reference string) (ProcessorResult, error)
+	// Status looks a payment up by the transaction it was made for.
+	Status(ctx context.Context, transactionID string) (ProcessorResult, error)
+}
+
+// NewPaymentProcessor returns the processor named by kind. Only "simulator"
+// exists so far. There is no default: the simulator declines amounts ending
+// in .51 and forgets every payment on restart, so it has to be asked for.
+func NewPaymentProcessor(kind string) (PaymentProcessor, error) {
+	switch kind {
+	case "":
+		return nil, errors.New("no payment processor configured")
+	case "simulator":
+		return NewSimulatedProcessor(), nil
+	default:
+		return nil, fmt.Errorf("unknown payment processor %q", kind)
+	}
+}
+
+// ProcessorRegistry picks the processor for a payment method type.
+type ProcessorRegistry struct {
+	Timeout    time.Duration // Limit on each processor call; zero for none
+	fallback   PaymentProcessor
+	processors map[string]PaymentProcessor
+}
+
+// NewProcessorRegistry creates a registry that uses fallback for every method
+// type without a processor of its own.
+func NewProcessorRegistry(fallback PaymentProcessor) *ProcessorRegistry {
+	return &ProcessorRegistry{Timeout: DefaultProcessorTimeout, fallback: fallback, processors: make(map[string]PaymentProcessor)}
+}
+
+// Register sets the processor for a method type.
+func (r *ProcessorRegistry) Register(methodType string, processor PaymentProcessor) {
+	r.processors[methodType] = processor
+}
+
+// For returns the processor for a method type, with calls cut off after
+// the registry's Timeout.
+func (r *ProcessorRegistry) For(methodType string) PaymentProcessor {
+	processor, ok := r.processors[methodType]
+	if !ok {
+		processor = r.fallback
+	}
+	if r.Timeout <= 0 {
+		return processor
+	}
+	return timeoutProcessor{processor: processor, timeout: r.Timeout}
+}
+
+// timeoutProcessor bounds each call to the wrapped processor and reports
+// calls that ran out of time as ErrProcessorTimeout.
+type timeoutProcessor struct {
+	processor PaymentProcessor
+	timeout   time.Duration
+}
+
+func (p timeoutProcessor) Authorize(ctx context.Context, req ProcessorRequest) (ProcessorResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return timedOut(p.processor.Authorize(ctx, req))
+}
+
+func (p timeoutProcessor) Capture(ctx context.Context, reference string, amount model.Money) (ProcessorResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return timedOut(p.processor.Capture(ctx, reference, amount))
+}
+
+func (p timeoutProcessor) Refund(ctx context.Context, reference string, amount model.Money) (ProcessorResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return timedOut(p.processor.Refund(ctx, reference, amount))
+}
+
+func (p timeoutProcessor) Void(ctx context.Context, reference string) (ProcessorResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return timedOut(p.processor.Void(ctx, reference))
+}
+
+func (p timeoutProcessor) Status(ctx context.Context, transactionID string) (ProcessorResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return timedOut(p.processor.Status(ctx, transactionID))
+}
+
+func timedOut(result ProcessorResult, err error) (ProcessorResult, error) {
+	if errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrProcessorTimeout) {
+		return result, fmt.Errorf("%w: %v", ErrProcessorTimeout, err)
+	}
+	return result, err
+}
+
+// Magic card numbers understood by SimulatedProcessor. Any other valid card
+// is approved.
+const (
+	SimulatorCardDecline           = "4000000000000002"
+	SimulatorCardInsufficientFunds = "4000000000009995"
+	SimulatorCardTimeout           = "4000000000000119"
+	SimulatorCardAuthentication    = "4000000000003220"
+)
+
+// Amounts whose minor units end in these digits trigger the same outcomes
+// for every payment method.
+const (
+	simulatorAmountDecline        = 51
+	simulatorAmountTimeout        = 52
+	simulatorAmountAuthentication = 53
+)
+
+// SimulatedProcessor is an in-memory processor whose outcome depends only on
+// the card number or the amount, so every path can be exercised locally:
+// magic cards (the Simulator* constants) and amounts ending in .51 (decline),
+// .52 (timeout) and .53 (3-D Secure). A timed out payment is still approved,
+// as happens with real acquirers, and shows up through Status.
+type SimulatedProcessor struct {
+	mu       sync.Mutex
+	payments map[string]*ProcessorResult // By reference
+	byTxn    map[string]string           // Transaction ID to reference
+}
+
+// NewSimulatedProcessor creates an empty simulator.
+func NewSimulatedProcessor() *SimulatedProcessor {
+	return &SimulatedProcessor{payments: make(map[string]*ProcessorResult), byTxn: make(map[string]string)}
+}
+
+// Authorize approves, declines, times out or asks for 3-D Secure depending on
+// the card number or amount. Authorizing a transaction again returns the
+// first result.
+func (p *SimulatedProcessor) Authorize(ctx context.Context, req ProcessorRequest) (ProcessorResult, error) {
+	if err := ctx.Err(); err != nil {
+		return ProcessorResult{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if reference, ok := p.byTxn[req.TransactionID]; ok {
+		return *p.payments[reference], nil
+	}
+
+	result := &ProcessorResult{
+		Reference:      "sim_" + utils.GenerateUniqueID(),
+		Status:         ProcessorAuthorized,
+		Amount:         req.Amount,
+		CapturedAmount: model.ZeroMoney(req.Amount.Currency),
+		RefundedAmount: model.ZeroMoney(req.Amount.Currency),
+	}
+	timedOut := false
+	number := card.Normalize(req.CardNumber)
+	switch {
+	case number == SimulatorCardDecline || req.Amount.MinorUnits%100 == simulatorAmountDecline:
+		result.Status, result.DeclineCode = ProcessorDeclined, "do_not_honor"
+	case number == SimulatorCardInsufficientFunds:
+		result.Status, result.DeclineCode = ProcessorDeclined, "insufficient_funds"
+	case number == SimulatorCardAuthentication || req.Amount.MinorUnits%100 == simulatorAmountAuthentication:
+		result.Status = ProcessorActionRequired
+	case number == SimulatorCardTimeout || req.Amount.MinorUnits%100 == simulatorAmountTimeout:
+		timedOut = true
+	}
+	p.payments[result.Reference] = result
+	p.byTxn[req.TransactionID] = result.Reference
+
+	log.Printf("Simulated %s authorization of %s for transaction %s: %s %s", req.MethodType, req.Amount, req.TransactionID, result.Status, result.DeclineCode)
+	if timedOut {
+		return ProcessorResult{}, ErrProcessorTimeout
+	}
+	return *result, nil
+}
+
+// Capture collects part or all of what is left of the authorization.
+func (p *SimulatedProcessor) Capture(ctx context.Context, reference string, amount model.Money) (ProcessorResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment, ok := p.payments[reference]
+	if !ok {
+		return ProcessorResult{}, ErrProcessorPaymentNotFound
+	}
+	if payment.Status != ProcessorAuthorized {
+		return ProcessorResult{}, fmt.Errorf("cannot capture a %s payment", payment.Status)
+	}
+	captured, err := payment.CapturedAmount.Add(amount)
+	if err != nil {
+		return ProcessorResult{}, err
+	}
+	if captured.MinorUnits > payment.Amount.MinorUnits {
+		return ProcessorResult{}, fmt.Errorf("capture of %s exceeds the authorized amount", amount)
+	}
+	payment.CapturedAmount = captured
+	if captured.MinorUnits == payment.Amount.MinorUnits {
+		payment.Status = ProcessorCaptured
+	}
+	return *payment, nil
+}
+
+// Refund returns part or all of the captured amount.
+func (p *SimulatedProcessor) Refund(ctx context.Context, reference string, amount model.Money) (ProcessorResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment, ok := p.payments[reference]
+	if !ok {
+		return ProcessorResult{}, ErrProcessorPaymentNotFound
+	}
+	refunded, err := payment.RefundedAmount.Add(amount)
+	if err != nil {
+		return ProcessorResult{}, err
+	}
+	if refunded.MinorUnits > payment.CapturedAmount.MinorUnits {
+		return ProcessorResult{}, fmt.Errorf("refund of %s exceeds the captured amount", amount)
+	}
+	payment.RefundedAmount = refunded
+	if refunded.MinorUnits == payment.CapturedAmount.MinorUnits {
+		payment.Status = ProcessorRefunded
+	}
+	return *payment, nil
+}
+
+// Void releases the uncaptured part of an authorization. A partly captured
+// payment ends captured.
+func (p *SimulatedProcessor) Void(ctx context.Context, reference string) (ProcessorResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment, ok := p.payments[reference]
+	if !ok {
+		return ProcessorResult{}, ErrProcessorPaymentNotFound
+	}
+	switch {
+	case payment.Status != ProcessorAuthorized && payment.Status != ProcessorActionRequired:
+		return ProcessorResult{}, fmt.Errorf("cannot void a %s payment", payment.Status)
+	case payment.CapturedAmount.IsPositive():
+		payment.Status = ProcessorCaptured
+	default:
+		payment.Status = ProcessorVoided
+	}
+	return *payment, nil
+}
+
+// Status returns the payment made for a transaction.
+func (p *SimulatedProcessor) Status(ctx context.Context, transactionID string) (ProcessorResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reference, ok := p.byTxn[transactionID]
+	if !ok {
+		return ProcessorResult{}, ErrProcessorPaymentNotFound
+	}
+	return *p.payments[reference], nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"poc/model"
+	"testing"
+)
+
+func authorizeSimulated(t *testing.T, p *SimulatedProcessor, txnID string, amount model.Money) ProcessorResult {
+	t.Helper()
+	result, err := p.Authorize(context.Background(), ProcessorRequest{TransactionID: txnID, Amount: amount, MethodType: "card"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSimulatedProcessorOutcomeByAmount(t *testing.T) {
+	p := NewSimulatedProcessor()
+	ctx := context.Background()
+	tests := []struct {
+		minor   int64
+		status  string
+		timeout bool
+	}{
+		{1000, ProcessorAuthorized, false},
+		{1051, ProcessorDeclined, false},
+		{1053, ProcessorActionRequired, false},
+		{1052, ProcessorAuthorized, true}, // Approved, but the answer is lost
+	}
+	for i, tc := range tests {
+		txnID := string(rune('a' + i))
+		result, err := p.Authorize(ctx, ProcessorRequest{TransactionID: txnID, Amount: model.NewMoney(tc.minor, "INR")})
+		if tc.timeout {
+			if !errors.Is(err, ErrProcessorTimeout) {
+				t.Fatalf("%d: got %v, want ErrProcessorTimeout", tc.minor, err)
+			}
+			if result, err = p.Status(ctx, txnID); err != nil {
+				t.Fatal(err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != tc.status {
+			t.Errorf("%d: status = %s, want %s", tc.minor, result.Status, tc.status)
+		}
+	}
+}
+
+func TestSimulatedProcessorAuthorizeIsIdempotent(t *testing.T) {
+	p := NewSimulatedProcessor()
+	first := authorizeSimulated(t, p, "txn", model.NewMoney(1000, "INR"))
+	again := authorizeSimulated(t, p, "txn", model.NewMoney(1000, "INR"))
+	if again.Reference != first.Reference {
+		t.Errorf("second authorization got reference %s, want %s", again.Reference, first.Reference)
+	}
+}
+
+func TestSimulatedProcessorPartialCaptureThenVoid(t *testing.T) {
+	p := NewSimulatedProcessor()
+	ctx := context.Background()
+	auth := authorizeSimulated(t, p, "txn", model.NewMoney(1000, "INR"))
+
+	result, err := p.Capture(ctx, auth.Reference, model.NewMoney(400, "INR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != ProcessorAuthorized || result.CapturedAmount.MinorUnits != 400 {
+		t.Fatalf("after partial capture: %s with %s captured", result.Status, result.CapturedAmount)
+	}
+	if _, err := p.Capture(ctx, auth.Reference, model.NewMoney(601, "INR")); err == nil {
+		t.Fatal("captured more than was authorized")
+	}
+
+	// Voiding what is left of a partly captured payment leaves it captured
+	result, err = p.Void(ctx, auth.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != ProcessorCaptured || result.CapturedAmount.MinorUnits != 400 {
+		t.Errorf("after void: %s with %s captured", result.Status, result.CapturedAmount)
+	}
+}
+
+func TestSimulatedProcessorVoidTwice(t *testing.T) {
+	p := NewSimulatedProcessor()
+	ctx := context.Background()
+	auth := authorizeSimulated(t, p, "txn", model.NewMoney(1000, "INR"))
+
+	if _, err := p.Void(ctx, auth.Reference); err != nil {
+		t.Fatal(err)
+	}
+	// The processor refuses a second void; callers check Status to tell a
+	// retry from a real failure
+	if _, err := p.Void(ctx, auth.Reference); err == nil {
+		t.Fatal("voided a payment twice")
+	}
+	status, err := p.Status(ctx, "txn")
+	if err != nil || status.Status != ProcessorVoided {
+		t.Errorf("Status = %s, %v; want voided", status.Status, err)
+	}
+	if _, err := p.Void(ctx, "unknown"); !errors.Is(err, ErrProcessorPaymentNotFound) {
+		t.Errorf("void of unknown reference: got %v", err)
+	}
+}
+
+func TestSimulatedProcessorRefundLimits(t *testing.T) {
+	p := NewSimulatedProcessor()
+	ctx := context.Background()
+	auth := authorizeSimulated(t, p, "txn", model.NewMoney(1000, "INR"))
+	if _, err := p.Capture(ctx, auth.Reference, model.NewMoney(1000, "INR")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.Refund(ctx, auth.Reference, model.NewMoney(300, "INR"))
+	if err != nil || result.Status != ProcessorCaptured {
+		t.Fatalf("partial refund: %s, %v", result.Status, err)
+	}
+	if _, err := p.Refund(ctx, auth.Reference, model.NewMoney(701, "INR")); err == nil {
+		t.Fatal("refunded more than was captured")
+	}
+	result, err = p.Refund(ctx, auth.Reference, model.NewMoney(700, "INR"))
+	if err != nil || result.Status != ProcessorRefunded {
+		t.Errorf("final refund: %s, %v", result.Status, err)
+	}
+}
+
+func TestFundedByProcessor(t *testing.T) {
+	for methodType, want := range map[string]bool{
+		"card": true, "bank_transfer": true, "upi": true, "wallet": false, "cheque": false,
+	} {
+		if got := FundedByProcessor(methodType); got != want {
+			t.Errorf("FundedByProcessor(%q) = %v, want %v", methodType, got, want)
+		}
+	}
+}
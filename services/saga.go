@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultSagaStaleAfter is how long a saga may go without progress before
+// recovery treats it as interrupted. It is kept well above
+// DefaultProcessorTimeout so a step waiting on a slow processor is never
+// mistaken for one cut off by a restart.
+const DefaultSagaStaleAfter = 5 * time.Minute
+
+// SagaStep is one step of a saga. Steps run in order, each in its own
+// database transaction, and their outcomes are persisted so a saga can be
+// picked up again after a restart.
+type SagaStep struct {
+	Name   string
+	Action func(ctx context.Context) error
+	// Compensate undoes Action. It is also run for the step that failed and
+	// for a step interrupted by a restart, so it must look at the current
+	// state and do nothing when there is nothing to undo. Nil for steps
+	// without side effects.
+	Compensate func(ctx context.Context) error
+	// Pivot marks the point of no return: a saga interrupted after its pivot
+	// is finished on recovery rather than compensated.
+	Pivot bool
+	// Done reports whether an interrupted run of Action took effect. Only
+	// consulted for the pivot step.
+	Done func(ctx context.Context) (bool, error)
+}
+
+// SagaStore persists sagas and the log of their steps.
+type SagaStore interface {
+	// Create stores a new saga together with steps that already happened.
+	Create(ctx context.Context, saga *model.Saga, done []model.SagaStepLog) error
+	// Steps returns the step log of a saga.
+	Steps(ctx context.Context, sagaID string) ([]model.SagaStepLog, error)
+	// AddStep appends an entry to a saga's step log.
+	AddStep(ctx context.Context, entry *model.SagaStepLog) error
+	// Update saves the saga's status, current step, last error and update time.
+	Update(ctx context.Context, saga *model.Saga) error
+	// Stale returns running and compensating sagas last updated before cutoff,
+	// oldest first.
+	Stale(ctx context.Context, cutoff time.Time) ([]model.Saga, error)
+	// Claim moves the saga's update time to now if its status and update time
+	// are still as given, and reports whether it did.
+	Claim(ctx context.Context, saga *model.Saga, now time.Time) (bool, error)
+}
+
+// GormSagaStore is the SagaStore backed by the Sagas and SagaSteps tables.
+type GormSagaStore struct {
+	DB *gorm.DB
+}
+
+// NewGormSagaStore creates a new instance of GormSagaStore
+func NewGormSagaStore(db *gorm.DB) *GormSagaStore {
+	return &GormSagaStore{DB: db}
+}
+
+// Create stores the saga and its completed steps in one transaction.
+func (s *GormSagaStore) Create(ctx context.Context, saga *model.Saga, done []model.SagaStepLog) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(saga).Error; err != nil {
+			return err
+		}
+		for i := range done {
+			if err := tx.Create(&done[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Steps returns the step log of a saga.
+func (s *GormSagaStore) Steps(ctx context.Context, sagaID string) ([]model.SagaStepLog, error) {
+	var logs []model.SagaStepLog
+	err := s.DB.WithContext(ctx).Where("saga_id = ?", sagaID).Find(&logs).Error
+	return logs, err
+}
+
+// AddStep appends an entry to a saga's step log.
+func (s *GormSagaStore) AddStep(ctx context.Context, entry *model.SagaStepLog) error {
+	return s.DB.WithContext(ctx).Create(entry).Error
+}
+
+// Update saves the saga's progress fields.
+func (s *GormSagaStore) Update(ctx context.Context, saga *model.Saga) error {
+	return s.DB.WithContext(ctx).Model(saga).Updates(map[string]interface{}{
+		"status":       saga.Status,
+		"current_step": saga.CurrentStep,
+		"last_error":   saga.LastError,
+		"updated_at":   saga.UpdatedAt,
+	}).Error
+}
+
+// Stale returns running and compensating sagas last updated before cutoff.
+func (s *GormSagaStore) Stale(ctx context.Context, cutoff time.Time) ([]model.Saga, error) {
+	var sagas []model.Saga
+	err := s.DB.WithContext(ctx).
+		Where("status IN ? AND updated_at < ?", []string{model.SagaRunning, model.SagaCompensating}, cutoff).
+		Order("created_at").Find(&sagas).Error
+	return sagas, err
+}
+
+// Claim is a compare-and-set on the saga's status and update time.
+func (s *GormSagaStore) Claim(ctx context.Context, saga *model.Saga, now time.Time) (bool, error) {
+	result := s.DB.WithContext(ctx).Model(&model.Saga{}).
+		Where("saga_id = ? AND status = ? AND updated_at = ?", saga.SagaID, saga.Status, saga.UpdatedAt).
+		Update("updated_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SagaOrchestrator runs sagas and persists their progress.
+type SagaOrchestrator struct {
+	Store SagaStore
+}
+
+// NewSagaOrchestrator creates a new instance of SagaOrchestrator
+func NewSagaOrchestrator(db *gorm.DB) *SagaOrchestrator {
+	return &SagaOrchestrator{Store: NewGormSagaStore(db)}
+}
+
+// Start records a new running saga. done names steps that already happened
+// outside the saga; they are recorded as completed so Run skips them but
+// compensation still undoes them.
+func (o *SagaOrchestrator) Start(ctx context.Context, kind, transactionID string, done ...string) (*model.Saga, error) {
+	saga := &model.Saga{
+		SagaID:        utils.GenerateUniqueID(),
+		Kind:          kind,
+		TransactionID: transactionID,
+		Status:        model.SagaRunning,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if len(done) > 0 {
+		saga.CurrentStep = done[len(done)-1]
+	}
+	logs := make([]model.SagaStepLog, 0, len(done))
+	for _, step := range done {
+		logs = append(logs, model.SagaStepLog{
+			SagaStepID: utils.GenerateUniqueID(),
+			SagaID:     saga.SagaID,
+			Step:       step,
+			Status:     model.SagaStepCompleted,
+			CreatedAt:  time.Now(),
+		})
+	}
+	if err := o.Store.Create(ctx, saga, logs); err != nil {
+		return nil, fmt.Errorf("failed to start saga: %v", err)
+	}
+	return saga, nil
+}
+
+// Run executes the steps the saga has not completed yet. When a step fails,
+// it and every step before it are compensated in reverse order and the
+// step's error is returned.
+func (o *SagaOrchestrator) Run(ctx context.Context, saga *model.Saga, steps []SagaStep) error {
+	completed, _, err := o.stepOutcomes(ctx, saga)
+	if err != nil {
+		return err
+	}
+
+	for i, step := range steps {
+		if completed[step.Name] {
+			continue
+		}
+		if err := o.update(ctx, saga, model.SagaRunning, step.Name, ""); err != nil {
+			return err
+		}
+		if err := step.Action(ctx); err != nil {
+			o.recordStep(ctx, saga, step.Name, model.SagaStepFailed, err.Error())
+			if compErr := o.compensate(ctx, saga, steps[:i+1], err.Error()); compErr != nil {
+				return fmt.Errorf("%w (compensation incomplete: %v)", err, compErr)
+			}
+			return err
+		}
+		if err := o.recordStep(ctx, saga, step.Name, model.SagaStepCompleted, ""); err != nil {
+			return err
+		}
+	}
+	return o.update(ctx, saga, model.SagaCompleted, saga.CurrentStep, "")
+}
+
+// Resume picks up a saga that stopped part way, typically because the
+// process exited. A saga past its pivot is run to the end; any other is
+// compensated. It reports whether the saga ended compensated.
+func (o *SagaOrchestrator) Resume(ctx context.Context, saga *model.Saga, steps []SagaStep) (bool, error) {
+	completed, _, err := o.stepOutcomes(ctx, saga)
+	if err != nil {
+		return false, err
+	}
+	started := -1
+	for i, step := range steps {
+		if step.Name == saga.CurrentStep {
+			started = i
+		}
+	}
+
+	if saga.Status == model.SagaRunning {
+		pivotDone := false
+		for i, step := range steps {
+			if !step.Pivot {
+				continue
+			}
+			pivotDone = completed[step.Name]
+			if !pivotDone && i == started && step.Done != nil {
+				if pivotDone, err = step.Done(ctx); err != nil {
+					return false, err
+				}
+				if pivotDone {
+					if err := o.recordStep(ctx, saga, step.Name, model.SagaStepCompleted, ""); err != nil {
+						return false, err
+					}
+				}
+			}
+		}
+		if pivotDone {
+			return false, o.Run(ctx, saga, steps)
+		}
+	}
+
+	reason := saga.LastError
+	if reason == "" {
+		reason = "interrupted before completion"
+	}
+	if err := o.compensate(ctx, saga, steps[:started+1], reason); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Stale returns running and compensating sagas that have made no progress
+// for longer than olderThan.
+func (o *SagaOrchestrator) Stale(ctx context.Context, olderThan time.Duration) ([]model.Saga, error) {
+	sagas, err := o.Store.Stale(ctx, time.Now().Add(-olderThan))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch interrupted sagas: %v", err)
+	}
+	return sagas, nil
+}
+
+// Claim takes a stale saga over for recovery. The update only succeeds if
+// the saga is unchanged since Stale read it, so of several instances
+// recovering at once exactly one gets it, and a saga that made progress in
+// the meantime is left to whoever is running it.
+func (o *SagaOrchestrator) Claim(ctx context.Context, saga *model.Saga) (bool, error) {
+	now := time.Now()
+	claimed, err := o.Store.Claim(ctx, saga, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim saga: %v", err)
+	}
+	if !claimed {
+		return false, nil
+	}
+	saga.UpdatedAt = now
+	return true, nil
+}
+
+// compensate undoes steps in reverse order, skipping those already
+// compensated. A failed compensation leaves the saga compensating so
+// recovery retries it.
+func (o *SagaOrchestrator) compensate(ctx context.Context, saga *model.Saga, steps []SagaStep, reason string) error {
+	if err := o.update(ctx, saga, model.SagaCompensating, saga.CurrentStep, reason); err != nil {
+		return err
+	}
+	_, compensated, err := o.stepOutcomes(ctx, saga)
+	if err != nil {
+		return err
+	}
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.Compensate == nil || compensated[step.Name] {
+			continue
+		}
+		if err := step.Compensate(ctx); err != nil {
+			_ = o.update(ctx, saga, model.SagaCompensating, saga.CurrentStep, fmt.Sprintf("compensating %s: %v", step.Name, err))
+			return fmt.Errorf("failed to compensate %s: %v", step.Name, err)
+		}
+		if err := o.recordStep(ctx, saga, step.Name, model.SagaStepCompensated, ""); err != nil {
+			return err
+		}
+	}
+	return o.update(ctx, saga, model.SagaCompensated, saga.CurrentStep, reason)
+}
+
+// stepOutcomes returns which steps have completed and which were compensated.
+func (o *SagaOrchestrator) stepOutcomes(ctx context.Context, saga *model.Saga) (completed, compensated map[string]bool, err error) {
+	logs, err := o.Store.Steps(ctx, saga.SagaID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch saga steps: %v", err)
+	}
+	completed, compensated = make(map[string]bool), make(map[string]bool)
+	for _, entry := range logs {
+		switch entry.Status {
+		case model.SagaStepCompleted:
+			completed[entry.Step] = true
+		case model.SagaStepCompensated:
+			compensated[entry.Step] = true
+		}
+	}
+	return completed, compensated, nil
+}
+
+func (o *SagaOrchestrator) recordStep(ctx context.Context, saga *model.Saga, step, status, message string) error {
+	entry := model.SagaStepLog{
+		SagaStepID: utils.GenerateUniqueID(),
+		SagaID:     saga.SagaID,
+		Step:       step,
+		Status:     status,
+		Error:      truncate(message, 1024),
+		CreatedAt:  time.Now(),
+	}
+	if err := o.Store.AddStep(ctx, &entry); err != nil {
+		return fmt.Errorf("failed to record saga step: %v", err)
+	}
+	return nil
+}
+
+func (o *SagaOrchestrator) update(ctx context.Context, saga *model.Saga, status, step, message string) error {
+	saga.Status, saga.CurrentStep, saga.LastError, saga.UpdatedAt = status, step, truncate(message, 1024), time.Now()
+	if err := o.Store.Update(ctx, saga); err != nil {
+		return fmt.Errorf("failed to update saga: %v", err)
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
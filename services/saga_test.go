@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"poc/model"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySagaStore is a SagaStore kept in memory for tests.
+type memorySagaStore struct {
+	mu    sync.Mutex
+	sagas map[string]model.Saga
+	steps map[string][]model.SagaStepLog
+}
+
+func newMemorySagaStore() *memorySagaStore {
+	return &memorySagaStore{sagas: map[string]model.Saga{}, steps: map[string][]model.SagaStepLog{}}
+}
+
+func (s *memorySagaStore) Create(ctx context.Context, saga *model.Saga, done []model.SagaStepLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sagas[saga.SagaID] = *saga
+	s.steps[saga.SagaID] = append([]model.SagaStepLog(nil), done...)
+	return nil
+}
+
+func (s *memorySagaStore) Steps(ctx context.Context, sagaID string) ([]model.SagaStepLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.SagaStepLog(nil), s.steps[sagaID]...), nil
+}
+
+func (s *memorySagaStore) AddStep(ctx context.Context, entry *model.SagaStepLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.steps[entry.SagaID] = append(s.steps[entry.SagaID], *entry)
+	return nil
+}
+
+func (s *memorySagaStore) Update(ctx context.Context, saga *model.Saga) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sagas[saga.SagaID] = *saga
+	return nil
+}
+
+func (s *memorySagaStore) Stale(ctx context.Context, cutoff time.Time) ([]model.Saga, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stale []model.Saga
+	for _, saga := range s.sagas {
+		if (saga.Status == model.SagaRunning || saga.Status == model.SagaCompensating) && saga.UpdatedAt.Before(cutoff) {
+			stale = append(stale, saga)
+		}
+	}
+	return stale, nil
+}
+
+func (s *memorySagaStore) Claim(ctx context.Context, saga *model.Saga, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.sagas[saga.SagaID]
+	if !ok || stored.Status != saga.Status || !stored.UpdatedAt.Equal(saga.UpdatedAt) {
+		return false, nil
+	}
+	stored.UpdatedAt = now
+	s.sagas[saga.SagaID] = stored
+	return true, nil
+}
+
+// stepRecorder builds fake steps that log what ran, in order.
+type stepRecorder struct {
+	calls []string
+}
+
+func (r *stepRecorder) step(name string, fail error) SagaStep {
+	return SagaStep{
+		Name: name,
+		Action: func(ctx context.Context) error {
+			r.calls = append(r.calls, "do "+name)
+			return fail
+		},
+		Compensate: func(ctx context.Context) error {
+			r.calls = append(r.calls, "undo "+name)
+			return nil
+		},
+	}
+}
+
+func newTestSaga(t *testing.T, done ...string) (*SagaOrchestrator, *memorySagaStore, *model.Saga) {
+	t.Helper()
+	store := newMemorySagaStore()
+	orchestrator := &SagaOrchestrator{Store: store}
+	saga, err := orchestrator.Start(context.Background(), model.SagaKindPayment, "txn", done...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orchestrator, store, saga
+}
+
+func stepStatuses(store *memorySagaStore, saga *model.Saga) []string {
+	var statuses []string
+	for _, entry := range store.steps[saga.SagaID] {
+		statuses = append(statuses, entry.Step+":"+entry.Status)
+	}
+	return statuses
+}
+
+func TestSagaRunCompletesEveryStep(t *testing.T) {
+	orchestrator, store, saga := newTestSaga(t)
+	r := &stepRecorder{}
+	steps := []SagaStep{r.step("a", nil), r.step("b", nil), r.step("c", nil)}
+
+	if err := orchestrator.Run(context.Background(), saga, steps); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"do a", "do b", "do c"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	if got := store.sagas[saga.SagaID].Status; got != model.SagaCompleted {
+		t.Errorf("status = %s, want %s", got, model.SagaCompleted)
+	}
+}
+
+func TestSagaRunCompensatesInReverseOnFailure(t *testing.T) {
+	orchestrator, store, saga := newTestSaga(t)
+	r := &stepRecorder{}
+	failure := errors.New("declined")
+	steps := []SagaStep{r.step("a", nil), r.step("b", nil), r.step("c", failure), r.step("d", nil)}
+
+	err := orchestrator.Run(context.Background(), saga, steps)
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v, want %v", err, failure)
+	}
+	// The failed step is compensated too; the step after it never runs
+	want := []string{"do a", "do b", "do c", "undo c", "undo b", "undo a"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	stored := store.sagas[saga.SagaID]
+	if stored.Status != model.SagaCompensated || stored.LastError != "declined" {
+		t.Errorf("saga = %s (%q), want compensated (declined)", stored.Status, stored.LastError)
+	}
+}
+
+func TestSagaRunSkipsStepsDoneBeforeStart(t *testing.T) {
+	orchestrator, _, saga := newTestSaga(t, "a")
+	r := &stepRecorder{}
+	failure := errors.New("declined")
+	steps := []SagaStep{r.step("a", nil), r.step("b", failure)}
+
+	if err := orchestrator.Run(context.Background(), saga, steps); !errors.Is(err, failure) {
+		t.Fatalf("got %v, want %v", err, failure)
+	}
+	// a happened outside the saga, so it is skipped but still undone
+	if want := []string{"do b", "undo b", "undo a"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+}
+
+func TestSagaCompensationFailureLeavesSagaCompensating(t *testing.T) {
+	orchestrator, store, saga := newTestSaga(t)
+	r := &stepRecorder{}
+	a := r.step("a", nil)
+	stuck := errors.New("ledger unavailable")
+	a.Compensate = func(ctx context.Context) error { return stuck }
+	failure := errors.New("declined")
+	steps := []SagaStep{a, r.step("b", failure)}
+
+	err := orchestrator.Run(context.Background(), saga, steps)
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v, want %v", err, failure)
+	}
+	stored := store.sagas[saga.SagaID]
+	if stored.Status != model.SagaCompensating {
+		t.Fatalf("status = %s, want %s", stored.Status, model.SagaCompensating)
+	}
+
+	// Resuming retries only what is left to undo
+	a.Compensate = func(ctx context.Context) error {
+		r.calls = append(r.calls, "undo a")
+		return nil
+	}
+	r.calls = nil
+	compensated, err := orchestrator.Resume(context.Background(), &stored, []SagaStep{a, r.step("b", failure)})
+	if err != nil || !compensated {
+		t.Fatalf("Resume = %v, %v; want compensated", compensated, err)
+	}
+	if want := []string{"undo a"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+}
+
+// interrupt runs steps up to and including the named one's Action, as if the
+// process died right after, and returns the saga as recovery would load it.
+func interrupt(t *testing.T, orchestrator *SagaOrchestrator, store *memorySagaStore, saga *model.Saga, steps []SagaStep, at string) model.Saga {
+	t.Helper()
+	ctx := context.Background()
+	for _, step := range steps {
+		if err := orchestrator.update(ctx, saga, model.SagaRunning, step.Name, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := step.Action(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if step.Name == at {
+			break
+		}
+		if err := orchestrator.recordStep(ctx, saga, step.Name, model.SagaStepCompleted, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store.sagas[saga.SagaID]
+}
+
+func TestSagaResumeBeforePivotCompensates(t *testing.T) {
+	orchestrator, store, saga := newTestSaga(t)
+	r := &stepRecorder{}
+	pivot := r.step("pay", nil)
+	pivot.Pivot = true
+	pivot.Done = func(ctx context.Context) (bool, error) { return false, nil }
+	steps := []SagaStep{r.step("reserve", nil), pivot, r.step("complete", nil)}
+
+	stored := interrupt(t, orchestrator, store, saga, steps, "pay")
+	r.calls = nil
+	compensated, err := orchestrator.Resume(context.Background(), &stored, steps)
+	if err != nil || !compensated {
+		t.Fatalf("Resume = %v, %v; want compensated", compensated, err)
+	}
+	// The interrupted step is compensated as well, in case it took effect
+	if want := []string{"undo pay", "undo reserve"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	if got := store.sagas[saga.SagaID].LastError; got != "interrupted before completion" {
+		t.Errorf("LastError = %q", got)
+	}
+}
+
+func TestSagaResumeAfterPivotFinishes(t *testing.T) {
+	orchestrator, store, saga := newTestSaga(t)
+	r := &stepRecorder{}
+	pivot := r.step("pay", nil)
+	pivot.Pivot = true
+	steps := []SagaStep{r.step("reserve", nil), pivot, r.step("complete", nil), r.step("notify", nil)}
+
+	stored := interrupt(t, orchestrator, store, saga, steps, "complete")
+	r.calls = nil
+	compensated, err := orchestrator.Resume(context.Background(), &stored, steps)
+	if err != nil || compensated {
+		t.Fatalf("Resume = %v, %v; want finished", compensated, err)
+	}
+	if want := []string{"do complete", "do notify"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	if got := store.sagas[saga.SagaID].Status; got != model.SagaCompleted {
+		t.Errorf("status = %s, want %s", got, model.SagaCompleted)
+	}
+}
+
+func TestSagaResumeAtPivotAsksWhetherItTookEffect(t *testing.T) {
+	orchestrator, store, saga := newTestSaga(t)
+	r := &stepRecorder{}
+	pivot := r.step("pay", nil)
+	pivot.Pivot = true
+	pivot.Done = func(ctx context.Context) (bool, error) { return true, nil }
+	steps := []SagaStep{r.step("reserve", nil), pivot, r.step("complete", nil)}
+
+	stored := interrupt(t, orchestrator, store, saga, steps, "pay")
+	r.calls = nil
+	compensated, err := orchestrator.Resume(context.Background(), &stored, steps)
+	if err != nil || compensated {
+		t.Fatalf("Resume = %v, %v; want finished", compensated, err)
+	}
+	// The pivot went through, so it is not repeated
+	if want := []string{"do complete"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	want := []string{"reserve:completed", "pay:completed", "complete:completed"}
+	if got := stepStatuses(store, saga); !reflect.DeepEqual(got, want) {
+		t.Errorf("step log = %v, want %v", got, want)
+	}
+}
+
+func TestSagaClaimOnlyOnce(t *testing.T) {
+	orchestrator, store, saga := newTestSaga(t)
+	stored := store.sagas[saga.SagaID]
+	first, second := stored, stored
+
+	claimed, err := orchestrator.Claim(context.Background(), &first)
+	if err != nil || !claimed {
+		t.Fatalf("first Claim = %v, %v", claimed, err)
+	}
+	claimed, err = orchestrator.Claim(context.Background(), &second)
+	if err != nil || claimed {
+		t.Fatalf("second Claim = %v, %v; want false", claimed, err)
+	}
+}
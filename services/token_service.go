@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultAccessTokenTTL keeps access tokens short-lived; clients refresh them.
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL is how long a session can go without refreshing.
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens.
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// TokenPair is what a client receives on login and refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// TokenService issues access and refresh tokens and keeps the server-side
+// revocation list that AuthMiddleware checks.
+type TokenService struct {
+	DB         *gorm.DB
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// NewTokenService creates a new instance of TokenService
+func NewTokenService(db *gorm.DB) *TokenService {
+	return &TokenService{DB: db, AccessTTL: DefaultAccessTokenTTL, RefreshTTL: DefaultRefreshTokenTTL}
+}
+
+// IssueTokens starts a new session for the user.
+func (s *TokenService) IssueTokens(ctx context.Context, userID string) (*TokenPair, error) {
+	var pair *TokenPair
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		pair, _, err = s.issue(tx, userID, utils.GenerateUniqueID())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Refresh rotates a refresh token: the presented token and the access token
+// issued with it are revoked and a new pair is returned for the same session.
+// Presenting an already rotated token means it leaked, so the whole session
+// is revoked.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	var pair *TokenPair
+	var reused *model.RefreshToken
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current model.RefreshToken
+		if err := tx.First(&current, "refresh_token_id = ?", hashOpaqueToken(refreshToken)).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return fmt.Errorf("failed to fetch refresh token: %v", err)
+		}
+		if current.RevokedAt != nil {
+			if current.ReplacedBy != "" {
+				reused = &current
+			}
+			return ErrInvalidRefreshToken
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		var next *model.RefreshToken
+		var err error
+		pair, next, err = s.issue(tx, current.UserID, current.SessionID)
+		if err != nil {
+			return err
+		}
+		return s.revokeRefreshToken(tx, &current, next.RefreshTokenID)
+	})
+	if reused != nil {
+		// Runs outside the failed transaction so the revocation sticks
+		if err := s.RevokeSession(ctx, reused.UserID, reused.SessionID); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// RevokeSession logs out one session: its refresh tokens stop working and its
+// current access token is added to the revocation list.
+func (s *TokenService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return s.revokeSessions(ctx, userID, sessionID)
+}
+
+// RevokeAllSessions logs the user out everywhere.
+func (s *TokenService) RevokeAllSessions(ctx context.Context, userID string) error {
+	return s.revokeSessions(ctx, userID, "")
+}
+
+// RevokeAccessToken adds a single access token to the revocation list.
+func (s *TokenService) RevokeAccessToken(ctx context.Context, userID, tokenID string, expiresAt time.Time) error {
+	return s.revokeAccessToken(s.DB.WithContext(ctx), userID, tokenID, expiresAt)
+}
+
+// IsRevoked reports whether the access token with the given jti was revoked.
+func (s *TokenService) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var count int64
+	if err := s.DB.WithContext(ctx).Model(&model.RevokedToken{}).Where("token_id = ?", tokenID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %v", err)
+	}
+	return count > 0, nil
+}
+
+// PurgeExpired drops revocation entries and refresh tokens that have expired
+// and can no longer be presented. It returns how many rows were removed.
+func (s *TokenService) PurgeExpired(ctx context.Context) (int64, error) {
+	now := time.Now()
+	revoked := s.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.RevokedToken{})
+	if revoked.Error != nil {
+		return 0, fmt.Errorf("failed to purge revoked tokens: %v", revoked.Error)
+	}
+	refresh := s.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.RefreshToken{})
+	if refresh.Error != nil {
+		return revoked.RowsAffected, fmt.Errorf("failed to purge refresh tokens: %v", refresh.Error)
+	}
+	return revoked.RowsAffected + refresh.RowsAffected, nil
+}
+
+// issue creates an access token and a refresh token for the session inside tx.
+// The role is read afresh, so role changes apply from the next refresh.
+func (s *TokenService) issue(tx *gorm.DB, userID, sessionID string) (*TokenPair, *model.RefreshToken, error) {
+	var user model.User
+	if err := tx.Select("UserID", "Role").First(&user, "UserID = ?", userID).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch user: %v", err)
+	}
+	role := user.Role
+	if role == "" {
+		role = model.RoleCustomer
+	}
+
+	accessToken, claims, err := utils.GenerateToken(userID, sessionID, role, s.AccessTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+
+	record := &model.RefreshToken{
+		RefreshTokenID: hashOpaqueToken(refreshToken),
+		UserID:         userID,
+		SessionID:      sessionID,
+		AccessTokenID:  claims.ID,
+		ExpiresAt:      time.Now().Add(s.RefreshTTL),
+		CreatedAt:      time.Now(),
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.AccessTTL / time.Second),
+	}, record, nil
+}
+
+// revokeSessions revokes the live refresh tokens of one session, or of every
+// session when sessionID is empty, together with their access tokens.
+func (s *TokenService) revokeSessions(ctx context.Context, userID, sessionID string) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("user_id = ? AND revoked_at IS NULL", userID)
+		if sessionID != "" {
+			query = query.Where("session_id = ?", sessionID)
+		}
+		var live []model.RefreshToken
+		if err := query.Find(&live).Error; err != nil {
+			return fmt.Errorf("failed to fetch sessions: %v", err)
+		}
+		for i := range live {
+			if err := s.revokeRefreshToken(tx, &live[i], ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// revokeRefreshToken marks a refresh token as used or logged out and puts its
+// access token on the revocation list.
+func (s *TokenService) revokeRefreshToken(tx *gorm.DB, token *model.RefreshToken, replacedBy string) error {
+	now := time.Now()
+	token.RevokedAt = &now
+	token.ReplacedBy = replacedBy
+	if err := tx.Save(token).Error; err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
+	if token.AccessTokenID == "" {
+		return nil
+	}
+	return s.revokeAccessToken(tx, token.UserID, token.AccessTokenID, token.CreatedAt.Add(s.AccessTTL))
+}
+
+func (s *TokenService) revokeAccessToken(tx *gorm.DB, userID, tokenID string, expiresAt time.Time) error {
+	var existing int64
+	if err := tx.Model(&model.RevokedToken{}).Where("token_id = ?", tokenID).Count(&existing).Error; err != nil {
+		return fmt.Errorf("failed to check token revocation: %v", err)
+	}
+	if existing > 0 {
+		return nil
+	}
+	entry := model.RevokedToken{TokenID: tokenID, UserID: userID, ExpiresAt: expiresAt, RevokedAt: time.Now()}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to revoke access token: %v", err)
+	}
+	return nil
+}
+
+// newOpaqueToken returns a random URL-safe token with 256 bits of entropy.
+func newOpaqueToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashOpaqueToken is how opaque tokens are stored, so a database leak does not
+// hand out usable tokens.
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
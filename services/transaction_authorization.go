@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultAuthorizationTTL is how long an uncaptured hold lasts unless configured otherwise.
+const DefaultAuthorizationTTL = 7 * 24 * time.Hour
+
+// AuthorizeTransaction places a hold for the full amount without paying the
+// payee: at the processor for processor-funded methods, on the payer's balance
+// for wallets. The hold is captured later with CaptureAuthorization, released
+// with VoidAuthorization, or released automatically once it expires.
+func (svc *TransactionService) AuthorizeTransaction(ctx context.Context, payerID, payeeID string, amount model.Money, paymentMethodID string, paymentDetail model.PaymentDetails, idempotencyKey string) (*model.Transaction, error) {
+	transaction, _, err := svc.buildTransaction(ctx, payerID, payeeID, amount, "Debit", model.Money{}, paymentMethodID, paymentDetail, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
+	transaction.CapturedAmount = model.ZeroMoney(transaction.Amount.Currency)
+
+	if err := svc.createTransaction(svc.DB, transaction, model.StatusPending, "Authorization requested"); err != nil {
+		return nil, fmt.Errorf("failed to create transaction: %v", err)
+	}
+	funded, err := svc.fundedByProcessor(svc.DB.WithContext(ctx), transaction)
+	if err != nil {
+		_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, model.StatusFailed, "Authorization failed")
+		return nil, err
+	}
+	if funded {
+		if err := svc.authorizeWithProcessor(ctx, transaction); err != nil {
+			_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, model.StatusFailed, "Authorization refused by processor")
+			svc.logAudit(transaction.TransactionID, "Authorization Failed", err.Error())
+			return nil, err
+		}
+	}
+
+	expiresAt := time.Now().Add(svc.AuthorizationTTL)
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		// Wallet payments move the amount into the payer's hold account
+		if !funded {
+			if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryReserve, "Authorization hold",
+				model.PayerAccount(payerID), model.PayerHoldAccount(payerID), transaction.Amount); err != nil {
+				return err
+			}
+		}
+
+		if err := transitionStatus(tx, transaction, model.StatusAuthorized, "Authorization hold placed"); err != nil {
+			return err
+		}
+		transaction.ReservedAmount = transaction.Amount
+		transaction.AuthorizationExpiresAt = &expiresAt
+		return tx.Save(transaction).Error
+	})
+	if err != nil {
+		if voidErr := svc.voidWithProcessor(ctx, transaction); voidErr != nil {
+			log.Printf("Failed to void payment for transaction %s: %v", transaction.TransactionID, voidErr)
+		}
+		_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, model.StatusFailed, "Authorization failed")
+		svc.logAudit(transaction.TransactionID, "Authorization Failed", err.Error())
+		if errors.Is(err, ErrInsufficientFunds) {
+			return nil, errors.New("insufficient funds")
+		}
+		return nil, fmt.Errorf("failed to authorize transaction: %v", err)
+	}
+
+	svc.logAudit(transaction.TransactionID, "Authorization Created", fmt.Sprintf("Hold of %s placed until %s", transaction.Amount, expiresAt.Format(time.RFC3339)))
+	return transaction, nil
+}
+
+// CaptureAuthorization pays the payee out of an authorization hold. A nil
+// amount captures everything still held; smaller amounts may be captured
+// several times until the hold is used up. Only the payee can capture.
+//
+// The capture is first set aside on the transaction and committed, then sent
+// to the processor, and only paid to the payee once the processor confirms.
+// If the processor refuses, the amount goes back into the hold; if the
+// outcome is unknown, recovery settles it later from the processor's view.
+func (svc *TransactionService) CaptureAuthorization(ctx context.Context, transactionID, userID string, amount *model.Money) (*model.Transaction, error) {
+	var transaction model.Transaction
+	var capture model.Money
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := svc.loadAuthorization(tx, &transaction, transactionID, userID); err != nil {
+			return err
+		}
+		if transaction.PayeeID != userID {
+			return errors.New("only the payee can capture an authorization")
+		}
+		if transaction.AuthorizationExpiresAt != nil && time.Now().After(*transaction.AuthorizationExpiresAt) {
+			return errors.New("authorization has expired")
+		}
+		if transaction.CapturingAmount.IsPositive() {
+			return errors.New("a capture is already in progress")
+		}
+
+		var err error
+		if capture, err = captureAmount(&transaction, amount); err != nil {
+			return err
+		}
+
+		// Set the capture aside so voids, expiry and other captures leave it alone
+		if transaction.ReservedAmount, err = transaction.ReservedAmount.Sub(capture); err != nil {
+			return err
+		}
+		transaction.CapturingAmount = capture
+		return tx.Save(&transaction).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := svc.captureWithProcessor(ctx, &transaction, capture); err != nil {
+		if errors.Is(err, ErrProcessorTimeout) {
+			return nil, fmt.Errorf("capture of %s is waiting for the processor to confirm: %w", capture, err)
+		}
+		if releaseErr := svc.abandonCapture(ctx, transaction.TransactionID, err.Error()); releaseErr != nil {
+			log.Printf("Failed to return refused capture to the hold of transaction %s: %v", transaction.TransactionID, releaseErr)
+		}
+		return nil, err
+	}
+	if err := svc.finishCapture(ctx, &transaction); err != nil {
+		return nil, fmt.Errorf("capture of %s was collected but not yet recorded: %v", capture, err)
+	}
+
+	svc.logAudit(transaction.TransactionID, "Authorization Captured", fmt.Sprintf("Captured %s, %s still held", transaction.CapturedAmount, transaction.ReservedAmount))
+	return &transaction, nil
+}
+
+// captureAmount is what a capture request takes from the authorization: the
+// requested amount, or everything still held when amount is nil.
+func captureAmount(transaction *model.Transaction, amount *model.Money) (model.Money, error) {
+	capture := transaction.ReservedAmount
+	if amount != nil {
+		capture = *amount
+		if capture.Currency == "" {
+			capture.Currency = transaction.Amount.Currency
+		}
+	}
+	if !capture.IsPositive() {
+		return model.Money{}, errors.New("capture amount must be greater than zero")
+	}
+	exceeds, err := transaction.ReservedAmount.LessThan(capture)
+	if err != nil {
+		return model.Money{}, err
+	}
+	if exceeds {
+		return model.Money{}, fmt.Errorf("capture amount %s exceeds remaining authorized amount %s", capture, transaction.ReservedAmount)
+	}
+	return capture, nil
+}
+
+// finishCapture pays a capture the processor has collected to the payee, out
+// of the settlement account, or out of the payer's hold for wallet payments.
+func (svc *TransactionService) finishCapture(ctx context.Context, transaction *model.Transaction) error {
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(transaction, "transaction_id = ?", transaction.TransactionID).Error; err != nil {
+			return err
+		}
+		capture := transaction.CapturingAmount
+		if !capture.IsPositive() {
+			return errors.New("no capture in progress")
+		}
+		funded, err := svc.fundedByProcessor(tx, transaction)
+		if err != nil {
+			return err
+		}
+		from := model.PayerHoldAccount(transaction.PayerID)
+		if funded {
+			from = model.SystemAccount(model.LedgerSystemSettlement)
+		}
+		if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryPayment, "Authorization capture",
+			from, model.PayeeAccount(transaction.PayeeID), capture); err != nil {
+			return err
+		}
+
+		if transaction.CapturedAmount, err = transaction.CapturedAmount.Add(capture); err != nil {
+			return err
+		}
+		transaction.CapturingAmount = model.ZeroMoney(transaction.Amount.Currency)
+		next := model.StatusPartiallyCaptured
+		if transaction.ReservedAmount.IsZero() {
+			next = model.StatusCompleted
+		}
+		if err := transitionStatus(tx, transaction, next, fmt.Sprintf("Captured %s", capture)); err != nil {
+			return err
+		}
+		return tx.Save(transaction).Error
+	})
+}
+
+// abandonCapture returns a capture the processor did not collect to the hold.
+func (svc *TransactionService) abandonCapture(ctx context.Context, transactionID, reason string) error {
+	var transaction model.Transaction
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&transaction, "transaction_id = ?", transactionID).Error; err != nil {
+			return err
+		}
+		if !transaction.CapturingAmount.IsPositive() {
+			return nil
+		}
+		var err error
+		if transaction.ReservedAmount, err = transaction.ReservedAmount.Add(transaction.CapturingAmount); err != nil {
+			return err
+		}
+		transaction.CapturingAmount = model.ZeroMoney(transaction.Amount.Currency)
+		return tx.Save(&transaction).Error
+	})
+	if err != nil {
+		return err
+	}
+	svc.logAudit(transactionID, "Authorization Capture Failed", reason)
+	return nil
+}
+
+// VoidAuthorization releases whatever is still held back to the payer. Either
+// party can void.
+func (svc *TransactionService) VoidAuthorization(ctx context.Context, transactionID, userID string) (*model.Transaction, error) {
+	var transaction model.Transaction
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := svc.loadAuthorization(tx, &transaction, transactionID, userID); err != nil {
+			return err
+		}
+		return svc.releaseAuthorization(tx, &transaction, model.StatusVoided, "Authorization voided")
+	})
+	if err != nil {
+		return nil, err
+	}
+	svc.voidReleasedAuthorization(ctx, &transaction)
+
+	svc.logAudit(transaction.TransactionID, "Authorization Voided", fmt.Sprintf("Hold released, %s captured", transaction.CapturedAmount))
+	return &transaction, nil
+}
+
+// ExpireAuthorizations releases every hold whose expiry has passed and
+// returns how many were released.
+func (svc *TransactionService) ExpireAuthorizations(ctx context.Context) (int, error) {
+	var expired []model.Transaction
+	if err := svc.DB.WithContext(ctx).
+		Where("status IN ? AND authorization_expires_at <= ?", []model.TransactionStatus{model.StatusAuthorized, model.StatusPartiallyCaptured}, time.Now()).
+		Find(&expired).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch expired authorizations: %v", err)
+	}
+
+	released := 0
+	for _, candidate := range expired {
+		var transaction model.Transaction
+		didRelease := false
+		err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// Re-read inside the transaction in case it was captured or voided meanwhile
+			if err := tx.First(&transaction, "transaction_id = ?", candidate.TransactionID).Error; err != nil {
+				return err
+			}
+			// A capture in flight is settled first; the hold expires on a later run
+			if !isOpenAuthorization(&transaction) || transaction.CapturingAmount.IsPositive() {
+				return nil
+			}
+			didRelease = true
+			return svc.releaseAuthorization(tx, &transaction, model.StatusExpired, "Authorization expired")
+		})
+		if err != nil {
+			// One bad authorization must not hold up the rest
+			log.Printf("Failed to expire authorization %s: %v", candidate.TransactionID, err)
+			continue
+		}
+		if didRelease {
+			svc.voidReleasedAuthorization(ctx, &transaction)
+			released++
+			svc.logAudit(transaction.TransactionID, "Authorization Expired", "Hold released after expiry")
+		}
+	}
+	return released, nil
+}
+
+// loadAuthorization fetches an open authorization the user takes part in.
+func (svc *TransactionService) loadAuthorization(tx *gorm.DB, transaction *model.Transaction, transactionID, userID string) error {
+	if err := tx.First(transaction, "transaction_id = ?", transactionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("transaction not found")
+		}
+		return fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+	if transaction.PayerID != userID && transaction.PayeeID != userID {
+		return errors.New("transaction not found")
+	}
+	if !isOpenAuthorization(transaction) {
+		return fmt.Errorf("transaction is %s, not an open authorization", transaction.Status)
+	}
+	return nil
+}
+
+// releaseAuthorization returns the remaining hold to the payer inside tx. A
+// partially captured authorization ends as Completed; an uncaptured one takes
+// status. The caller cancels it at the processor once tx has committed.
+func (svc *TransactionService) releaseAuthorization(tx *gorm.DB, transaction *model.Transaction, status model.TransactionStatus, description string) error {
+	if transaction.CapturingAmount.IsPositive() {
+		return errors.New("a capture is still in progress; try again once it is confirmed")
+	}
+	funded, err := svc.fundedByProcessor(tx, transaction)
+	if err != nil {
+		return err
+	}
+	// Only wallet authorizations hold money on the ledger
+	if !funded && transaction.ReservedAmount.IsPositive() {
+		if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryRelease, description,
+			model.PayerHoldAccount(transaction.PayerID), model.PayerAccount(transaction.PayerID), transaction.ReservedAmount); err != nil {
+			return err
+		}
+	}
+
+	transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
+	if transaction.CapturedAmount.IsPositive() {
+		status = model.StatusCompleted
+	}
+	if err := transitionStatus(tx, transaction, status, description); err != nil {
+		return err
+	}
+	return tx.Save(transaction).Error
+}
+
+// voidReleasedAuthorization cancels a released authorization at the
+// processor. The ledger has already let go of the hold, so a failure is only
+// logged; the processor drops the authorization when it lapses.
+func (svc *TransactionService) voidReleasedAuthorization(ctx context.Context, transaction *model.Transaction) {
+	if err := svc.voidWithProcessor(ctx, transaction); err != nil {
+		log.Printf("Failed to void authorization %s at the processor: %v", transaction.TransactionID, err)
+		svc.logAudit(transaction.TransactionID, "Authorization Void Failed", err.Error())
+	}
+}
+
+func isOpenAuthorization(transaction *model.Transaction) bool {
+	return transaction.Status == model.StatusAuthorized || transaction.Status == model.StatusPartiallyCaptured
+}
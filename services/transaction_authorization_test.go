@@ -0,0 +1,65 @@
+package services
+
+import (
+	"poc/model"
+	"strings"
+	"testing"
+)
+
+func TestCaptureAmount(t *testing.T) {
+	inr := func(minor int64) *model.Money {
+		m := model.NewMoney(minor, "INR")
+		return &m
+	}
+	held := &model.Transaction{Amount: model.NewMoney(1000, "INR"), ReservedAmount: model.NewMoney(600, "INR")}
+	tests := []struct {
+		name    string
+		amount  *model.Money
+		want    int64
+		wantErr string
+	}{
+		{"everything still held", nil, 600, ""},
+		{"part of the hold", inr(250), 250, ""},
+		{"exactly the hold", inr(600), 600, ""},
+		{"currency defaults to the transaction's", &model.Money{MinorUnits: 100}, 100, ""},
+		{"more than is held", inr(601), 0, "exceeds remaining authorized amount"},
+		{"zero", inr(0), 0, "must be greater than zero"},
+		{"negative", inr(-5), 0, "must be greater than zero"},
+		{"other currency", &model.Money{MinorUnits: 100, Currency: "USD"}, 0, "currency"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := captureAmount(held, tc.amount)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.MinorUnits != tc.want || got.Currency != "INR" {
+				t.Errorf("captureAmount = %s, want %d INR minor units", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNothingLeftToCapture(t *testing.T) {
+	spent := &model.Transaction{Amount: model.NewMoney(1000, "INR"), ReservedAmount: model.ZeroMoney("INR")}
+	if _, err := captureAmount(spent, nil); err == nil {
+		t.Error("captured from an empty hold")
+	}
+}
+
+func TestIsOpenAuthorization(t *testing.T) {
+	for status, open := range map[model.TransactionStatus]bool{
+		model.StatusAuthorized: true, model.StatusPartiallyCaptured: true,
+		model.StatusPending: false, model.StatusCompleted: false, model.StatusVoided: false, model.StatusExpired: false,
+	} {
+		if got := isOpenAuthorization(&model.Transaction{Status: status}); got != open {
+			t.Errorf("isOpenAuthorization(%s) = %v, want %v", status, got, open)
+		}
+	}
+}
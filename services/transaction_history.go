@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"poc/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultTransactionPageSize is used when the caller does not ask for a limit.
+	DefaultTransactionPageSize = 20
+	// MaxTransactionPageSize caps how many transactions one page can return.
+	MaxTransactionPageSize = 100
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// was issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TransactionSort is a sortable column, prefixed with "-" for descending order.
+type TransactionSort string
+
+const (
+	SortCreatedAtAsc  TransactionSort = "created_at"
+	SortCreatedAtDesc TransactionSort = "-created_at"
+	SortAmountAsc     TransactionSort = "amount"
+	SortAmountDesc    TransactionSort = "-amount"
+)
+
+// TransactionFilter narrows and orders a transaction history query. Zero
+// values mean "no filter".
+type TransactionFilter struct {
+	From            *time.Time                // Created at or after
+	To              *time.Time                // Created before
+	TransactionType string                    // Debit, Credit or Refund
+	Statuses        []model.TransactionStatus // Any of these statuses
+	Counterparty    string                    // The other party's payer or payee ID
+	MinAmount       *model.Money              // Amount at least this
+	MaxAmount       *model.Money              // Amount at most this
+	PaymentMethodID string
+	Sort            TransactionSort
+	Limit           int
+	Cursor          string // NextCursor from the previous page
+}
+
+// TransactionPage is one page of a transaction history.
+type TransactionPage struct {
+	Transactions []model.Transaction `json:"transactions"`
+	NextCursor   string              `json:"next_cursor,omitempty"`
+}
+
+// GetTransactionByID returns a transaction the user took part in as payer or
+// payee. Transactions belonging to other users are reported as not found.
+func (svc *TransactionService) GetTransactionByID(ctx context.Context, transactionID, userID string) (*model.Transaction, error) {
+	var transaction model.Transaction
+	err := svc.DB.WithContext(ctx).
+		Where("transaction_id = ? AND (payer_id = ? OR payee_id = ?)", transactionID, userID, userID).
+		First(&transaction).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("transaction not found")
+		}
+		return nil, fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+	return &transaction, nil
+}
+
+// ListTransactions returns a page of the user's transactions as payer or
+// payee, filtered and sorted as requested. Pages are keyed on the sort column
+// plus the transaction ID, so new transactions never shift later pages.
+func (svc *TransactionService) ListTransactions(ctx context.Context, userID string, filter TransactionFilter) (*TransactionPage, error) {
+	if filter.Sort == "" {
+		filter.Sort = SortCreatedAtDesc
+	}
+	column, descending, err := filter.Sort.column()
+	if err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultTransactionPageSize
+	}
+	if filter.Limit > MaxTransactionPageSize {
+		filter.Limit = MaxTransactionPageSize
+	}
+
+	query := svc.DB.WithContext(ctx).Model(&model.Transaction{})
+	if filter.Counterparty != "" {
+		query = query.Where("(payer_id = ? AND payee_id = ?) OR (payee_id = ? AND payer_id = ?)",
+			userID, filter.Counterparty, userID, filter.Counterparty)
+	} else {
+		query = query.Where("payer_id = ? OR payee_id = ?", userID, userID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.TransactionType != "" {
+		query = query.Where("transaction_type = ?", filter.TransactionType)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.PaymentMethodID != "" {
+		query = query.Where("payment_method_id = ?", filter.PaymentMethodID)
+	}
+	if filter.MinAmount != nil {
+		query = query.Where("amount_currency = ? AND amount_minor_units >= ?", filter.MinAmount.Currency, filter.MinAmount.MinorUnits)
+	}
+	if filter.MaxAmount != nil {
+		query = query.Where("amount_currency = ? AND amount_minor_units <= ?", filter.MaxAmount.Currency, filter.MaxAmount.MinorUnits)
+	}
+
+	// Resume after the last row of the previous page
+	if filter.Cursor != "" {
+		value, lastID, err := decodeTransactionCursor(filter.Cursor, filter.Sort)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		query = query.Where(fmt.Sprintf("(%s %s ?) OR (%s = ? AND transaction_id %s ?)", column, op, column, op),
+			value, value, lastID)
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+	var transactions []model.Transaction
+	err = query.Order(column + " " + direction).Order("transaction_id " + direction).
+		Limit(filter.Limit + 1).Find(&transactions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transactions: %v", err)
+	}
+
+	page := &TransactionPage{Transactions: transactions}
+	if len(transactions) > filter.Limit {
+		page.Transactions = transactions[:filter.Limit]
+		page.NextCursor = encodeTransactionCursor(page.Transactions[filter.Limit-1], filter.Sort)
+	}
+	return page, nil
+}
+
+// ParseTransactionSort validates a sort parameter such as "-created_at".
+func ParseTransactionSort(value string) (TransactionSort, error) {
+	sort := TransactionSort(value)
+	if _, _, err := sort.column(); err != nil {
+		return "", err
+	}
+	return sort, nil
+}
+
+func (s TransactionSort) column() (column string, descending bool, err error) {
+	switch s {
+	case SortCreatedAtAsc, SortCreatedAtDesc:
+		column = "created_at"
+	case SortAmountAsc, SortAmountDesc:
+		column = "amount_minor_units"
+	default:
+		return "", false, fmt.Errorf("unsupported sort %q", string(s))
+	}
+	return column, strings.HasPrefix(string(s), "-"), nil
+}
+
+// encodeTransactionCursor packs the sort key and ID of the last row on a page.
+func encodeTransactionCursor(last model.Transaction, sort TransactionSort) string {
+	var value string
+	switch sort {
+	case SortAmountAsc, SortAmountDesc:
+		value = strconv.FormatInt(last.Amount.MinorUnits, 10)
+	default:
+		value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	raw := strings.Join([]string{string(sort), value, last.TransactionID}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTransactionCursor(cursor string, sort TransactionSort) (value interface{}, transactionID string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || TransactionSort(parts[0]) != sort || parts[2] == "" {
+		return nil, "", ErrInvalidCursor
+	}
+
+	switch sort {
+	case SortAmountAsc, SortAmountDesc:
+		value, err = strconv.ParseInt(parts[1], 10, 64)
+	default:
+		value, err = time.Parse(time.RFC3339Nano, parts[1])
+	}
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+	return value, parts[2], nil
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+
+	"gorm.io/gorm"
+)
+
+// processorFor returns the processor for the transaction's payment method.
+// Removed payment methods still resolve so refunds and voids keep working.
+func (svc *TransactionService) processorFor(ctx context.Context, transaction *model.Transaction) (PaymentProcessor, *model.PaymentMethod, error) {
+	var paymentMethod model.PaymentMethod
+	if err := svc.DB.WithContext(ctx).First(&paymentMethod, "payment_method_id = ?", transaction.PaymentMethodID).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch payment method: %v", err)
+	}
+	if svc.Processors == nil {
+		return nil, nil, errors.New("no payment processor configured")
+	}
+	return svc.Processors.For(paymentMethod.MethodType), &paymentMethod, nil
+}
+
+// FundedByProcessor reports whether payments made with a method type are
+// collected by a payment processor from outside the platform. Such payments
+// are booked against the settlement account; only wallet payments come out
+// of the payer's balance on the ledger.
+func FundedByProcessor(methodType string) bool {
+	switch methodType {
+	case "card", "bank_transfer", "upi":
+		return true
+	}
+	return false
+}
+
+// fundedByProcessor looks up the transaction's payment method and reports
+// whether a processor collects its money.
+func (svc *TransactionService) fundedByProcessor(tx *gorm.DB, transaction *model.Transaction) (bool, error) {
+	if transaction.PaymentMethodID == "" {
+		return false, nil
+	}
+	var paymentMethod model.PaymentMethod
+	if err := tx.Select("method_type").First(&paymentMethod, "payment_method_id = ?", transaction.PaymentMethodID).Error; err != nil {
+		return false, fmt.Errorf("failed to fetch payment method: %v", err)
+	}
+	return FundedByProcessor(paymentMethod.MethodType), nil
+}
+
+// authorizeWithProcessor asks the processor to approve the transaction and
+// stores its reference on the transaction. Declines, 3-D Secure challenges
+// and timeouts come back as ErrPaymentDeclined, ErrAuthenticationRequired and
+// ErrProcessorTimeout; anything the processor may have approved is voided.
+func (svc *TransactionService) authorizeWithProcessor(ctx context.Context, transaction *model.Transaction) error {
+	processor, paymentMethod, err := svc.processorFor(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	req := ProcessorRequest{
+		TransactionID: transaction.TransactionID,
+		Amount:        transaction.Amount,
+		MethodType:    paymentMethod.MethodType,
+		Details:       paymentMethod.Details,
+	}
+	if paymentMethod.CardToken != "" {
+		if req.CardNumber, err = svc.PaymentMethodService.Vault.Detokenize(ctx, paymentMethod.CardToken); err != nil {
+			return fmt.Errorf("failed to read card number: %v", err)
+		}
+	}
+
+	result, err := processor.Authorize(ctx, req)
+	if err != nil {
+		if errors.Is(err, ErrProcessorTimeout) {
+			// The processor may have approved it after all
+			if cancelErr := svc.cancelAtProcessor(ctx, processor, transaction.TransactionID); cancelErr != nil {
+				log.Printf("Failed to cancel timed out payment for transaction %s: %v", transaction.TransactionID, cancelErr)
+			}
+		}
+		return fmt.Errorf("processor authorization failed: %w", err)
+	}
+	switch result.Status {
+	case ProcessorDeclined:
+		return fmt.Errorf("%w: %s", ErrPaymentDeclined, result.DeclineCode)
+	case ProcessorActionRequired:
+		if _, err := processor.Void(ctx, result.Reference); err != nil {
+			log.Printf("Failed to cancel 3-D Secure payment %s for transaction %s: %v", result.Reference, transaction.TransactionID, err)
+		}
+		return ErrAuthenticationRequired
+	}
+
+	transaction.ProcessorReference = result.Reference
+	if err := svc.DB.Model(transaction).Update("processor_reference", result.Reference).Error; err != nil {
+		return fmt.Errorf("failed to save processor reference: %v", err)
+	}
+	return nil
+}
+
+// captureWithProcessor collects amount of the transaction's authorization.
+// Transactions made before processors existed have no reference and are
+// skipped.
+func (svc *TransactionService) captureWithProcessor(ctx context.Context, transaction *model.Transaction, amount model.Money) error {
+	if transaction.ProcessorReference == "" {
+		return nil
+	}
+	processor, _, err := svc.processorFor(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	if _, err := processor.Capture(ctx, transaction.ProcessorReference, amount); err != nil {
+		return fmt.Errorf("processor capture failed: %w", err)
+	}
+	return nil
+}
+
+// refundWithProcessor returns amount of the original transaction's payment.
+func (svc *TransactionService) refundWithProcessor(ctx context.Context, original *model.Transaction, amount model.Money) error {
+	if original.ProcessorReference == "" {
+		return nil
+	}
+	processor, _, err := svc.processorFor(ctx, original)
+	if err != nil {
+		return err
+	}
+	if _, err := processor.Refund(ctx, original.ProcessorReference, amount); err != nil {
+		return fmt.Errorf("processor refund failed: %w", err)
+	}
+	return nil
+}
+
+// voidWithProcessor cancels what is left of the transaction's authorization.
+// An authorization the processor no longer knows has nothing left to cancel.
+func (svc *TransactionService) voidWithProcessor(ctx context.Context, transaction *model.Transaction) error {
+	if transaction.ProcessorReference == "" {
+		return nil
+	}
+	processor, _, err := svc.processorFor(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	_, err = processor.Void(ctx, transaction.ProcessorReference)
+	if errors.Is(err, ErrProcessorPaymentNotFound) {
+		// Nothing left to cancel, e.g. the processor dropped the authorization
+		log.Printf("Processor has no authorization %s for transaction %s; releasing the hold anyway", transaction.ProcessorReference, transaction.TransactionID)
+		return nil
+	}
+	if err != nil {
+		// A retry after a void that went through, or after the last capture,
+		// finds nothing left to cancel
+		if result, statusErr := processor.Status(ctx, transaction.TransactionID); statusErr == nil &&
+			(result.Status == ProcessorVoided || result.Status == ProcessorCaptured || result.Status == ProcessorRefunded) {
+			return nil
+		}
+		return fmt.Errorf("processor void failed: %w", err)
+	}
+	return nil
+}
+
+// cancelAtProcessor makes sure the processor keeps nothing for a transaction:
+// an open authorization is voided and whatever was captured and not yet
+// refunded is refunded. Payments the processor never saw are fine.
+func (svc *TransactionService) cancelAtProcessor(ctx context.Context, processor PaymentProcessor, transactionID string) error {
+	result, err := processor.Status(ctx, transactionID)
+	if errors.Is(err, ErrProcessorPaymentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if result.Status == ProcessorAuthorized || result.Status == ProcessorActionRequired {
+		if result, err = processor.Void(ctx, result.Reference); err != nil {
+			return err
+		}
+	}
+	if result.Status != ProcessorCaptured {
+		return nil
+	}
+	remaining, err := result.CapturedAmount.Sub(result.RefundedAmount)
+	if err != nil || !remaining.IsPositive() {
+		return err
+	}
+	_, err = processor.Refund(ctx, result.Reference, remaining)
+	return err
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultStuckTransactionAfter is how long a transaction may sit in Pending
+// or Reserved before the recovery sweeper steps in.
+const DefaultStuckTransactionAfter = 10 * time.Minute
+
+// Recovery decisions, as recorded in the audit log.
+const (
+	recoveryCompleted  = "Recovery Completed"
+	recoveryRolledBack = "Recovery Rolled Back"
+)
+
+// RecoveryStats is what the recovery sweeper reports for monitoring. The
+// stuck counts are from the latest sweep; the totals count since startup.
+type RecoveryStats struct {
+	LastRunAt          *time.Time `json:"last_run_at"`
+	LastError          string     `json:"last_error,omitempty"`
+	StuckPending       int        `json:"stuck_pending"`
+	StuckReserved      int        `json:"stuck_reserved"`
+	OldestStuckSeconds int64      `json:"oldest_stuck_seconds"`
+	SagasCompensating  int64      `json:"sagas_compensating"` // Sagas whose compensation keeps failing
+	CompletedTotal     int64      `json:"completed_total"`
+	RolledBackTotal    int64      `json:"rolled_back_total"`
+	UndecidedTotal     int64      `json:"undecided_total"` // Left for the next sweep, e.g. processor unreachable
+}
+
+// RecoveryMetrics holds the latest RecoveryStats for the metrics endpoint.
+type RecoveryMetrics struct {
+	mu    sync.Mutex
+	stats RecoveryStats
+}
+
+// Snapshot returns a copy of the current stats.
+func (m *RecoveryMetrics) Snapshot() RecoveryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+func (m *RecoveryMetrics) update(fn func(*RecoveryStats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.stats)
+}
+
+// RecoverStuckTransactions finds debits and credits stuck in Pending or
+// Reserved for longer than StuckAfter and settles each one: a payment the
+// processor captured in full is completed, anything else is rolled back and
+// cancelled at the processor. Refunds and captures whose processor outcome
+// was never recorded are finished or undone to match the processor. Transactions owned by a live saga, cheques in
+// clearing and unanswered UPI collect requests are left alone. Every decision
+// is written to the audit log. It returns how many transactions it settled.
+func (svc *TransactionService) RecoverStuckTransactions(ctx context.Context) (int, error) {
+	settled, err := svc.recoverStuckTransactions(ctx)
+	now := time.Now()
+	svc.Recovery.update(func(stats *RecoveryStats) {
+		stats.LastRunAt = &now
+		stats.LastError = ""
+		if err != nil {
+			stats.LastError = err.Error()
+		}
+	})
+	return settled, err
+}
+
+func (svc *TransactionService) recoverStuckTransactions(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-svc.StuckAfter)
+	var stuck []model.Transaction
+	err := svc.DB.WithContext(ctx).
+		Where("status IN ? AND transaction_type IN ? AND updated_at < ?",
+			[]model.TransactionStatus{model.StatusPending, model.StatusReserved}, []string{"Debit", "Credit", "Refund"}, cutoff).
+		Where("transaction_id NOT IN (?)", svc.DB.Model(&model.Cheque{}).Select("transaction_id")).
+		Where("transaction_id NOT IN (?)", svc.DB.Model(&model.UPICollectRequest{}).Select("transaction_id").Where("status = ?", model.CollectRequestPending)).
+		Where("transaction_id NOT IN (?)", svc.DB.Model(&model.Saga{}).Select("transaction_id").Where("status IN ?", []string{model.SagaRunning, model.SagaCompensating})).
+		Order("updated_at").Find(&stuck).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch stuck transactions: %v", err)
+	}
+
+	var compensating int64
+	if err := svc.DB.WithContext(ctx).Model(&model.Saga{}).
+		Where("status = ? AND updated_at < ?", model.SagaCompensating, cutoff).Count(&compensating).Error; err != nil {
+		return 0, fmt.Errorf("failed to count stalled sagas: %v", err)
+	}
+
+	pending, reserved := 0, 0
+	var oldest time.Duration
+	for _, transaction := range stuck {
+		if transaction.Status == model.StatusPending {
+			pending++
+		} else {
+			reserved++
+		}
+		if age := time.Since(transaction.UpdatedAt); age > oldest {
+			oldest = age
+		}
+	}
+
+	// Captures sent to the processor whose outcome was never recorded
+	var capturing []model.Transaction
+	err = svc.DB.WithContext(ctx).
+		Where("status IN ? AND capturing_amount_minor_units > 0 AND updated_at < ?",
+			[]model.TransactionStatus{model.StatusAuthorized, model.StatusPartiallyCaptured}, cutoff).
+		Order("updated_at").Find(&capturing).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch stuck captures: %v", err)
+	}
+	stuck = append(stuck, capturing...)
+	svc.Recovery.update(func(stats *RecoveryStats) {
+		stats.StuckPending, stats.StuckReserved = pending, reserved
+		stats.OldestStuckSeconds = int64(oldest / time.Second)
+		stats.SagasCompensating = compensating
+	})
+
+	settled := 0
+	for i := range stuck {
+		decision, err := svc.recoverTransaction(ctx, &stuck[i])
+		svc.Recovery.update(func(stats *RecoveryStats) {
+			switch {
+			case err != nil:
+				stats.UndecidedTotal++
+			case decision == recoveryCompleted:
+				stats.CompletedTotal++
+			case decision == recoveryRolledBack:
+				stats.RolledBackTotal++
+			}
+		})
+		if err != nil {
+			log.Printf("Failed to recover transaction %s: %v", stuck[i].TransactionID, err)
+			continue
+		}
+		if decision != "" {
+			settled++
+		}
+	}
+	return settled, nil
+}
+
+// recoverTransaction settles one stuck transaction and returns the decision,
+// or "" when it moved on by itself in the meantime.
+func (svc *TransactionService) recoverTransaction(ctx context.Context, candidate *model.Transaction) (string, error) {
+	var transaction model.Transaction
+	if err := svc.DB.WithContext(ctx).First(&transaction, "transaction_id = ?", candidate.TransactionID).Error; err != nil {
+		return "", err
+	}
+	if transaction.Status != candidate.Status || !transaction.UpdatedAt.Equal(candidate.UpdatedAt) {
+		return "", nil
+	}
+	stuckFor := time.Since(transaction.UpdatedAt).Round(time.Second)
+	switch {
+	case transaction.CapturingAmount.IsPositive():
+		return svc.recoverCapture(ctx, &transaction, stuckFor)
+	case transaction.TransactionType == "Refund":
+		return svc.recoverRefund(ctx, &transaction, stuckFor)
+	}
+
+	// Only processor-funded debits go through a processor; its view decides the outcome
+	processorState := "none"
+	var processor PaymentProcessor
+	funded, err := svc.fundedByProcessor(svc.DB.WithContext(ctx), &transaction)
+	if err != nil {
+		return "", err
+	}
+	if transaction.TransactionType == "Debit" && funded {
+		if processor, _, err = svc.processorFor(ctx, &transaction); err != nil {
+			return "", err
+		}
+		result, err := processor.Status(ctx, transaction.TransactionID)
+		switch {
+		case errors.Is(err, ErrProcessorPaymentNotFound):
+		case err != nil:
+			return "", fmt.Errorf("processor status unavailable: %w", err)
+		default:
+			processorState = result.Status
+			if isFullyCaptured(result, transaction.Amount) {
+				if err := svc.completeFromProcessor(ctx, &transaction, result.Reference); err != nil {
+					return "", err
+				}
+				svc.logAudit(transaction.TransactionID, recoveryCompleted,
+					fmt.Sprintf("Stuck in %s for %s; processor captured the payment (%s), so it was paid to the payee", candidate.Status, stuckFor, result.Reference))
+				return recoveryCompleted, nil
+			}
+		}
+	}
+
+	if processor != nil {
+		if err := svc.cancelAtProcessor(ctx, processor, transaction.TransactionID); err != nil {
+			return "", fmt.Errorf("failed to cancel payment at processor: %w", err)
+		}
+	}
+	if transaction.Status == model.StatusReserved {
+		err = svc.RollbackReservation(ctx, &transaction)
+	} else {
+		err = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, model.StatusFailed, "Rolled back by recovery")
+	}
+	if err != nil {
+		return "", err
+	}
+	svc.logAudit(transaction.TransactionID, recoveryRolledBack,
+		fmt.Sprintf("Stuck in %s for %s; processor state %s, so the payment was rolled back", candidate.Status, stuckFor, processorState))
+	return recoveryRolledBack, nil
+}
+
+// recoverCapture settles a capture whose processor outcome was never
+// recorded: if the processor has collected it, the payee is paid, otherwise
+// it goes back into the hold.
+func (svc *TransactionService) recoverCapture(ctx context.Context, transaction *model.Transaction, stuckFor time.Duration) (string, error) {
+	collected := transaction.ProcessorReference == ""
+	if !collected {
+		processor, _, err := svc.processorFor(ctx, transaction)
+		if err != nil {
+			return "", err
+		}
+		result, err := processor.Status(ctx, transaction.TransactionID)
+		switch {
+		case errors.Is(err, ErrProcessorPaymentNotFound):
+		case err != nil:
+			return "", fmt.Errorf("processor status unavailable: %w", err)
+		default:
+			expected, err := transaction.CapturedAmount.Add(transaction.CapturingAmount)
+			if err != nil {
+				return "", err
+			}
+			short, err := result.CapturedAmount.LessThan(expected)
+			if err != nil {
+				return "", err
+			}
+			collected = result.Status == ProcessorCaptured && !short
+		}
+	}
+
+	capture := transaction.CapturingAmount
+	if !collected {
+		if err := svc.abandonCapture(ctx, transaction.TransactionID, "Processor did not collect the capture"); err != nil {
+			return "", err
+		}
+		svc.logAudit(transaction.TransactionID, recoveryRolledBack,
+			fmt.Sprintf("Capture of %s unconfirmed for %s; processor did not collect it, so it went back into the hold", capture, stuckFor))
+		return recoveryRolledBack, nil
+	}
+	if err := svc.finishCapture(ctx, transaction); err != nil {
+		return "", err
+	}
+	svc.logAudit(transaction.TransactionID, recoveryCompleted,
+		fmt.Sprintf("Capture of %s unconfirmed for %s; processor collected it, so it was paid to the payee", capture, stuckFor))
+	return recoveryCompleted, nil
+}
+
+// recoverRefund settles a refund whose processor outcome was never recorded
+// by comparing what the processor has refunded with the refunds already
+// recorded against the original.
+func (svc *TransactionService) recoverRefund(ctx context.Context, refund *model.Transaction, stuckFor time.Duration) (string, error) {
+	var original model.Transaction
+	if err := svc.DB.WithContext(ctx).First(&original, "transaction_id = ?", refund.OriginalTransactionID).Error; err != nil {
+		return "", fmt.Errorf("failed to fetch original transaction: %v", err)
+	}
+
+	paid := original.ProcessorReference == ""
+	if !paid {
+		processor, _, err := svc.processorFor(ctx, &original)
+		if err != nil {
+			return "", err
+		}
+		result, err := processor.Status(ctx, original.TransactionID)
+		switch {
+		case errors.Is(err, ErrProcessorPaymentNotFound):
+		case err != nil:
+			return "", fmt.Errorf("processor status unavailable: %w", err)
+		default:
+			expected, err := original.RefundedAmount.Add(refund.Amount)
+			if err != nil {
+				return "", err
+			}
+			short, err := result.RefundedAmount.LessThan(expected)
+			if err != nil {
+				return "", err
+			}
+			paid = !short
+		}
+	}
+
+	if !paid {
+		if err := svc.abandonRefund(ctx, refund.TransactionID, "Rolled back by recovery"); err != nil {
+			return "", err
+		}
+		svc.logAudit(refund.TransactionID, recoveryRolledBack,
+			fmt.Sprintf("Stuck in %s for %s; processor did not refund it, so the payee keeps the money", refund.Status, stuckFor))
+		return recoveryRolledBack, nil
+	}
+	if err := svc.finishRefund(ctx, refund, &original, "Refund to payer (recovered)"); err != nil {
+		return "", err
+	}
+	svc.logAudit(refund.TransactionID, recoveryCompleted,
+		fmt.Sprintf("Stuck in %s for %s; processor refunded it, so the payer was paid", refund.Status, stuckFor))
+	return recoveryCompleted, nil
+}
+
+// completeFromProcessor pays a stuck debit to the payee after the processor
+// has collected it. The payee is paid from the settlement account, or from
+// the payer's hold if funds were reserved for it.
+func (svc *TransactionService) completeFromProcessor(ctx context.Context, transaction *model.Transaction, reference string) error {
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(transaction, "transaction_id = ?", transaction.TransactionID).Error; err != nil {
+			return err
+		}
+		from := model.SystemAccount(model.LedgerSystemSettlement)
+		switch transaction.Status {
+		case model.StatusPending:
+		case model.StatusReserved:
+			from = model.PayerHoldAccount(transaction.PayerID)
+		default:
+			return fmt.Errorf("transaction is now %s", transaction.Status)
+		}
+		if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryPayment, "Payment to payee (recovered)",
+			from, model.PayeeAccount(transaction.PayeeID), transaction.Amount); err != nil {
+			return err
+		}
+		transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
+		transaction.ProcessorReference = reference
+		if err := transitionStatus(tx, transaction, model.StatusCompleted, "Completed by recovery"); err != nil {
+			return err
+		}
+		return tx.Save(transaction).Error
+	})
+}
+
+func isFullyCaptured(result ProcessorResult, amount model.Money) bool {
+	return result.Status == ProcessorCaptured && result.RefundedAmount.IsZero() &&
+		result.CapturedAmount.Currency == amount.Currency && result.CapturedAmount.MinorUnits == amount.MinorUnits
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"poc/model"
+	"testing"
+)
+
+func TestIsFullyCaptured(t *testing.T) {
+	amount := model.NewMoney(1000, "INR")
+	tests := []struct {
+		name   string
+		result ProcessorResult
+		want   bool
+	}{
+		{"captured in full", ProcessorResult{Status: ProcessorCaptured, CapturedAmount: amount, RefundedAmount: model.ZeroMoney("INR")}, true},
+		{"only authorized", ProcessorResult{Status: ProcessorAuthorized, CapturedAmount: model.ZeroMoney("INR"), RefundedAmount: model.ZeroMoney("INR")}, false},
+		{"captured short", ProcessorResult{Status: ProcessorCaptured, CapturedAmount: model.NewMoney(999, "INR"), RefundedAmount: model.ZeroMoney("INR")}, false},
+		{"captured in another currency", ProcessorResult{Status: ProcessorCaptured, CapturedAmount: model.NewMoney(1000, "USD"), RefundedAmount: model.ZeroMoney("USD")}, false},
+		{"partly refunded since", ProcessorResult{Status: ProcessorCaptured, CapturedAmount: amount, RefundedAmount: model.NewMoney(1, "INR")}, false},
+		{"voided", ProcessorResult{Status: ProcessorVoided, CapturedAmount: model.ZeroMoney("INR"), RefundedAmount: model.ZeroMoney("INR")}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isFullyCaptured(tc.result, amount); got != tc.want {
+				t.Errorf("isFullyCaptured = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
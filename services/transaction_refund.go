@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"poc/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefundTransaction refunds all or part of a completed debit. A nil amount
+// refunds whatever is still refundable. Only the payee of the original
+// transaction may issue a refund. The refund is recorded as its own Refund
+// transaction linked to the original through OriginalTransactionID.
+//
+// The refund is first held for the payer and committed as Pending, then sent
+// to the processor, and only completed once the processor confirms. If the
+// processor refuses, the hold goes back to the payee; if the outcome is
+// unknown, recovery settles it later from the processor's view.
+func (svc *TransactionService) RefundTransaction(ctx context.Context, transactionID, userID string, amount *model.Money, reason string) (refund *model.Transaction, original *model.Transaction, err error) {
+	original = &model.Transaction{}
+	description := "Refund to payer"
+	if reason != "" {
+		description = "Refund to payer: " + reason
+	}
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(original, "transaction_id = ?", transactionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("transaction not found")
+			}
+			return fmt.Errorf("failed to fetch transaction: %v", err)
+		}
+		if original.PayerID != userID && original.PayeeID != userID {
+			return errors.New("transaction not found")
+		}
+		if original.PayeeID != userID {
+			return errors.New("only the payee can refund a transaction")
+		}
+
+		refundAmount, err := RefundableAmount(original)
+		if err != nil {
+			return err
+		}
+		if amount != nil {
+			refundAmount = *amount
+			if refundAmount.Currency == "" {
+				refundAmount.Currency = original.Amount.Currency
+			}
+		}
+
+		refund = &model.Transaction{
+			TransactionID:         utils.GenerateUniqueID(),
+			PayerID:               original.PayerID,
+			PayeeID:               original.PayeeID,
+			Amount:                refundAmount,
+			ReservedAmount:        model.ZeroMoney(refundAmount.Currency),
+			TransactionType:       "Refund",
+			PaymentMethodID:       original.PaymentMethodID,
+			OriginalTransactionID: original.TransactionID,
+			CreatedAt:             time.Now(),
+			UpdatedAt:             time.Now(),
+		}
+		if err := svc.createTransaction(tx, refund, model.StatusPending, "Refund requested"); err != nil {
+			return fmt.Errorf("failed to create refund transaction: %v", err)
+		}
+		return svc.holdRefund(tx, refund, original)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := svc.refundWithProcessor(ctx, original, refund.Amount); err != nil {
+		if errors.Is(err, ErrProcessorTimeout) {
+			return nil, nil, fmt.Errorf("refund %s is waiting for the processor to confirm: %w", refund.TransactionID, err)
+		}
+		if releaseErr := svc.abandonRefund(ctx, refund.TransactionID, "Refund refused by processor"); releaseErr != nil {
+			log.Printf("Failed to release refused refund %s: %v", refund.TransactionID, releaseErr)
+		}
+		return nil, nil, err
+	}
+	if err := svc.finishRefund(ctx, refund, original, description); err != nil {
+		return nil, nil, fmt.Errorf("refund %s was paid out but not yet recorded: %v", refund.TransactionID, err)
+	}
+
+	svc.logAudit(refund.TransactionID, "Refund Completed", fmt.Sprintf("Refunded %s of transaction %s. %s", refund.Amount, original.TransactionID, reason))
+	svc.logAudit(original.TransactionID, "Transaction "+string(original.Status), fmt.Sprintf("Refunded %s in total", original.RefundedAmount))
+	return refund, original, nil
+}
+
+// holdRefund checks a new refund against what is left to refund and moves
+// its amount from the payee into the payer's hold account until the
+// processor confirms it.
+func (svc *TransactionService) holdRefund(tx *gorm.DB, refund *model.Transaction, original *model.Transaction) error {
+	if err := svc.checkRefund(tx, refund, original); err != nil {
+		return err
+	}
+	if _, err := svc.Ledger.Transfer(tx, refund.TransactionID, model.JournalEntryReserve, "Refund held for payer",
+		model.PayeeAccount(original.PayeeID), model.PayerHoldAccount(original.PayerID), refund.Amount); err != nil {
+		if errors.Is(err, ErrInsufficientFunds) {
+			return errors.New("insufficient funds in payee account for refund")
+		}
+		return err
+	}
+	refund.ReservedAmount = refund.Amount
+	return tx.Save(refund).Error
+}
+
+// finishRefund pays a held refund the processor has confirmed to the payer
+// and completes it.
+func (svc *TransactionService) finishRefund(ctx context.Context, refund *model.Transaction, original *model.Transaction, description string) error {
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(refund, "transaction_id = ?", refund.TransactionID).Error; err != nil {
+			return err
+		}
+		if refund.Status != model.StatusPending {
+			return fmt.Errorf("refund is %s", refund.Status)
+		}
+		if err := tx.First(original, "transaction_id = ?", refund.OriginalTransactionID).Error; err != nil {
+			return err
+		}
+		if err := svc.applyRefund(tx, refund, original, model.PayerHoldAccount(original.PayerID), description); err != nil {
+			return err
+		}
+		refund.ReservedAmount = model.ZeroMoney(refund.Amount.Currency)
+		if err := transitionStatus(tx, refund, model.StatusCompleted, description); err != nil {
+			return err
+		}
+		return tx.Save(refund).Error
+	})
+}
+
+// abandonRefund gives a held refund the processor did not pay back to the
+// payee and fails it.
+func (svc *TransactionService) abandonRefund(ctx context.Context, refundID, reason string) error {
+	var refund model.Transaction
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&refund, "transaction_id = ?", refundID).Error; err != nil {
+			return err
+		}
+		if refund.Status != model.StatusPending {
+			return nil
+		}
+		if refund.ReservedAmount.IsPositive() {
+			if _, err := svc.Ledger.Transfer(tx, refund.TransactionID, model.JournalEntryRelease, reason,
+				model.PayerHoldAccount(refund.PayerID), model.PayeeAccount(refund.PayeeID), refund.ReservedAmount); err != nil {
+				return err
+			}
+		}
+		refund.ReservedAmount = model.ZeroMoney(refund.Amount.Currency)
+		if err := transitionStatus(tx, &refund, model.StatusFailed, reason); err != nil {
+			return err
+		}
+		return tx.Save(&refund).Error
+	})
+	if err != nil {
+		return err
+	}
+	svc.logAudit(refundID, "Refund Failed", reason)
+	return nil
+}
+
+// RefundableAmount is what is left to refund on a transaction: the settled
+// amount (the captured amount for authorizations) minus prior refunds.
+func RefundableAmount(original *model.Transaction) (model.Money, error) {
+	settled := original.Amount
+	if original.CapturedAmount.IsPositive() {
+		settled = original.CapturedAmount
+	}
+	return settled.Sub(original.RefundedAmount)
+}
+
+// checkRefund validates a refund against the original transaction. Refunds
+// still waiting for the processor count as already refunded.
+func (svc *TransactionService) checkRefund(tx *gorm.DB, refund *model.Transaction, original *model.Transaction) error {
+	if original.TransactionType != "Debit" {
+		return errors.New("only debit transactions can be refunded")
+	}
+	if original.Status != model.StatusCompleted && original.Status != model.StatusPartiallyRefunded {
+		return fmt.Errorf("refund not allowed for %s transactions", original.Status)
+	}
+	if !refund.Amount.IsPositive() {
+		return errors.New("refund amount must be greater than zero")
+	}
+
+	refundable, err := RefundableAmount(original)
+	if err != nil {
+		return err
+	}
+	var pending []model.Transaction
+	if err := tx.Where("original_transaction_id = ? AND transaction_type = ? AND status = ? AND transaction_id <> ?",
+		original.TransactionID, "Refund", model.StatusPending, refund.TransactionID).Find(&pending).Error; err != nil {
+		return fmt.Errorf("failed to fetch pending refunds: %v", err)
+	}
+	for _, other := range pending {
+		if refundable, err = refundable.Sub(other.Amount); err != nil {
+			return err
+		}
+	}
+	exceeds, err := refundable.LessThan(refund.Amount)
+	if err != nil {
+		return err
+	}
+	if exceeds {
+		return fmt.Errorf("refund amount %s exceeds refundable amount %s", refund.Amount, refundable)
+	}
+	return nil
+}
+
+// applyRefund moves refund.Amount from the given account back to where the
+// original payment came from and updates the original transaction's refunded
+// total and status inside tx. Wallet payments go back to the payer's balance;
+// the processor returns the others, so they go back to the settlement account.
+func (svc *TransactionService) applyRefund(tx *gorm.DB, refund *model.Transaction, original *model.Transaction, from model.LedgerAccount, description string) error {
+	if err := svc.checkRefund(tx, refund, original); err != nil {
+		return err
+	}
+	funded, err := svc.fundedByProcessor(tx, original)
+	if err != nil {
+		return err
+	}
+	to := model.PayerAccount(original.PayerID)
+	if funded {
+		to = model.SystemAccount(model.LedgerSystemSettlement)
+	}
+
+	// Reverse the money back to the payer
+	if _, err := svc.Ledger.Transfer(tx, refund.TransactionID, model.JournalEntryRefund, description,
+		from, to, refund.Amount); err != nil {
+		if errors.Is(err, ErrInsufficientFunds) {
+			return errors.New("insufficient funds in payee account for refund")
+		}
+		return err
+	}
+
+	// Track the cumulative refunded total on the original
+	refundable, err := RefundableAmount(original)
+	if err != nil {
+		return err
+	}
+	if original.RefundedAmount, err = original.RefundedAmount.Add(refund.Amount); err != nil {
+		return err
+	}
+	next := model.StatusPartiallyRefunded
+	if refundable.MinorUnits == refund.Amount.MinorUnits {
+		next = model.StatusRefunded
+	}
+	if err := transitionStatus(tx, original, next, fmt.Sprintf("Refunded %s in refund %s", refund.Amount, refund.TransactionID)); err != nil {
+		return err
+	}
+	return tx.Save(original).Error
+}
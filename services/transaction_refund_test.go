@@ -0,0 +1,42 @@
+package services
+
+import (
+	"poc/model"
+	"testing"
+)
+
+func TestRefundableAmount(t *testing.T) {
+	inr := func(minor int64) model.Money { return model.NewMoney(minor, "INR") }
+	tests := []struct {
+		name     string
+		amount   model.Money
+		captured model.Money
+		refunded model.Money
+		want     int64
+	}{
+		{"nothing refunded", inr(1000), model.ZeroMoney("INR"), model.ZeroMoney("INR"), 1000},
+		{"after a partial refund", inr(1000), model.ZeroMoney("INR"), inr(300), 700},
+		{"after several partial refunds", inr(1000), model.ZeroMoney("INR"), inr(999), 1},
+		{"fully refunded", inr(1000), model.ZeroMoney("INR"), inr(1000), 0},
+		{"partially captured authorization", inr(1000), inr(400), model.ZeroMoney("INR"), 400},
+		{"refund of a partial capture", inr(1000), inr(400), inr(150), 250},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RefundableAmount(&model.Transaction{Amount: tc.amount, CapturedAmount: tc.captured, RefundedAmount: tc.refunded})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.MinorUnits != tc.want || got.Currency != "INR" {
+				t.Errorf("RefundableAmount = %s, want %d INR minor units", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRefundableAmountCurrencyMismatch(t *testing.T) {
+	_, err := RefundableAmount(&model.Transaction{Amount: model.NewMoney(1000, "INR"), RefundedAmount: model.NewMoney(100, "USD")})
+	if err == nil {
+		t.Error("expected a currency mismatch")
+	}
+}
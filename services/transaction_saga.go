@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Payment saga steps.
+const (
+	sagaStepCreate   = "create_transaction"
+	sagaStepBalance  = "check_balance"
+	sagaStepReserve  = "reserve_funds"
+	sagaStepProcess  = "process_payment"
+	sagaStepComplete = "complete_transaction"
+)
+
+// paymentSagaSteps are the steps InitializeTransaction runs for a debit or a
+// credit. Processing the payment is the pivot: before it a failure releases
+// the reservation and fails the transaction, after it the payment is
+// reversed.
+func (svc *TransactionService) paymentSagaSteps(transaction *model.Transaction) []SagaStep {
+	steps := []SagaStep{{
+		Name: sagaStepCreate,
+		Action: func(ctx context.Context) error {
+			if err := svc.createTransaction(svc.DB, transaction, model.StatusPending, "Transaction created"); err != nil {
+				return fmt.Errorf("failed to create transaction: %v", err)
+			}
+			return nil
+		},
+		Compensate: func(ctx context.Context) error {
+			return svc.failOpenTransaction(ctx, transaction.TransactionID)
+		},
+	}}
+
+	if transaction.TransactionType == "Debit" {
+		// Only wallet payments come out of the payer's balance; a processor
+		// collects the others from outside the platform
+		walletOnly := func(ctx context.Context, action func() error) error {
+			funded, err := svc.fundedByProcessor(svc.DB.WithContext(ctx), transaction)
+			if err != nil || funded {
+				return err
+			}
+			return action()
+		}
+		steps = append(steps,
+			SagaStep{
+				Name: sagaStepBalance,
+				Action: func(ctx context.Context) error {
+					return walletOnly(ctx, func() error {
+						if err := svc.CheckBalance(ctx, transaction); err != nil {
+							return fmt.Errorf("balance check failed: %v", err)
+						}
+						return nil
+					})
+				},
+			},
+			SagaStep{
+				Name: sagaStepReserve,
+				Action: func(ctx context.Context) error {
+					return walletOnly(ctx, func() error {
+						if err := svc.ReserveFunds(ctx, transaction); err != nil {
+							return fmt.Errorf("failed to reserve funds: %v", err)
+						}
+						return nil
+					})
+				},
+				Compensate: func(ctx context.Context) error {
+					return svc.releaseOpenReservation(ctx, transaction.TransactionID)
+				},
+			},
+		)
+	}
+
+	return append(steps,
+		SagaStep{
+			Name: sagaStepProcess,
+			Action: func(ctx context.Context) error {
+				if err := svc.ProcessPayment(ctx, transaction); err != nil {
+					return fmt.Errorf("payment processing failed: %w", err)
+				}
+				return nil
+			},
+			Compensate: func(ctx context.Context) error {
+				return svc.reversePayment(ctx, transaction.TransactionID)
+			},
+			Pivot: true,
+			Done: func(ctx context.Context) (bool, error) {
+				var current model.Transaction
+				if err := svc.DB.WithContext(ctx).First(&current, "transaction_id = ?", transaction.TransactionID).Error; err != nil {
+					return false, err
+				}
+				return current.Status == model.StatusCompleted, nil
+			},
+		},
+		SagaStep{
+			Name: sagaStepComplete,
+			Action: func(ctx context.Context) error {
+				if err := svc.CompleteTransaction(transaction.TransactionID, svc.DB); err != nil {
+					return fmt.Errorf("failed to complete transaction: %v", err)
+				}
+				return nil
+			},
+		},
+	)
+}
+
+// ResumeSagas finishes or compensates payment sagas that have made no
+// progress for longer than olderThan, such as those cut off by a restart.
+// Each saga is claimed first so only one instance recovers it. It returns how
+// many were picked up.
+func (svc *TransactionService) ResumeSagas(ctx context.Context, olderThan time.Duration) (int, error) {
+	sagas, err := svc.Sagas.Stale(ctx, olderThan)
+	if err != nil {
+		return 0, err
+	}
+
+	resumed := 0
+	for i := range sagas {
+		saga := &sagas[i]
+		if saga.Kind != model.SagaKindPayment {
+			continue
+		}
+		// Another instance may be recovering the same saga, or its owner
+		// may have moved it on since Stale looked
+		claimed, err := svc.Sagas.Claim(ctx, saga)
+		if err != nil {
+			log.Printf("Failed to claim saga %s for transaction %s: %v", saga.SagaID, saga.TransactionID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		// A saga interrupted before its transaction was created has nothing
+		// to undo, and any step list compensates it correctly
+		transaction := &model.Transaction{TransactionID: saga.TransactionID, TransactionType: "Debit"}
+		if err := svc.DB.WithContext(ctx).First(transaction, "transaction_id = ?", saga.TransactionID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return resumed, fmt.Errorf("failed to fetch transaction %s: %v", saga.TransactionID, err)
+		}
+
+		compensated, err := svc.Sagas.Resume(ctx, saga, svc.paymentSagaSteps(transaction))
+		if err != nil {
+			log.Printf("Failed to resume saga %s for transaction %s: %v", saga.SagaID, saga.TransactionID, err)
+			continue
+		}
+		resumed++
+		if compensated {
+			svc.logAudit(saga.TransactionID, "Saga Compensated", "Payment undone after an interruption: "+saga.LastError)
+		} else {
+			svc.logAudit(saga.TransactionID, "Saga Resumed", "Payment finished after an interruption")
+		}
+	}
+	return resumed, nil
+}
+
+// failOpenTransaction fails a transaction that never got past Pending.
+func (svc *TransactionService) failOpenTransaction(ctx context.Context, transactionID string) error {
+	var transaction model.Transaction
+	if err := svc.DB.WithContext(ctx).First(&transaction, "transaction_id = ?", transactionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if transaction.Status != model.StatusPending {
+		return nil
+	}
+	return svc.UpdateTransactionStatus(ctx, transactionID, model.StatusFailed, "Payment saga compensated")
+}
+
+// releaseOpenReservation returns reserved funds to the payer if the
+// transaction still holds them.
+func (svc *TransactionService) releaseOpenReservation(ctx context.Context, transactionID string) error {
+	var transaction model.Transaction
+	if err := svc.DB.WithContext(ctx).First(&transaction, "transaction_id = ?", transactionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if transaction.Status != model.StatusReserved {
+		return nil
+	}
+	return svc.RollbackReservation(ctx, &transaction)
+}
+
+// reversePayment undoes a processed payment: the payee's credit goes back to
+// the payer's balance for wallet payments, or to the settlement account for
+// credits and processor-funded debits, and the processor returns what it
+// collected.
+func (svc *TransactionService) reversePayment(ctx context.Context, transactionID string) error {
+	var transaction model.Transaction
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&transaction, "transaction_id = ?", transactionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if transaction.Status != model.StatusCompleted {
+			return nil
+		}
+
+		// Credits and processor-funded debits came from outside the platform
+		funded, err := svc.fundedByProcessor(tx, &transaction)
+		if err != nil {
+			return err
+		}
+		to := model.PayerAccount(transaction.PayerID)
+		if transaction.TransactionType == "Credit" || funded {
+			to = model.SystemAccount(model.LedgerSystemSettlement)
+		}
+		if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryReversal, "Payment reversed",
+			model.PayeeAccount(transaction.PayeeID), to, transaction.Amount); err != nil {
+			return err
+		}
+		if err := transitionStatus(tx, &transaction, model.StatusReversed, "Payment saga compensated"); err != nil {
+			return err
+		}
+		return tx.Save(&transaction).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reverse payment: %v", err)
+	}
+	if transaction.TransactionType != "Debit" || transaction.PaymentMethodID == "" {
+		return nil
+	}
+
+	// Whatever the ledger says, make sure the processor keeps nothing
+	processor, _, err := svc.processorFor(ctx, &transaction)
+	if err != nil {
+		return err
+	}
+	return svc.cancelAtProcessor(ctx, processor, transaction.TransactionID)
+}
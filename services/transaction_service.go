@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"poc/card"
 	"poc/model"
 	"poc/utils"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -15,167 +17,171 @@ import (
 type TransactionService struct {
 	DB                   *gorm.DB
 	PaymentMethodService *PaymentMethodService
+	Ledger               *LedgerService
+	AuthorizationTTL     time.Duration      // How long an uncaptured authorization hold lasts
+	CollectRequestTTL    time.Duration      // How long a payer has to answer a UPI collect request
+	ChequeClearingPeriod time.Duration      // How long a presented cheque takes to clear
+	ChequeBounceFee      model.Money        // Charged to the payer when a cheque bounces; zero for none
+	StuckAfter           time.Duration      // How long a Pending or Reserved transaction may sit before recovery settles it
+	Processors           *ProcessorRegistry // Set from PAYMENT_PROCESSOR; nil fails every payment that needs one
+	Sagas                *SagaOrchestrator
+	Recovery             *RecoveryMetrics
 }
 
-func NewTransactionService(db *gorm.DB, pmService *PaymentMethodService) *TransactionService {
+func NewTransactionService(db *gorm.DB, pmService *PaymentMethodService, ledger *LedgerService) *TransactionService {
 	return &TransactionService{
 		DB:                   db,
 		PaymentMethodService: pmService,
+		Ledger:               ledger,
+		AuthorizationTTL:     DefaultAuthorizationTTL,
+		CollectRequestTTL:    DefaultCollectRequestTTL,
+		ChequeClearingPeriod: DefaultChequeClearingPeriod,
+		StuckAfter:           DefaultStuckTransactionAfter,
+		Sagas:                NewSagaOrchestrator(db),
+		Recovery:             &RecoveryMetrics{},
 	}
 }
 
-func (svc *TransactionService) InitializeTransaction(ctx context.Context, payerID, payeeID string, amount float64, transactionType, status string, reservedAmount float64, paymentMethodID string, paymentDetail model.PaymentDetails) (*model.Transaction, error) {
+func (svc *TransactionService) InitializeTransaction(ctx context.Context, payerID, payeeID string, amount model.Money, transactionType string, reservedAmount model.Money, paymentMethodID string, paymentDetail model.PaymentDetails, idempotencyKey string) (_ *model.Transaction, err error) {
+	// Refunds are linked to their original transaction and go through RefundTransaction
+	if strings.EqualFold(transactionType, "Refund") {
+		return nil, errors.New("refunds must be created with POST /transactions/{id}/refunds")
+	}
 
-	// err := svc.DB.Transaction(func(tx *gorm.DB) error {
+	// Steps 1-7: Validate the request and build the transaction record
+	transaction, paymentMethod, err := svc.buildTransaction(ctx, payerID, payeeID, amount, transactionType, reservedAmount, paymentMethodID, paymentDetail, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	transactionID := transaction.TransactionID
 
-	// 	var payer model.Payer
-	// 	if err := svc.DB.First(&payer, "PayerID = ?", payerID).Error; err != nil {
-	// 		return err
-	// 	}
+	// Cheques settle through clearing rather than straight away
+	if transactionType == "Debit" && paymentMethod.MethodType == "cheque" {
+		if _, err := svc.DepositCheque(ctx, transaction, paymentDetail.Cheque); err != nil {
+			return nil, err
+		}
+		return transaction, nil
+	}
 
-	// 	// Step 3: Check if the payee exists
-	// 	var payee model.Payee
-	// 	if err := svc.DB.First(&payee, "PayeeID = ?", payeeID).Error; err != nil {
-	// 		return err
-	// 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		auditLog := model.AuditLog{
+			AuditLogID:    utils.GenerateUniqueID(),
+			TransactionID: transactionID,
+			Action:        "Transaction Process Failed",
+			Details:       err.Error(),
+			CreatedAt:     time.Now(),
+		}
+		if err := svc.DB.Create(&auditLog).Error; err != nil {
+			fmt.Printf("Failed to log audit entry: %v\n", err)
+		}
+	}()
+	// Steps 8-11: create the transaction, check the balance, reserve the
+	// funds, process the payment and complete it. They run as a saga so a
+	// failure part way, or a restart, never leaves funds reserved.
+	saga, err := svc.Sagas.Start(ctx, model.SagaKindPayment, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.Sagas.Run(ctx, saga, svc.paymentSagaSteps(transaction)); err != nil {
+		return nil, err
+	}
 
-	// 	// return nil will commit the whole transaction
-	// 	return nil
-	// })
+	return transaction, nil
+}
 
-	/***********************/
+// buildTransaction validates a payment request (payer, payment method, payee
+// and payload) and returns an unsaved Pending transaction along with the
+// payment method it resolved.
+func (svc *TransactionService) buildTransaction(ctx context.Context, payerID, payeeID string, amount model.Money, transactionType string, reservedAmount model.Money, paymentMethodID string, paymentDetail model.PaymentDetails, idempotencyKey string) (*model.Transaction, *model.PaymentMethod, error) {
+	// Amounts without a currency are taken to be in the default currency
+	if amount.Currency == "" {
+		amount.Currency = model.DefaultCurrency
+	}
+	if err := amount.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid amount: %v", err)
+	}
 
 	// Step 1: Check if the payer exists
 	var payer model.Payer
 	if err := svc.DB.First(&payer, "PayerID = ?", payerID).Error; err != nil {
-		return nil, fmt.Errorf("payer with PayerID %s does not exist", payerID)
+		return nil, nil, fmt.Errorf("payer with PayerID %s does not exist", payerID)
 	}
 
-	// if transactionType == "Debit" {
-		// Step 2: Fetch and validate the payment method
-		paymentMethod, err := svc.GetPaymentMethodByPayerID(ctx, payerID)
-		if err != nil {
-			return nil, fmt.Errorf("no valid payment method found for payer: %v", err)
-		}
+	// Step 2: Fetch and validate the payment method
+	paymentMethod, err := svc.ResolvePaymentMethod(ctx, payerID, paymentMethodID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no valid payment method found for payer: %v", err)
+	}
+	paymentMethodID = paymentMethod.PaymentMethodID
 
-		if paymentMethod.Status != "active" {
-			return nil, errors.New("payment method is not active")
-		}
-		// }
+	if paymentMethod.Status != model.PaymentMethodActive {
+		return nil, nil, errors.New("payment method is not active")
+	}
 
-		// fmt.Println("Reached Here!", paymentDetail.CardNumber, paymentDetail.CVV, paymentDetail.ExpiryDate)
-		// fmt.Println("paymentMethod.CardNumber", paymentMethod.CardNumber, paymentMethod.ExpiryDate, paymentMethod.MethodType, paymentMethod.PaymentMethodID)
-		// if transactionType == "Debit" {
-		errPaymentMethod := svc.ValidatePaymentDetails(paymentMethod, paymentDetail)
-		if errPaymentMethod != nil {
-			return nil, fmt.Errorf("no valid payment method found for payer: %v", errPaymentMethod)
-		}
-	// }
+	errPaymentMethod := svc.ValidatePaymentDetails(paymentMethod, paymentDetail)
+	if errPaymentMethod != nil {
+		return nil, nil, fmt.Errorf("no valid payment method found for payer: %v", errPaymentMethod)
+	}
 
 	// Step 3: Check if the payee exists
 	var payee model.Payee
 	if err := svc.DB.First(&payee, "PayeeID = ?", payeeID).Error; err != nil {
-		return nil, fmt.Errorf("payee with PayeeID %s does not exist", payeeID)
+		return nil, nil, fmt.Errorf("payee with PayeeID %s does not exist", payeeID)
 	}
 
 	// Step 4: Validate that payer and payee are not the same
 	if payerID == payeeID {
-		return nil, errors.New("payer cannot pay themselves")
+		return nil, nil, errors.New("payer cannot pay themselves")
 	}
 
 	// Step 5: Validate the transaction payload
 	transactionID := utils.GenerateUniqueID()
 	if err := validateTransactionPayload(transactionID, payerID, payeeID, amount, transactionType, paymentMethodID); err != nil {
-		return nil, fmt.Errorf("invalid transaction payload: %v", err)
+		return nil, nil, fmt.Errorf("invalid transaction payload: %v", err)
 	}
 
 	// Step 6: Check for duplicate transaction
-	if err := svc.CheckDuplicateTransaction(transactionID); err != nil {
-		return nil, fmt.Errorf("duplicate transaction: %v", err)
+	if err := svc.CheckDuplicateTransaction(payerID, idempotencyKey); err != nil {
+		return nil, nil, fmt.Errorf("duplicate transaction: %v", err)
 	}
 
-	// Step 7: Create the transaction record
-	transaction := &model.Transaction{
+	// Step 7: Build the transaction record
+	return &model.Transaction{
 		TransactionID:   transactionID,
 		PayerID:         payerID,
 		PayeeID:         payeeID,
 		Amount:          amount,
 		TransactionType: transactionType,
-		Status:          "Pending",
 		ReservedAmount:  reservedAmount,
 		PaymentMethodID: paymentMethodID,
+		IdempotencyKey:  idempotencyKey,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
-	}
-	defer func() {
-		auditLog := model.AuditLog{
-			AuditLogID:    utils.GenerateUniqueID(),
-			TransactionID: transactionID,
-			Action:        "Transaction Process Failed",
-			Details:       "Failed",
-			CreatedAt:     time.Now(),
-		}
-		if err := svc.DB.Create(&auditLog).Error; err != nil {
-			fmt.Printf("Failed to log audit entry: %v\n", err)
-		}
-	}()
-	if err := svc.DB.Create(transaction).Error; err != nil {
-		// svc.logAudit(transaction.TransactionID, "Create T", "Trasaction failed")
-		return nil, fmt.Errorf("failed to create transaction: %v", err)
-	}
-
-	if transactionType == "Debit" {
-		// Step 8: Check the payer's balance
-		if err := svc.CheckBalance(ctx, transaction); err != nil {
-			return nil, fmt.Errorf("balance check failed: %v", err)
-		}
-	}
-
-	// // Step 9: Reserve the funds
-	// if err := svc.ReserveFunds(ctx, transaction); err != nil {
-	// 	return nil, fmt.Errorf("failed to reserve funds: %v", err)
-	// }
-
-	if transaction.TransactionType == "Debit" {
-		// 	// Skip reserving funds for refunds
-		// 	// You can directly proceed to reverse balances
-		// Step 9: Reserve the funds
-		if err := svc.ReserveFunds(ctx, transaction); err != nil {
-			return nil, fmt.Errorf("failed to reserve funds: %v", err)
-		}
-	}
-
-	// Step 10: Process the payment
-	if err := svc.ProcessPayment(ctx, transaction); err != nil {
-
-		return nil, fmt.Errorf("payment processing failed: %v", err)
-	}
-
-	// Step 11: Complete the transaction
-	if err := svc.CompleteTransaction(transaction.TransactionID, svc.DB); err != nil {
-
-		return nil, fmt.Errorf("failed to complete transaction: %v", err)
-	}
-
-	return transaction, nil
+	}, paymentMethod, nil
 }
 
 func (svc *TransactionService) ValidatePaymentDetails(paymentMethod *model.PaymentMethod, paymentDetail model.PaymentDetails) error {
 	switch paymentMethod.MethodType {
 	case "card":
 
-		if paymentMethod.CardNumber != paymentDetail.CardNumber {
+		if paymentMethod.Fingerprint != svc.PaymentMethodService.Vault.Fingerprint(model.VaultKindCardNumber, card.Normalize(paymentDetail.CardNumber)) {
 			return errors.New("payment method is not correct - card number")
 		}
-		// if paymentMethod.cvv != paymentDetail.PaymentDetails.CVV {
-		// 	return nil, errors.New("payment method is not correct cvv")
-		// }
+		if err := card.ValidateCVV(card.Brand(paymentMethod.Brand), paymentDetail.CVV); err != nil {
+			return errors.New("payment method is not correct - cvv")
+		}
 		if paymentMethod.ExpiryDate != paymentDetail.ExpiryDate {
 			return errors.New("payment method is not correct - expiry date")
 		}
+		if err := card.CheckExpiry(paymentMethod.ExpiryDate, time.Now()); err != nil {
+			return err
+		}
 	case "bank_transfer":
 		// Validate AccountNumber for bank transfer
-		if paymentMethod.AccountNumber != paymentDetail.CardNumber {
+		if paymentMethod.Fingerprint != svc.PaymentMethodService.Vault.Fingerprint(model.VaultKindAccountNumber, paymentDetail.CardNumber) {
 			return errors.New("payment method is not correct - account number")
 		}
 
@@ -192,9 +198,12 @@ func (svc *TransactionService) ValidatePaymentDetails(paymentMethod *model.Payme
 		}
 
 	case "cheque":
-		// Validate for cheque method if required
-		if paymentMethod.Details != paymentDetail.Cheque {
-			return errors.New("payment method is not correct - cheque")
+		// The method is the chequebook; each payment names its own cheque
+		if paymentDetail.Cheque == "" {
+			return errors.New("cheque number is required")
+		}
+		if !utils.IsNumeric(paymentDetail.Cheque) {
+			return errors.New("cheque number must be numeric")
 		}
 
 	default:
@@ -204,9 +213,9 @@ func (svc *TransactionService) ValidatePaymentDetails(paymentMethod *model.Payme
 
 	return nil
 }
-func (svc *TransactionService) UpdatePayerBalance(ctx context.Context, payerID string, amount float64) error {
+func (svc *TransactionService) UpdatePayerBalance(ctx context.Context, payerID string, amount model.Money) error {
 	// Validate the amount to prevent invalid updates
-	if amount == 0 {
+	if amount.IsZero() {
 		return errors.New("amount must not be zero")
 	}
 
@@ -214,51 +223,38 @@ func (svc *TransactionService) UpdatePayerBalance(ctx context.Context, payerID s
 	return svc.DB.Transaction(func(tx *gorm.DB) error {
 		// Fetch the payer record
 		var payer model.Payer
-		if err := tx.First(&payer, "payer_id = ?", payerID).Error; err != nil {
+		if err := tx.First(&payer, "PayerID = ?", payerID).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				return fmt.Errorf("payer with ID %s not found", payerID)
 			}
 			return fmt.Errorf("error fetching payer: %v", err)
 		}
 
-		// Update the payer's balance
-		newBalance := payer.Balance + amount
-		if newBalance < 0 {
-			return errors.New("insufficient funds for balance update")
+		// Post the adjustment against the system adjustments account
+		from, to := model.SystemAccount(model.LedgerSystemAdjustments), model.PayerAccount(payerID)
+		if amount.IsNegative() {
+			from, to, amount = to, from, amount.Neg()
 		}
-
-		payer.Balance = newBalance
-
-		// Save the updated balance
-		if err := tx.Save(&payer).Error; err != nil {
+		if _, err := svc.Ledger.Transfer(tx, "", model.JournalEntryAdjustment, "Payer balance adjustment", from, to, amount); err != nil {
+			if errors.Is(err, ErrInsufficientFunds) {
+				return errors.New("insufficient funds for balance update")
+			}
 			return fmt.Errorf("failed to update payer's balance: %v", err)
 		}
 
-		// Optionally log the balance update
-		// logEntry := model.AuditLog{
-		// 	LogID:      utils.GenerateUniqueID(),
-		// 	PayerID:    payerID,
-		// 	Amount:     amount,
-		// 	NewBalance: newBalance,
-		// 	CreatedAt:  time.Now(),
-		// }
-		// if err := tx.Create(&logEntry).Error; err != nil {
-		// 	return fmt.Errorf("failed to log balance update: %v", err)
-		// }
-
 		return nil
 	})
 }
 
-func (svc *TransactionService) DepositToPayer(ctx context.Context, payerID string, amount float64) error {
+func (svc *TransactionService) DepositToPayer(ctx context.Context, payerID string, amount model.Money) error {
 	// Validate the deposit amount
-	if amount <= 0 {
+	if !amount.IsPositive() {
 		return errors.New("deposit amount must be greater than zero")
 	}
 
 	// Fetch the payer record
 	var payer model.Payer
-	if err := svc.DB.First(&payer, "payer_id = ?", payerID).Error; err != nil {
+	if err := svc.DB.First(&payer, "PayerID = ?", payerID).Error; err != nil {
 		return fmt.Errorf("payer with ID %s not found: %v", payerID, err)
 	}
 
@@ -269,36 +265,44 @@ func (svc *TransactionService) DepositToPayer(ctx context.Context, payerID strin
 		PayeeID:         "", // No payee for deposit
 		Amount:          amount,
 		TransactionType: "Deposit",
-		Status:          "Completed",
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
 
-	// Save the deposit transaction
-	if err := svc.DB.Create(depositTransaction).Error; err != nil {
-		return fmt.Errorf("failed to create deposit transaction: %v", err)
-	}
+	return svc.DB.Transaction(func(tx *gorm.DB) error {
+		// Save the deposit transaction
+		if err := svc.createTransaction(tx, depositTransaction, model.StatusCompleted, "Deposit to payer"); err != nil {
+			return fmt.Errorf("failed to create deposit transaction: %v", err)
+		}
 
-	// Update the payer's balance
-	payer.Balance += amount
-	if err := svc.DB.Save(&payer).Error; err != nil {
-		return fmt.Errorf("failed to update payer's balance: %v", err)
-	}
+		// Credit the payer from the external funding account
+		if _, err := svc.Ledger.Transfer(tx, depositTransaction.TransactionID, model.JournalEntryDeposit, "Deposit to payer",
+			model.SystemAccount(model.LedgerSystemFunding), model.PayerAccount(payerID), amount); err != nil {
+			return fmt.Errorf("failed to update payer's balance: %v", err)
+		}
 
-	return nil
+		return nil
+	})
 }
 
-func (svc *TransactionService) GetPaymentMethodByPayerID(ctx context.Context, payerID string) (*model.PaymentMethod, error) {
+// ResolvePaymentMethod returns the payment method a payment is made with: the
+// requested one, which must belong to the payer, or the payer's default when
+// none is given.
+func (svc *TransactionService) ResolvePaymentMethod(ctx context.Context, payerID, paymentMethodID string) (*model.PaymentMethod, error) {
 	var paymentMethod model.PaymentMethod
-	err := svc.DB.Where("payer_id = ? AND status = ?", payerID, "active").First(&paymentMethod).Error
+	var err error
+	if paymentMethodID != "" {
+		paymentMethod, err = svc.PaymentMethodService.GetPaymentMethod(payerID, paymentMethodID)
+	} else {
+		paymentMethod, err = svc.PaymentMethodService.GetDefaultPaymentMethod(payerID)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("no valid payment method found for payer: %v", err)
+		return nil, err
 	}
-	fmt.Println("payment method - 1 : ", &paymentMethod)
 	return &paymentMethod, nil
 }
 
-func validateTransactionPayload(transaction_id string, payerID string, payeeID string, amount float64, transactionType, paymentMethodID string) error {
+func validateTransactionPayload(transaction_id string, payerID string, payeeID string, amount model.Money, transactionType, paymentMethodID string) error {
 	// if transaction_id == "" || payerID == "" || payeeID == "" ||
 	// 	amount <= 0 || transactionType == "" || paymentMethodID == "" {
 	// 	return errors.New("missing required fields or invalid data")
@@ -307,7 +311,7 @@ func validateTransactionPayload(transaction_id string, payerID string, payeeID s
 	if transactionType != "Debit" && transactionType != "Credit" && transactionType != "Refund" && transactionType != "debit" && transactionType != "credit" && transactionType != "refund" {
 		return errors.New("invalid transaction type")
 	}
-	if transactionType == "Debit" && (transaction_id == "" || payerID == "" || payeeID == "" || amount <= 0 || transactionType == "") {
+	if transactionType == "Debit" && (transaction_id == "" || payerID == "" || payeeID == "" || !amount.IsPositive() || transactionType == "") {
 		if paymentMethodID == "" {
 			return errors.New("missing required fields or invalid data")
 		}
@@ -315,17 +319,22 @@ func validateTransactionPayload(transaction_id string, payerID string, payeeID s
 	return nil
 }
 
-func (svc *TransactionService) CheckDuplicateTransaction(transactionID string) error {
+// CheckDuplicateTransaction rejects a second transaction created by the same
+// payer with the same idempotency key. Requests without a key are not checked.
+func (svc *TransactionService) CheckDuplicateTransaction(payerID, idempotencyKey string) error {
+	if idempotencyKey == "" {
+		return nil
+	}
 	var existingTransaction model.Transaction
-	if err := svc.DB.First(&existingTransaction, "transaction_id = ?", transactionID).Error; err == nil {
-		return errors.New("duplicate transaction ID")
+	if err := svc.DB.First(&existingTransaction, "payer_id = ? AND idempotency_key = ?", payerID, idempotencyKey).Error; err == nil {
+		return fmt.Errorf("transaction %s already created with this idempotency key", existingTransaction.TransactionID)
 	}
 	return nil
 }
 func (svc *TransactionService) VerifyPaymentMethod(ctx context.Context, transaction *model.Transaction) error {
-	paymentMethod, err := svc.PaymentMethodService.ValidatePaymentMethod(transaction.PaymentMethodID)
-	if err != nil || paymentMethod.Status != "active" {
-		_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, "Failed")
+	paymentMethod, err := svc.PaymentMethodService.ValidatePaymentMethod(transaction.PayerID, transaction.PaymentMethodID)
+	if err != nil || paymentMethod.Status != model.PaymentMethodActive {
+		_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, model.StatusFailed, "Invalid or inactive payment method")
 		return errors.New("invalid or inactive payment method")
 	}
 	return nil
@@ -336,8 +345,12 @@ func (svc *TransactionService) CheckBalance(ctx context.Context, transaction *mo
 		// svc.logAudit(transaction.TransactionID, "Check balance", "Trasaction failed")
 		return errors.New("payer not found")
 	}
-	if payer.Balance < transaction.Amount {
-		_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, "Failed")
+	insufficient, err := payer.Balance.LessThan(transaction.Amount)
+	if err != nil {
+		return err
+	}
+	if insufficient {
+		_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, model.StatusFailed, "Insufficient funds")
 		return errors.New("insufficient funds")
 	}
 	return nil
@@ -348,16 +361,18 @@ func (svc *TransactionService) ReserveFunds(ctx context.Context, transaction *mo
 		if err := tx.First(&payer, "PayerID = ?", transaction.PayerID).Error; err != nil {
 			return errors.New("payer not found")
 		}
-		if payer.Balance < transaction.Amount {
-			_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, "Failed")
-			return errors.New("insufficient funds")
+		// Move the amount from the payer's available balance into their hold account
+		if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryReserve, "Reserve funds",
+			model.PayerAccount(payer.PayerID), model.PayerHoldAccount(payer.PayerID), transaction.Amount); err != nil {
+			if errors.Is(err, ErrInsufficientFunds) {
+				_ = svc.UpdateTransactionStatus(ctx, transaction.TransactionID, model.StatusFailed, "Insufficient funds")
+				return errors.New("insufficient funds")
+			}
+			return err
 		}
-		payer.Balance -= transaction.Amount
-		if err := tx.Save(&payer).Error; err != nil {
+		if err := transitionStatus(tx, transaction, model.StatusReserved, "Funds reserved"); err != nil {
 			return err
 		}
-		fmt.Println("transaction - Status", transaction.Status)
-		transaction.Status = "Reserved"
 		transaction.ReservedAmount = transaction.Amount
 		return tx.Save(transaction).Error
 	})
@@ -370,27 +385,44 @@ func (svc *TransactionService) RollbackReservation(ctx context.Context, transact
 		}
 
 		// Add back the reserved amount
-		payer.Balance += transaction.ReservedAmount
-		if err := tx.Save(&payer).Error; err != nil {
-			return fmt.Errorf("failed to rollback reservation: %v", err)
+		if transaction.ReservedAmount.IsPositive() {
+			if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryRelease, "Rollback reservation",
+				model.PayerHoldAccount(payer.PayerID), model.PayerAccount(payer.PayerID), transaction.ReservedAmount); err != nil {
+				return fmt.Errorf("failed to rollback reservation: %v", err)
+			}
 		}
 
-		transaction.Status = "Failed"
-		transaction.ReservedAmount = 0
+		if err := transitionStatus(tx, transaction, model.StatusFailed, "Reservation rolled back"); err != nil {
+			return err
+		}
+		transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
 		return tx.Save(transaction).Error
 	})
 }
 
 func (svc *TransactionService) ProcessPayment(ctx context.Context, transaction *model.Transaction) error {
-	return svc.DB.Transaction(func(tx *gorm.DB) error {
-		// Ensure the reserved funds are rolled back on failure
-		defer func() {
-			if r := recover(); r != nil {
-				transaction.Status = "Failed"
-				svc.RollbackReservation(ctx, transaction)
+	// Debits funded by a processor are collected before the ledger pays the
+	// payee; wallet debits were reserved from the payer's balance instead
+	funded := false
+	if transaction.TransactionType == "Debit" {
+		var err error
+		if funded, err = svc.fundedByProcessor(svc.DB.WithContext(ctx), transaction); err != nil {
+			return err
+		}
+	}
+	if funded {
+		if err := svc.authorizeWithProcessor(ctx, transaction); err != nil {
+			return err
+		}
+		if err := svc.captureWithProcessor(ctx, transaction, transaction.Amount); err != nil {
+			if voidErr := svc.voidWithProcessor(ctx, transaction); voidErr != nil {
+				log.Printf("Failed to void payment for transaction %s: %v", transaction.TransactionID, voidErr)
 			}
-		}()
+			return err
+		}
+	}
 
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
 		switch transaction.TransactionType {
 		case "Debit":
 			var payer model.Payer
@@ -406,20 +438,14 @@ func (svc *TransactionService) ProcessPayment(ctx context.Context, transaction *
 				return errors.New("payee not found")
 			}
 
-			// // Check for sufficient balance
-			// if payer.Balance < transaction.Amount {
-			// 	return errors.New("insufficient funds")
-			// }
-
-			// Update balances
-			// payer.Balance -= transaction.Amount
-			payee.Balance += transaction.Amount
-
-			// Save updated records
-			if err := tx.Save(&payer).Error; err != nil {
-				return err
+			// Settle the reserved funds from the payer's hold account to the
+			// payee, or from the settlement account when the processor collected them
+			from := model.PayerHoldAccount(payer.PayerID)
+			if funded {
+				from = model.SystemAccount(model.LedgerSystemSettlement)
 			}
-			if err := tx.Save(&payee).Error; err != nil {
+			if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryPayment, "Payment to payee",
+				from, model.PayeeAccount(payee.PayeeID), transaction.Amount); err != nil {
 				return err
 			}
 
@@ -429,55 +455,21 @@ func (svc *TransactionService) ProcessPayment(ctx context.Context, transaction *
 				return errors.New("payee not found")
 			}
 
-			// Update balance
-			payee.Balance += transaction.Amount
-			if err := tx.Save(&payee).Error; err != nil {
+			// Credits come from outside the platform, so they are funded by the settlement account
+			if _, err := svc.Ledger.Transfer(tx, transaction.TransactionID, model.JournalEntryCredit, "Credit to payee",
+				model.SystemAccount(model.LedgerSystemSettlement), model.PayeeAccount(payee.PayeeID), transaction.Amount); err != nil {
 				return err
 			}
 
 		case "Refund":
 			// Validate original transaction
 			var originalTransaction model.Transaction
-			if err := tx.First(&originalTransaction, "transaction_id = ?", transaction.TransactionID).Error; err != nil {
+			if err := tx.First(&originalTransaction, "transaction_id = ?", transaction.OriginalTransactionID).Error; err != nil {
 				return errors.New("original transaction not found")
 			}
 
-			fmt.Println("originalTransaction Status ", originalTransaction.Status, " - for - ", originalTransaction.TransactionID, "- Transaction type - ", originalTransaction.TransactionType)
-			// Ensure the original transaction was completed
-			// if originalTransaction.Status != "Completed" {
-			// 	return errors.New("refund not allowed for incomplete transactions")
-			// }
-
-			// Fetch payer and payee from the original transaction
-			var payer model.Payer
-			var payee model.Payee
-
-			if err := tx.First(&payer, "PayerID = ?", originalTransaction.PayerID).Error; err != nil {
-				return errors.New("payer not found")
-			}
-			if err := tx.First(&payee, "PayeeID = ?", originalTransaction.PayeeID).Error; err != nil {
-				return errors.New("payee not found")
-			}
-
-			// Reverse balances
-			if payee.Balance <= 0 && payee.Balance < originalTransaction.Amount {
-				return errors.New("insufficient funds in payee account for refund")
-			}
-
-			payer.Balance += originalTransaction.Amount
-			payee.Balance -= originalTransaction.Amount
-
-			// Save updated records
-			if err := tx.Save(&payer).Error; err != nil {
-				return err
-			}
-			if err := tx.Save(&payee).Error; err != nil {
-				return err
-			}
-
-			// Mark original transaction as refunded
-			originalTransaction.Status = "Refunded"
-			if err := tx.Save(&originalTransaction).Error; err != nil {
+			// Reverse balances and update the original's refunded total
+			if err := svc.applyRefund(tx, transaction, &originalTransaction, model.PayeeAccount(originalTransaction.PayeeID), "Refund to payer"); err != nil {
 				return err
 			}
 
@@ -486,80 +478,36 @@ func (svc *TransactionService) ProcessPayment(ctx context.Context, transaction *
 		}
 
 		// Mark transaction as completed
-		transaction.ReservedAmount = 0
-		transaction.Status = "Completed"
+		transaction.ReservedAmount = model.ZeroMoney(transaction.Amount.Currency)
+		if err := transitionStatus(tx, transaction, model.StatusCompleted, "Payment processed"); err != nil {
+			return err
+		}
 		return tx.Save(transaction).Error
 	})
+	if err != nil && funded {
+		// The processor has collected the money the ledger failed to pay on
+		if refundErr := svc.refundWithProcessor(ctx, transaction, transaction.Amount); refundErr != nil {
+			log.Printf("Failed to refund payment for transaction %s: %v", transaction.TransactionID, refundErr)
+		}
+	}
+	return err
 }
-func RefundTransaction(transactionID string, db *gorm.DB) error {
-	// Start a database transaction
-	return db.Transaction(func(tx *gorm.DB) error {
-		// Fetch the transaction
+func (svc *TransactionService) CompleteTransaction(transactionID string, db *gorm.DB) error {
+	// Update transaction status; ProcessPayment usually completed it already
+	err := db.Transaction(func(tx *gorm.DB) error {
 		var transaction model.Transaction
 		if err := tx.First(&transaction, "transaction_id = ?", transactionID).Error; err != nil {
-			return fmt.Errorf("transaction not found: %w", err)
-		}
-
-		// Check if the transaction is already refunded
-		if transaction.Status == "Refunded" {
-			return fmt.Errorf("transaction already refunded")
-		}
-
-		// Fetch the payee
-		var payee model.Payee
-		if err := tx.First(&payee, "payee_id = ?", transaction.PayeeID).Error; err != nil {
-			return fmt.Errorf("payee not found: %w", err)
-		}
-
-		// Fetch the payer
-		var payer model.Payer
-		if err := tx.First(&payer, "payer_id = ?", transaction.PayerID).Error; err != nil {
-			return fmt.Errorf("payer not found: %w", err)
-		}
-
-		// Check if the payee has sufficient balance for the refund
-		if payee.Balance < transaction.Amount {
-			return fmt.Errorf("insufficient balance in payee account for refund")
-		}
-
-		// Perform the refund by adjusting balances
-		payee.Balance -= transaction.Amount
-		payer.Balance += transaction.Amount
-
-		// Save updated balances
-		if err := tx.Save(&payee).Error; err != nil {
-			return fmt.Errorf("failed to update payee balance: %w", err)
-		}
-
-		if err := tx.Save(&payer).Error; err != nil {
-			return fmt.Errorf("failed to update payer balance: %w", err)
-		}
-
-		// Update the transaction status to refunded
-		if err := tx.Model(&transaction).Update("status", "Refunded").Error; err != nil {
-			return fmt.Errorf("error updating transaction status to refunded: %w", err)
+			return err
 		}
-
-		// Add entry to the audit log
-		auditLog := model.AuditLog{
-			AuditLogID:    utils.GenerateUniqueID(),
-			TransactionID: transactionID,
-			Action:        "Transaction success",
-			Details:       "Transaction refunded successfully",
-			CreatedAt:     time.Now(),
+		if transaction.Status == model.StatusCompleted {
+			return nil
 		}
-		if err := tx.Create(&auditLog).Error; err != nil {
-			return fmt.Errorf("error inserting audit log: %w", err)
+		if err := transitionStatus(tx, &transaction, model.StatusCompleted, "Transaction completed"); err != nil {
+			return err
 		}
-
-		return nil
+		return tx.Model(&transaction).Update("status", transaction.Status).Error
 	})
-}
-
-func (svc *TransactionService) CompleteTransaction(transactionID string, db *gorm.DB) error {
-	// Update transaction status
-	if err := db.Model(&model.Transaction{}).Where("transaction_id = ?", transactionID).
-		Update("status", "Completed").Error; err != nil {
+	if err != nil {
 		return fmt.Errorf("error completing transaction: %w", err)
 	}
 
@@ -579,31 +527,72 @@ func (svc *TransactionService) CompleteTransaction(transactionID string, db *gor
 	return nil
 }
 
-func (svc *TransactionService) GetTransactionByID(ctx context.Context, transactionID string) (*model.Transaction, error) {
-	var transaction model.Transaction
-	if err := svc.DB.Preload("Payer").Preload("Payee").Preload("PaymentMethod").First(&transaction, "transaction_id = ?", transactionID).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch transaction: %v", err)
+// UpdateTransactionStatus moves a transaction to a new status, enforcing the
+// transition table and recording the change in the status history.
+func (svc *TransactionService) UpdateTransactionStatus(ctx context.Context, transactionID string, status model.TransactionStatus, reason string) error {
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		var transaction model.Transaction
+		if err := tx.First(&transaction, "transaction_id = ?", transactionID).Error; err != nil {
+			return err
+		}
+		if err := transitionStatus(tx, &transaction, status, reason); err != nil {
+			return err
+		}
+		return tx.Model(&transaction).Updates(map[string]interface{}{
+			"status":     transaction.Status,
+			"updated_at": transaction.UpdatedAt,
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update transaction status: %v", err)
 	}
-	return &transaction, nil
+	return nil
 }
 
-// ListTransactions retrieves all transactions for a specific user as payer or payee
-func (svc *TransactionService) ListTransactions(ctx context.Context, userID string) ([]model.Transaction, error) {
-	var transactions []model.Transaction
-	if err := svc.DB.Where("payer_id = ? OR payee_id = ?", userID, userID).Find(&transactions).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch transactions: %v", err)
+// createTransaction inserts a new transaction in its initial status and
+// records the creation in the status history.
+func (svc *TransactionService) createTransaction(tx *gorm.DB, transaction *model.Transaction, status model.TransactionStatus, reason string) error {
+	transaction.Status = ""
+	if err := transitionStatus(tx, transaction, status, reason); err != nil {
+		return err
 	}
-	return transactions, nil
+	return tx.Create(transaction).Error
 }
 
-// UpdateTransactionStatus updates the status of a transaction
-func (svc *TransactionService) UpdateTransactionStatus(ctx context.Context, transactionID, status string) error {
-	if err := svc.DB.Model(&model.Transaction{}).Where("transaction_id = ?", transactionID).Update("status", status).Error; err != nil {
-		return fmt.Errorf("failed to update transaction status: %v", err)
+// transitionStatus validates a status move against the transition table,
+// records it in the status history and applies it to the in-memory
+// transaction. Callers are responsible for saving the transaction within tx.
+func transitionStatus(tx *gorm.DB, transaction *model.Transaction, status model.TransactionStatus, reason string) error {
+	if err := transaction.Status.ValidateTransition(status); err != nil {
+		return err
+	}
+
+	history := model.TransactionStatusHistory{
+		StatusHistoryID: utils.GenerateUniqueID(),
+		TransactionID:   transaction.TransactionID,
+		FromStatus:      transaction.Status,
+		ToStatus:        status,
+		Reason:          reason,
+		CreatedAt:       time.Now(),
 	}
+	if err := tx.Create(&history).Error; err != nil {
+		return fmt.Errorf("failed to record status history: %v", err)
+	}
+
+	transaction.Status = status
+	transaction.UpdatedAt = history.CreatedAt
 	return nil
 }
 
+// GetStatusHistory returns the status transitions of a transaction, oldest first.
+func (svc *TransactionService) GetStatusHistory(ctx context.Context, transactionID string) ([]model.TransactionStatusHistory, error) {
+	var history []model.TransactionStatusHistory
+	if err := svc.DB.Where("transaction_id = ?", transactionID).Order("created_at").Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch status history: %v", err)
+	}
+	return history, nil
+}
+
 // DeleteTransaction deletes a transaction by its ID
 func (svc *TransactionService) DeleteTransaction(ctx context.Context, transactionID string) error {
 	if err := svc.DB.Delete(&model.Transaction{}, "transaction_id = ?", transactionID).Error; err != nil {
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"poc/utils"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultCollectRequestTTL is how long a payer has to answer a UPI collect request.
+const DefaultCollectRequestTTL = 15 * time.Minute
+
+// ErrCollectRequestNotFound is returned for unknown collect requests and for
+// requests addressed to someone else.
+var ErrCollectRequestNotFound = errors.New("collect request not found")
+
+// RequestUPICollect lets a payee ask the owner of a UPI ID for money. A
+// Pending transaction is created straight away and settled when the payer
+// approves; it fails if they decline or let the request expire.
+func (svc *TransactionService) RequestUPICollect(ctx context.Context, payeeID, payerVPA string, amount model.Money, note, idempotencyKey string) (*model.UPICollectRequest, error) {
+	var paymentMethod model.PaymentMethod
+	err := svc.DB.First(&paymentMethod, "method_type = ? AND details = ? AND status = ?", "upi", payerVPA, model.PaymentMethodActive).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no payer has registered UPI ID %s", payerVPA)
+		}
+		return nil, fmt.Errorf("failed to look up UPI ID: %v", err)
+	}
+
+	transaction, _, err := svc.buildTransaction(ctx, paymentMethod.PayerID, payeeID, amount, "Debit", model.Money{}, paymentMethod.PaymentMethodID,
+		model.PaymentDetails{UPIID: payerVPA}, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var payee model.User
+	if err := svc.DB.First(&payee, "UserID = ?", payeeID).Error; err != nil {
+		return nil, errors.New("payee not found")
+	}
+
+	request := &model.UPICollectRequest{
+		CollectRequestID: utils.GenerateUniqueID(),
+		TransactionID:    transaction.TransactionID,
+		PayeeID:          payeeID,
+		PayerID:          paymentMethod.PayerID,
+		PayerVPA:         payerVPA,
+		PaymentMethodID:  paymentMethod.PaymentMethodID,
+		Amount:           transaction.Amount,
+		Note:             note,
+		Status:           model.CollectRequestPending,
+		ExpiresAt:        time.Now().Add(svc.CollectRequestTTL),
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := svc.createTransaction(tx, transaction, model.StatusPending, "UPI collect request sent"); err != nil {
+			return err
+		}
+		return tx.Create(request).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create collect request: %v", err)
+	}
+
+	reference, err := svc.PaymentMethodService.PSP.SendCollectRequest(ctx, CollectRequestNotice{
+		CollectRequestID: request.CollectRequestID,
+		PayerVPA:         payerVPA,
+		PayeeName:        strings.TrimSpace(payee.FirstName + " " + payee.LastName),
+		Amount:           request.Amount,
+		Note:             note,
+		ExpiresAt:        request.ExpiresAt,
+	})
+	if err != nil {
+		_ = svc.finishCollectRequest(request, model.CollectRequestFailed, "UPI Collect Failed", "UPI collect request could not be sent")
+		return nil, fmt.Errorf("failed to send collect request: %v", err)
+	}
+	request.PSPReference = reference
+	if err := svc.DB.Model(request).Update("psp_reference", reference).Error; err != nil {
+		return nil, fmt.Errorf("failed to save PSP reference: %v", err)
+	}
+	svc.logAudit(transaction.TransactionID, "UPI Collect Requested", fmt.Sprintf("Collect request %s sent to %s", request.CollectRequestID, payerVPA))
+	return request, nil
+}
+
+// ListUPICollectRequests returns the collect requests waiting for the payer.
+func (svc *TransactionService) ListUPICollectRequests(ctx context.Context, payerID string) ([]model.UPICollectRequest, error) {
+	var requests []model.UPICollectRequest
+	err := svc.DB.WithContext(ctx).
+		Where("payer_id = ? AND status = ? AND expires_at > ?", payerID, model.CollectRequestPending, time.Now()).
+		Order("created_at DESC").Find(&requests).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch collect requests: %v", err)
+	}
+	return requests, nil
+}
+
+// GetUPICollectRequest returns a collect request addressed to the payer.
+func (svc *TransactionService) GetUPICollectRequest(ctx context.Context, payerID, collectRequestID string) (*model.UPICollectRequest, error) {
+	var request model.UPICollectRequest
+	if err := svc.DB.WithContext(ctx).First(&request, "collect_request_id = ? AND payer_id = ?", collectRequestID, payerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCollectRequestNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch collect request: %v", err)
+	}
+	return &request, nil
+}
+
+// RespondToUPICollect records the payer's answer. Approving runs the payment
+// through the same saga as any other debit; declining fails the transaction.
+func (svc *TransactionService) RespondToUPICollect(ctx context.Context, payerID, collectRequestID string, approve bool) (*model.UPICollectRequest, *model.Transaction, error) {
+	request, err := svc.GetUPICollectRequest(ctx, payerID, collectRequestID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if request.Status != model.CollectRequestPending {
+		return nil, nil, fmt.Errorf("collect request is already %s", request.Status)
+	}
+	if !time.Now().Before(request.ExpiresAt) {
+		_ = svc.finishCollectRequest(request, model.CollectRequestExpired, "UPI Collect Expired", "UPI collect request expired")
+		return nil, nil, errors.New("collect request has expired")
+	}
+
+	if !approve {
+		if err := svc.finishCollectRequest(request, model.CollectRequestDeclined, "UPI Collect Declined", "Payer declined UPI collect request"); err != nil {
+			return nil, nil, err
+		}
+		var transaction model.Transaction
+		if err := svc.DB.First(&transaction, "transaction_id = ?", request.TransactionID).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch transaction: %v", err)
+		}
+		return request, &transaction, nil
+	}
+
+	// Claim the request first so a second approval cannot pay twice
+	now := time.Now()
+	result := svc.DB.Model(&model.UPICollectRequest{}).
+		Where("collect_request_id = ? AND status = ?", collectRequestID, model.CollectRequestPending).
+		Updates(map[string]interface{}{"status": model.CollectRequestApproved, "responded_at": now, "updated_at": now})
+	if result.Error != nil {
+		return nil, nil, fmt.Errorf("failed to approve collect request: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, errors.New("collect request was already answered")
+	}
+	request.Status, request.RespondedAt = model.CollectRequestApproved, &now
+
+	var transaction model.Transaction
+	if err := svc.DB.First(&transaction, "transaction_id = ?", request.TransactionID).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+	if err := svc.settleCollectedPayment(ctx, &transaction); err != nil {
+		svc.logAudit(transaction.TransactionID, "Transaction Process Failed", err.Error())
+		return request, &transaction, err
+	}
+	svc.logAudit(transaction.TransactionID, "UPI Collect Approved", fmt.Sprintf("Collect request %s approved", collectRequestID))
+	return request, &transaction, nil
+}
+
+// ExpireUPICollectRequests fails the transactions of collect requests the
+// payer did not answer in time. It returns how many were expired.
+func (svc *TransactionService) ExpireUPICollectRequests(ctx context.Context) (int, error) {
+	var requests []model.UPICollectRequest
+	err := svc.DB.WithContext(ctx).
+		Where("status = ? AND expires_at <= ?", model.CollectRequestPending, time.Now()).
+		Find(&requests).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch expired collect requests: %v", err)
+	}
+	expired := 0
+	for i := range requests {
+		if err := svc.finishCollectRequest(&requests[i], model.CollectRequestExpired, "UPI Collect Expired", "UPI collect request expired"); err != nil {
+			// One bad request must not hold up the rest
+			log.Printf("Failed to expire UPI collect request %s: %v", requests[i].CollectRequestID, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// settleCollectedPayment runs an approved collect request's transaction
+// through the payment saga. The transaction already exists, so the saga
+// starts past its create step; recovery still fails it if the saga is cut
+// off before the payment is processed.
+func (svc *TransactionService) settleCollectedPayment(ctx context.Context, transaction *model.Transaction) error {
+	saga, err := svc.Sagas.Start(ctx, model.SagaKindPayment, transaction.TransactionID, sagaStepCreate)
+	if err != nil {
+		return err
+	}
+	return svc.Sagas.Run(ctx, saga, svc.paymentSagaSteps(transaction))
+}
+
+// finishCollectRequest closes a pending collect request without payment and
+// fails its transaction.
+func (svc *TransactionService) finishCollectRequest(request *model.UPICollectRequest, status, action, reason string) error {
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		updates := map[string]interface{}{"status": status, "updated_at": now}
+		if status == model.CollectRequestDeclined {
+			updates["responded_at"] = now
+			request.RespondedAt = &now
+		}
+		result := tx.Model(&model.UPICollectRequest{}).
+			Where("collect_request_id = ? AND status = ?", request.CollectRequestID, model.CollectRequestPending).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("collect request was already answered")
+		}
+		request.Status = status
+
+		var transaction model.Transaction
+		if err := tx.First(&transaction, "transaction_id = ?", request.TransactionID).Error; err != nil {
+			return err
+		}
+		if err := transitionStatus(tx, &transaction, model.StatusFailed, reason); err != nil {
+			return err
+		}
+		return tx.Model(&transaction).Updates(map[string]interface{}{"status": transaction.Status, "updated_at": transaction.UpdatedAt}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close collect request %s: %v", request.CollectRequestID, err)
+	}
+	svc.logAudit(request.TransactionID, action, reason)
+	return nil
+}
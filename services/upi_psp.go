@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"poc/model"
+	"poc/utils"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrVPANotFound is returned when a UPI ID does not resolve to an account.
+var ErrVPANotFound = errors.New("UPI ID not found")
+
+// CollectRequestNotice is what the PSP forwards to the payer's UPI app.
+type CollectRequestNotice struct {
+	CollectRequestID string
+	PayerVPA         string
+	PayeeName        string
+	Amount           model.Money
+	Note             string
+	ExpiresAt        time.Time
+}
+
+// PSP is the UPI payment service provider. Production deployments plug in a
+// real PSP; the simulator below is for local development.
+type PSP interface {
+	// ResolveVPA returns the account holder's name for a UPI ID.
+	ResolveVPA(ctx context.Context, vpa string) (string, error)
+	// SendCollectRequest asks the payer's UPI app to approve a payment and
+	// returns the PSP's reference for it.
+	SendCollectRequest(ctx context.Context, notice CollectRequestNotice) (string, error)
+}
+
+// NewPSP returns the PSP named by kind. Only "simulator" exists so far.
+func NewPSP(kind string) (PSP, error) {
+	switch kind {
+	case "", "simulator":
+		return SimulatedPSP{}, nil
+	default:
+		return nil, fmt.Errorf("unknown UPI PSP %q", kind)
+	}
+}
+
+// SimulatedPSP resolves every well-formed UPI ID except those whose handle
+// starts with "unknown", deriving the name from the handle, and logs collect
+// requests instead of sending them.
+type SimulatedPSP struct{}
+
+// ResolveVPA turns "jane.doe@bank" into "Jane Doe".
+func (SimulatedPSP) ResolveVPA(ctx context.Context, vpa string) (string, error) {
+	if ok, _ := utils.ValidateUpi(vpa); !ok {
+		return "", ErrVPANotFound
+	}
+	handle := strings.ToLower(vpa[:strings.Index(vpa, "@")])
+	if strings.HasPrefix(handle, "unknown") {
+		return "", ErrVPANotFound
+	}
+
+	words := strings.FieldsFunc(handle, func(r rune) bool { return !unicode.IsLetter(r) })
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	if len(words) == 0 {
+		return "UPI User", nil
+	}
+	return strings.Join(words, " "), nil
+}
+
+// SendCollectRequest logs the request.
+func (SimulatedPSP) SendCollectRequest(ctx context.Context, notice CollectRequestNotice) (string, error) {
+	reference := "sim_" + utils.GenerateUniqueID()
+	log.Printf("Simulated UPI collect request %s: %s asks %s for %s (%q), expires %s, reference %s",
+		notice.CollectRequestID, notice.PayeeName, notice.PayerVPA, notice.Amount, notice.Note, notice.ExpiresAt.Format(time.RFC3339), reference)
+	return reference, nil
+}
@@ -3,21 +3,45 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"poc/model"
 	"poc/utils"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// ErrInvalidCredentials is returned when the email or password is wrong.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
 // UserService provides methods for user-related operations.
 type UserService struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Ledger *LedgerService
+	Tokens *TokenService
+	Mailer Mailer
+	// Throttle limits wrong current passwords per account, together with the
+	// login and MFA steps. Nil means no limit.
+	Throttle *LoginThrottle
+
+	PublicURL            string        // Base URL used in links sent to users
+	VerificationTokenTTL time.Duration // How long email verification links stay valid
+	PasswordResetTTL     time.Duration // How long password reset links stay valid
 }
 
 // NewUserService creates a new instance of UserService.
-func NewUserService(db *gorm.DB) *UserService {
-	return &UserService{DB: db}
+func NewUserService(db *gorm.DB, ledger *LedgerService, tokens *TokenService, mailer Mailer) *UserService {
+	return &UserService{
+		DB:                   db,
+		Ledger:               ledger,
+		Tokens:               tokens,
+		Mailer:               mailer,
+		PublicURL:            "http://localhost:8080",
+		VerificationTokenTTL: DefaultVerificationTokenTTL,
+		PasswordResetTTL:     DefaultPasswordResetTTL,
+	}
 }
 
 // CreateUser creates a new user in the database.
@@ -42,6 +66,7 @@ func (svc *UserService) CreateUser(ctx context.Context, email, password, firstNa
 		FirstName:    firstName,
 		LastName:     lastName,
 		IsVerified:   false,
+		Role:         model.RoleCustomer,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
@@ -57,7 +82,7 @@ func (svc *UserService) CreateUser(ctx context.Context, email, password, firstNa
 			PayerID: user.UserID,
 			Name:    user.FirstName + " " + user.LastName,
 			Email:   user.Email,
-			Balance: 0.0, // Initial balance
+			Balance: model.ZeroMoney(model.DefaultCurrency), // Initial balance
 			Status:  "active",
 		}
 		if err := svc.DB.Create(payer).Error; err != nil {
@@ -70,7 +95,7 @@ func (svc *UserService) CreateUser(ctx context.Context, email, password, firstNa
 			PayeeID: user.UserID,
 			Name:    user.FirstName + " " + user.LastName,
 			Email:   user.Email,
-			Balance: 0.0, // Initial balance
+			Balance: model.ZeroMoney(model.DefaultCurrency), // Initial balance
 			Status:  "active",
 		}
 		if err := svc.DB.Create(payee).Error; err != nil {
@@ -78,6 +103,11 @@ func (svc *UserService) CreateUser(ctx context.Context, email, password, firstNa
 		}
 	}
 
+	// Send the verification link; the user can ask for another if this fails
+	if err := svc.SendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.UserID, err)
+	}
+
 	// Return user and nil error
 	return user, nil
 }
@@ -89,29 +119,35 @@ func (svc *UserService) LoginUser(ctx context.Context, email, password string) (
 	// Fetch user by email
 	if err := svc.DB.Where("email = ?", email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid credentials")
+			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
 
 	// Compare the provided password with the stored hashed password
 	if !utils.CheckPasswordHash(password, user.PasswordHash) {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 
 	// Return the user if credentials are valid
 	return &user, nil
 }
 
-// LogoutUser handles user logout by invalidating the session (or token).
-// Note: This method doesn't have much logic for now, as session invalidation is handled in the controller.
-func (svc *UserService) LogoutUser(ctx context.Context) error {
-	// If you're using sessions or JWT, you'd invalidate here
-	// For example, clear a JWT from the context or database session, if applicable.
-	return nil
+// LogoutUser ends the caller's session, or every session of the user when
+// allSessions is set. The access token in use is revoked immediately.
+func (svc *UserService) LogoutUser(ctx context.Context, userID, sessionID, tokenID string, tokenExpiresAt time.Time, allSessions bool) error {
+	if allSessions {
+		if err := svc.Tokens.RevokeAllSessions(ctx, userID); err != nil {
+			return err
+		}
+	} else if err := svc.Tokens.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	return svc.Tokens.RevokeAccessToken(ctx, userID, tokenID, tokenExpiresAt)
 }
 
-// UpdateUser updates the details of a user in the database.
+// UpdateUser updates the details of a user in the database. Changing the
+// email clears verification and mails a link for the new address.
 func (svc *UserService) UpdateUser(ctx context.Context, userID, email, firstName, lastName string, isPayee bool, isPayer bool) error {
 	// Fetch the user by ID
 	var user model.User
@@ -122,6 +158,12 @@ func (svc *UserService) UpdateUser(ctx context.Context, userID, email, firstName
 		return err
 	}
 
+	// A new address has to be verified again
+	emailChanged := user.Email != email
+	if emailChanged {
+		user.IsVerified = false
+	}
+
 	// Update the user fields
 	user.Email = email
 	user.FirstName = firstName
@@ -135,51 +177,137 @@ func (svc *UserService) UpdateUser(ctx context.Context, userID, email, firstName
 		return err
 	}
 
+	if emailChanged {
+		if err := svc.SendVerificationEmail(ctx, &user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.UserID, err)
+		}
+	}
 	return nil
 }
 
-// UpdatePayer updates the balance of a payer in the database.
-func (svc *UserService) UpdatePayer(ctx context.Context, payerID string, balance float64) error {
-	// Fetch the payer by ID
-	var payer model.Payer
-	if err := svc.DB.First(&payer, "PayerID = ?", payerID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("payer not found")
+// UpdatePayer sets the balance of a payer by posting the difference to the
+// ledger. It is an admin operation: reason is required and recorded in the
+// journal and the audit log along with the admin who made the change.
+func (svc *UserService) UpdatePayer(ctx context.Context, payerID string, balance model.Money, reason, actorID string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("a reason is required for balance adjustments")
+	}
+	if balance.Currency == "" {
+		balance.Currency = model.DefaultCurrency
+	}
+	if err := balance.Validate(); err != nil {
+		return err
+	}
+
+	var previous model.Money
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		// Fetch the payer by ID
+		var payer model.Payer
+		if err := tx.First(&payer, "PayerID = ?", payerID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("payer not found")
+			}
+			return err
 		}
+		previous = payer.Balance
+
+		// Post the adjustment; the cached balance is updated by the ledger
+		return svc.Ledger.AdjustTo(tx, model.PayerAccount(payerID), payer.Balance, balance, "Payer balance set: "+reason)
+	})
+	if err != nil {
 		return err
 	}
 
-	// Update the payer balance
-	payer.Balance = balance
-	payer.UpdatedAt = time.Now()
+	recordAudit(svc.DB, payerID, actorID, "Payer Balance Adjusted", fmt.Sprintf("%s -> %s: %s", previous, balance, reason))
+	return nil
+}
 
-	// Save the changes
-	if err := svc.DB.Save(&payer).Error; err != nil {
+// UpdatePayee sets the balance of a payee by posting the difference to the
+// ledger. Like UpdatePayer it is an admin operation that requires a reason.
+func (svc *UserService) UpdatePayee(ctx context.Context, payeeID string, balance model.Money, reason, actorID string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("a reason is required for balance adjustments")
+	}
+	if balance.Currency == "" {
+		balance.Currency = model.DefaultCurrency
+	}
+	if err := balance.Validate(); err != nil {
+		return err
+	}
+
+	var previous model.Money
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		// Fetch the payee by ID
+		var payee model.Payee
+		if err := tx.First(&payee, "PayeeID = ?", payeeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("payee not found")
+			}
+			return err
+		}
+		previous = payee.Balance
+
+		// Post the adjustment; the cached balance is updated by the ledger
+		return svc.Ledger.AdjustTo(tx, model.PayeeAccount(payeeID), payee.Balance, balance, "Payee balance set: "+reason)
+	})
+	if err != nil {
 		return err
 	}
 
+	recordAudit(svc.DB, payeeID, actorID, "Payee Balance Adjusted", fmt.Sprintf("%s -> %s: %s", previous, balance, reason))
 	return nil
 }
 
-// UpdatePayee updates the balance of a payee in the database.
-func (svc *UserService) UpdatePayee(ctx context.Context, payeeID string, balance float64) error {
-	// Fetch the payee by ID
-	var payee model.Payee
-	if err := svc.DB.First(&payee, "PayeeID = ?", payeeID).Error; err != nil {
+// BootstrapAdmin makes the user with email an admin if there is no admin
+// yet, so the first admin can be created from configuration; later admins
+// are granted with SetRole. It reports whether the role was granted.
+func (svc *UserService) BootstrapAdmin(ctx context.Context, email string) (bool, error) {
+	var admins int64
+	if err := svc.DB.WithContext(ctx).Model(&model.User{}).Where("Role = ?", model.RoleAdmin).Count(&admins).Error; err != nil {
+		return false, fmt.Errorf("failed to count admins: %v", err)
+	}
+	if admins > 0 {
+		return false, nil
+	}
+
+	var user model.User
+	if err := svc.DB.WithContext(ctx).Where("Email = ?", email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("payee not found")
+			return false, fmt.Errorf("no user with email %s; sign up first", email)
 		}
-		return err
+		return false, err
+	}
+	if err := svc.SetRole(ctx, user.UserID, model.RoleAdmin, ""); err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	// Update the payee balance
-	payee.Balance = balance
-	payee.UpdatedAt = time.Now()
+// SetRole grants a user a role. A change of role logs the user out of every
+// session, so no token carrying the old role stays usable.
+func (svc *UserService) SetRole(ctx context.Context, userID, role, actorID string) error {
+	if !model.IsValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
 
-	// Save the changes
-	if err := svc.DB.Save(&payee).Error; err != nil {
+	var user model.User
+	if err := svc.DB.First(&user, "UserID = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user not found")
+		}
 		return err
 	}
+	previous := user.Role
+
+	if err := svc.DB.Model(&user).Updates(map[string]interface{}{"Role": role, "UpdatedAt": time.Now()}).Error; err != nil {
+		return fmt.Errorf("failed to update role: %v", err)
+	}
+	if previous != role {
+		if err := svc.Tokens.RevokeAllSessions(ctx, userID); err != nil {
+			return fmt.Errorf("role changed but sessions were not revoked: %v", err)
+		}
+	}
 
+	recordAudit(svc.DB, userID, actorID, "Role Changed", fmt.Sprintf("%s -> %s", previous, role))
 	return nil
 }
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"poc/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrVaultTokenNotFound is returned for tokens the vault does not know, or
+// that have been purged.
+var ErrVaultTokenNotFound = errors.New("vault token not found")
+
+// Vault keeps card and account numbers encrypted and hands out opaque tokens
+// in their place. Each value gets its own data key (envelope encryption), so
+// rotating the KMS key only means rewrapping data keys.
+type Vault struct {
+	DB             *gorm.DB
+	KMS            KMS
+	FingerprintKey []byte // HMAC key for fingerprints; see Fingerprint
+}
+
+// NewVault creates a new instance of Vault
+func NewVault(db *gorm.DB, kms KMS, fingerprintKey []byte) *Vault {
+	return &Vault{DB: db, KMS: kms, FingerprintKey: fingerprintKey}
+}
+
+// Tokenize encrypts value and returns the token that stands for it.
+func (v *Vault) Tokenize(ctx context.Context, tx *gorm.DB, payerID, kind, value string) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate vault token: %v", err)
+	}
+	token = "vt_" + token
+
+	entry, err := v.seal(ctx, token, payerID, kind, value)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.WithContext(ctx).Create(entry).Error; err != nil {
+		return "", fmt.Errorf("failed to store vault entry: %v", err)
+	}
+	return token, nil
+}
+
+// Detokenize returns the value behind token. Only code that has to hand the
+// number to a bank or card network should call it.
+func (v *Vault) Detokenize(ctx context.Context, token string) (string, error) {
+	var entry model.VaultEntry
+	if err := v.DB.WithContext(ctx).First(&entry, "token = ?", token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrVaultTokenNotFound
+		}
+		return "", fmt.Errorf("failed to fetch vault entry: %v", err)
+	}
+	return v.open(ctx, &entry)
+}
+
+// seal encrypts value under a new data key and returns the unsaved entry.
+func (v *Vault) seal(ctx context.Context, token, payerID, kind, value string) (*model.VaultEntry, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	// The token and kind are bound to the ciphertext, so rows cannot be swapped
+	ciphertext, err := sealAESGCM(dataKey, []byte(value), []byte(token+"|"+kind))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %v", kind, err)
+	}
+	keyID, wrapped, err := v.KMS.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.VaultEntry{
+		Token:      token,
+		PayerID:    payerID,
+		Kind:       kind,
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		KeyID:      keyID,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// open decrypts a stored entry.
+func (v *Vault) open(ctx context.Context, entry *model.VaultEntry) (string, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(entry.WrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("corrupt vault entry %s: %v", entry.Token, err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(entry.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("corrupt vault entry %s: %v", entry.Token, err)
+	}
+	dataKey, err := v.KMS.UnwrapKey(ctx, entry.KeyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+	value, err := openAESGCM(dataKey, ciphertext, []byte(entry.Token+"|"+entry.Kind))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt vault entry %s: %v", entry.Token, err)
+	}
+	return string(value), nil
+}
+
+// Purge deletes the encrypted value behind token. The token stops working.
+func (v *Vault) Purge(ctx context.Context, tx *gorm.DB, token string) error {
+	if token == "" {
+		return nil
+	}
+	if err := tx.WithContext(ctx).Where("token = ?", token).Delete(&model.VaultEntry{}).Error; err != nil {
+		return fmt.Errorf("failed to purge vault entry: %v", err)
+	}
+	return nil
+}
+
+// Fingerprint returns a keyed hash of value, used to spot duplicates and to
+// compare numbers sent with a payment without decrypting the stored one. A
+// plain hash would be easy to reverse for card numbers.
+func (v *Vault) Fingerprint(kind, value string) string {
+	mac := hmac.New(sha256.New, v.FingerprintKey)
+	mac.Write([]byte(kind + "|" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// LastFour returns the last four characters of a number for display.
+func LastFour(value string) string {
+	if len(value) <= 4 {
+		return value
+	}
+	return value[len(value)-4:]
+}
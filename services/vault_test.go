@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"poc/model"
+	"strings"
+	"testing"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeTestKeyFile writes keys to a key file, the active one first.
+func writeTestKeyFile(t *testing.T, path string, keys ...[]byte) {
+	t.Helper()
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, base64.StdEncoding.EncodeToString(key))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalKMSGeneratesKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.key")
+	first, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("key file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Loading again reuses the generated key
+	second, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.active != second.active {
+		t.Errorf("active key changed from %s to %s on reload", first.active, second.active)
+	}
+}
+
+func TestLocalKMSRejectsBadKeyFiles(t *testing.T) {
+	tests := map[string]string{
+		"not base64":  "not a key\n",
+		"short key":   base64.StdEncoding.EncodeToString([]byte("too short")) + "\n",
+		"only blanks": "\n# comment\n\n",
+	}
+	for name, contents := range tests {
+		path := filepath.Join(t.TempDir(), "vault.key")
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewLocalKMS(path); err == nil {
+			t.Errorf("%s: key file accepted", name)
+		}
+	}
+}
+
+func TestLocalKMSWrapUnwrap(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vault.key")
+	writeTestKeyFile(t, path, newTestKey(t))
+	kms, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey := newTestKey(t)
+	keyID, wrapped, err := kms.WrapKey(ctx, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, dataKey) {
+		t.Fatal("wrapped key contains the data key in the clear")
+	}
+	unwrapped, err := kms.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Error("unwrapped key differs from the original")
+	}
+
+	if _, err := kms.UnwrapKey(ctx, "local-unknown", wrapped); err == nil {
+		t.Error("unwrapped with an unknown key ID")
+	}
+	tampered := append([]byte(nil), wrapped...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := kms.UnwrapKey(ctx, keyID, tampered); err == nil {
+		t.Error("unwrapped a tampered key")
+	}
+	if _, err := kms.UnwrapKey(ctx, keyID, wrapped[:4]); err == nil {
+		t.Error("unwrapped a truncated key")
+	}
+}
+
+func TestVaultRoundTripAcrossRotation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vault.key")
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	writeTestKeyFile(t, path, oldKey)
+	kms, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := NewVault(nil, kms, newTestKey(t))
+
+	entry, err := vault.seal(ctx, "vt_card", "payer-1", "card_number", "4111111111111111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(entry.Ciphertext, "4111111111111111") || entry.KeyID != kms.active {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if value, err := vault.open(ctx, entry); err != nil || value != "4111111111111111" {
+		t.Fatalf("open = %q, %v; want the card number", value, err)
+	}
+
+	// Rotate: the new key goes on top and the old one stays for reading
+	writeTestKeyFile(t, path, newKey, oldKey)
+	rotated, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.KMS = rotated
+	if value, err := vault.open(ctx, entry); err != nil || value != "4111111111111111" {
+		t.Errorf("open after rotation = %q, %v; want the card number", value, err)
+	}
+	fresh, err := vault.seal(ctx, "vt_card2", "payer-1", "card_number", "5555555555554444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.KeyID == entry.KeyID {
+		t.Error("new entries are still wrapped with the retired key")
+	}
+
+	// Dropping the old key makes its entries unreadable
+	writeTestKeyFile(t, path, newKey)
+	if vault.KMS, err = NewLocalKMS(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.open(ctx, entry); err == nil {
+		t.Error("opened an entry whose key was removed")
+	}
+}
+
+func TestVaultDetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vault.key")
+	writeTestKeyFile(t, path, newTestKey(t))
+	kms, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := NewVault(nil, kms, newTestKey(t))
+	entry, err := vault.seal(ctx, "vt_card", "payer-1", "card_number", "4111111111111111")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(encoded string) string {
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		raw[len(raw)-1] ^= 1
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	tests := map[string]func(e *model.VaultEntry){
+		"ciphertext":  func(e *model.VaultEntry) { e.Ciphertext = flip(e.Ciphertext) },
+		"wrapped key": func(e *model.VaultEntry) { e.WrappedKey = flip(e.WrappedKey) },
+		"token":       func(e *model.VaultEntry) { e.Token = "vt_other" },
+		"kind":        func(e *model.VaultEntry) { e.Kind = "account_number" },
+		"not base64":  func(e *model.VaultEntry) { e.Ciphertext = "%%%" },
+	}
+	for name, tamper := range tests {
+		tampered := *entry
+		tamper(&tampered)
+		if _, err := vault.open(ctx, &tampered); err == nil {
+			t.Errorf("%s: tampered entry opened", name)
+		}
+	}
+}
+
+func TestFingerprintKeySurvivesRotation(t *testing.T) {
+	dir := t.TempDir()
+	vaultPath, fingerprintPath := filepath.Join(dir, "vault.key"), filepath.Join(dir, "fingerprint.key")
+	oldKey := newTestKey(t)
+	writeTestKeyFile(t, vaultPath, oldKey)
+	kms, err := NewLocalKMS(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first load pins the key earlier versions derived
+	key, err := LoadFingerprintKey(fingerprintPath, kms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, kms.DeriveKey("payment-method-fingerprint")) {
+		t.Error("pinned key differs from the previously derived key")
+	}
+	before := NewVault(nil, kms, key).Fingerprint("card_number", "4111111111111111")
+
+	writeTestKeyFile(t, vaultPath, newTestKey(t), oldKey)
+	rotated, err := NewLocalKMS(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = LoadFingerprintKey(fingerprintPath, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := NewVault(nil, rotated, key).Fingerprint("card_number", "4111111111111111"); after != before {
+		t.Error("fingerprint changed after rotating the vault key")
+	}
+
+	if err := os.WriteFile(fingerprintPath, []byte("garbage\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFingerprintKey(fingerprintPath, rotated); err == nil {
+		t.Error("accepted a corrupt fingerprint key file")
+	}
+}
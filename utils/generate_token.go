@@ -1,31 +1,88 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// Secret key for signing the token (use a secure key in production)
-var secretKey = []byte("your-secret-key")
+// TokenClaims are the claims carried by an access token. The registered ID
+// claim (jti) identifies the token in the revocation list.
+type TokenClaims struct {
+	UserID    string `json:"UserID"`
+	SessionID string `json:"sid"`
+	Role      string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// GenerateToken generates an access token carrying the user's role for their
+// session that expires after ttl, signed with the key ring's active key.
+func GenerateToken(userID, sessionID, role string, ttl time.Duration) (string, *TokenClaims, error) {
+	ring, err := CurrentKeyRing()
+	if err != nil {
+		return "", nil, err
+	}
+	key := ring.Active()
 
-// GenerateToken generates a JWT token
-func GenerateToken(userID string) (string, error) {
 	// Create the claims
-	claims := jwt.MapClaims{
-		"UserID": userID,
-		"exp":    time.Now().Add(time.Hour * 72).Unix(), // Token expires in 72 hours
+	now := time.Now()
+	claims := &TokenClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		Role:      role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        GenerateUniqueID(),
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
 	}
 
-	// Create a new token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Create a new token; kid tells verifiers which key signed it
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
 
-	// Sign the token with the secret key
-	signedToken, err := token.SignedString(secretKey)
+	// Sign the token with the active key
+	signedToken, err := token.SignedString(key.PrivateKey)
 	if err != nil {
-		return "", fmt.Errorf("Failed to generate token: %v", err)
+		return "", nil, fmt.Errorf("Failed to generate token: %v", err)
 	}
 
-	return signedToken, nil
+	return signedToken, claims, nil
+}
+
+// ParseToken verifies an access token's signature and expiry and returns its
+// claims. The kid header picks the key from the key ring, so tokens signed
+// with a retired key keep verifying while it stays in the ring.
+func ParseToken(tokenString string) (*TokenClaims, error) {
+	ring, err := CurrentKeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &TokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ring.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// Validate the signing method against the key, never the token's say-so
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if claims.UserID == "" || claims.ID == "" {
+		return nil, errors.New("invalid token claims")
+	}
+	return claims, nil
 }
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is one key in the key ring. Keys without a private half (or
+// secret) can only verify tokens, which is how retired keys are kept around
+// until the tokens they signed have expired.
+type SigningKey struct {
+	ID         string      // Published as the kid header
+	Algorithm  string      // HS256, RS256 or ES256
+	PrivateKey interface{} // []byte secret, *rsa.PrivateKey or *ecdsa.PrivateKey; nil for verify-only keys
+	PublicKey  interface{} // []byte secret, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// NewSigningKey builds a key for the algorithm from a parsed PEM key or an
+// HMAC secret, checking that the key material matches the algorithm.
+func NewSigningKey(id, algorithm string, key interface{}) (*SigningKey, error) {
+	if id == "" {
+		return nil, errors.New("signing key id is required")
+	}
+	k := &SigningKey{ID: id, Algorithm: algorithm}
+	switch algorithm {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok || len(secret) < 32 {
+			return nil, fmt.Errorf("key %s: HS256 needs a secret of at least 32 bytes", id)
+		}
+		k.PrivateKey, k.PublicKey = secret, secret
+	case "RS256":
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			k.PrivateKey, k.PublicKey = key, &key.PublicKey
+		case *rsa.PublicKey:
+			k.PublicKey = key
+		default:
+			return nil, fmt.Errorf("key %s: RS256 needs an RSA key", id)
+		}
+	case "ES256":
+		switch key := key.(type) {
+		case *ecdsa.PrivateKey:
+			k.PrivateKey, k.PublicKey = key, &key.PublicKey
+		case *ecdsa.PublicKey:
+			k.PublicKey = key
+		default:
+			return nil, fmt.Errorf("key %s: ES256 needs an ECDSA key", id)
+		}
+		if k.PublicKey.(*ecdsa.PublicKey).Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: ES256 needs a P-256 key", id)
+		}
+	default:
+		return nil, fmt.Errorf("key %s: unsupported algorithm %q", id, algorithm)
+	}
+	return k, nil
+}
+
+// CanSign reports whether the key has the private half needed to sign.
+func (k *SigningKey) CanSign() bool {
+	return k.PrivateKey != nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeyRing holds every key tokens may be verified with and the one new tokens
+// are signed with.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	order  []string
+}
+
+// NewKeyRing builds a key ring that signs with the key named activeID.
+func NewKeyRing(activeID string, keys ...*SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string]*SigningKey, len(keys))}
+	for _, key := range keys {
+		if _, exists := ring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+		ring.keys[key.ID] = key
+		ring.order = append(ring.order, key.ID)
+	}
+	active, ok := ring.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q is not in the key ring", activeID)
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeID)
+	}
+	ring.active = active
+	return ring, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (r *KeyRing) Active() *SigningKey {
+	return r.active
+}
+
+// Lookup returns the key with the given kid.
+func (r *KeyRing) Lookup(id string) (*SigningKey, bool) {
+	key, ok := r.keys[id]
+	return key, ok
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public halves of the asymmetric keys. HMAC secrets are
+// never published.
+func (r *KeyRing) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range r.order {
+		key := r.keys[id]
+		jwk := JWK{Use: "sig", Algorithm: key.Algorithm, KeyID: key.ID}
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.KeyType = "EC"
+			jwk.Curve = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+var (
+	keyRingMu sync.RWMutex
+	keyRing   *KeyRing
+)
+
+// SetKeyRing installs the key ring used to sign and verify tokens. It is
+// called at startup with the keys loaded by initializer.LoadSigningKeys.
+func SetKeyRing(ring *KeyRing) {
+	keyRingMu.Lock()
+	defer keyRingMu.Unlock()
+	keyRing = ring
+}
+
+// CurrentKeyRing returns the installed key ring, or an error if none is set.
+func CurrentKeyRing() (*KeyRing, error) {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+	if keyRing == nil {
+		return nil, errors.New("no token signing keys configured")
+	}
+	return keyRing, nil
+}
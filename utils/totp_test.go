@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key from RFC 6238 appendix B, base32 encoded.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// The RFC lists 8-digit codes; a 6-digit code is the last six of them.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, tc := range rfc6238Vectors {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tc.unix, err)
+		}
+		if want := tc.code[len(tc.code)-TOTPDigits:]; got != want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tc.unix, got, want)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	upper, err := TOTPCode(rfc6238Secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Errorf("lowercase secret gave %s, want %s", lower, upper)
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		skew   int64
+		ok     bool
+	}{
+		{"current step", 0, 0, true},
+		{"previous step without skew", -1, 0, false},
+		{"previous step within skew", -1, 1, true},
+		{"next step within skew", 1, 1, true},
+		{"two steps back outside skew", -2, 1, false},
+		{"two steps ahead outside skew", 2, 1, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := TOTPCode(rfc6238Secret, current+tc.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := ValidateTOTP(rfc6238Secret, code, now, tc.skew)
+			if ok != tc.ok {
+				t.Fatalf("ValidateTOTP ok = %v, want %v", ok, tc.ok)
+			}
+			if ok && step != current+tc.offset {
+				t.Errorf("ValidateTOTP step = %d, want %d", step, current+tc.offset)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "1287082", "abcdef"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, now, 1); ok {
+			t.Errorf("ValidateTOTP accepted %q", code)
+		}
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, " 287082 ", now, 0); !ok {
+		t.Error("ValidateTOTP rejected a code with surrounding spaces")
+	}
+}
+
+// A code always maps to the same step, which is what callers remember in
+// LastUsedStep to refuse a replay.
+func TestValidateTOTPReplayReturnsSameStep(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := TOTPCode(rfc6238Secret, TOTPStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, ok := ValidateTOTP(rfc6238Secret, code, now, 1)
+	if !ok {
+		t.Fatal("ValidateTOTP rejected a current code")
+	}
+	again, ok := ValidateTOTP(rfc6238Secret, code, now.Add(TOTPPeriod), 1)
+	if !ok || again != first {
+		t.Errorf("replayed code gave step %d (ok %v), want %d", again, ok, first)
+	}
+}